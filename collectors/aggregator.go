@@ -0,0 +1,246 @@
+package collectors
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+)
+
+// FlowAggregationBucket is the granularity of the windows of the aggregator
+const FlowAggregationBucket = 10 * time.Second
+
+// Actions of the aggregated flows
+const (
+	FlowAccepted = "accepted"
+	FlowRejected = "rejected"
+)
+
+// Policy indexes of the ends of the flows that are not PUs handled by the
+// resolver
+const (
+	FlowExternal = "external"
+	FlowUnknown  = "unknown"
+)
+
+// FlowKey identifies the flows rolled up together
+type FlowKey struct {
+	SourcePolicy      string
+	DestinationPolicy string
+	Port              uint16
+	Action            string
+}
+
+// FlowAggregate is the number of flows of a key over a window
+type FlowAggregate struct {
+	FlowKey
+	Flows uint64
+}
+
+// TopFlows are the keys with the most flows over a window
+type TopFlows struct {
+	Window   string
+	Since    time.Time
+	Talkers  []*FlowAggregate
+	Rejected []*FlowAggregate
+}
+
+// flowBucket holds the flows of a FlowAggregationBucket starting at start
+type flowBucket struct {
+	start time.Time
+	flows map[FlowKey]uint64
+}
+
+// FlowAggregator rolls up the flows per source policy index, destination
+// policy index, destination port and action in buckets of
+// FlowAggregationBucket, kept for the retention. The policy indexes come from
+// the lookup, once it is set. All the events are passed on to the next
+// collector.
+type FlowAggregator struct {
+	retention time.Duration
+	buckets   []*flowBucket
+	lookup    PULookup
+	next      collector.EventCollector
+	sync.Mutex
+}
+
+// NewFlowAggregator creates a collector aggregating the flows before passing
+// them to next. The retention is the longest window that can be queried.
+func NewFlowAggregator(retention time.Duration, next collector.EventCollector) (*FlowAggregator, error) {
+
+	if retention < FlowAggregationBucket {
+		return nil, fmt.Errorf("flow retention must be at least %s", FlowAggregationBucket)
+	}
+
+	return &FlowAggregator{
+		retention: retention,
+		buckets:   []*flowBucket{},
+		next:      next,
+	}, nil
+}
+
+// SetPULookup sets where the policy indexes of the PUs are found. The policy
+// resolver is created after the collector, so it is set later.
+func (a *FlowAggregator) SetPULookup(lookup PULookup) {
+
+	a.Lock()
+	a.lookup = lookup
+	a.Unlock()
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (a *FlowAggregator) CollectFlowEvent(record *collector.FlowRecord) {
+
+	a.Lock()
+	lookup := a.lookup
+	a.Unlock()
+
+	key := FlowKey{
+		SourcePolicy:      flowPolicy(lookup, record.Source),
+		DestinationPolicy: flowPolicy(lookup, record.Destination),
+		Action:            FlowRejected,
+	}
+	if record.Destination != nil {
+		key.Port = record.Destination.Port
+	}
+	if record.Action.Accepted() {
+		key.Action = FlowAccepted
+	}
+
+	count := uint64(1)
+	if record.Count > 0 {
+		count = uint64(record.Count)
+	}
+
+	a.add(time.Now(), key, count)
+
+	a.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (a *FlowAggregator) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	a.next.CollectContainerEvent(record)
+}
+
+// Top returns the keys with the most flows and the rejected keys with the most
+// flows over the window, up to limit each. A limit of 0 returns all the keys.
+// The window is rounded up to FlowAggregationBucket.
+func (a *FlowAggregator) Top(window time.Duration, limit int) (*TopFlows, error) {
+
+	if window <= 0 || window > a.retention {
+		return nil, fmt.Errorf("window must be positive and at most %s", a.retention)
+	}
+
+	now := time.Now()
+	since := now.Add(-window).Truncate(FlowAggregationBucket)
+
+	totals := map[FlowKey]uint64{}
+
+	a.Lock()
+	a.prune(now)
+	for _, bucket := range a.buckets {
+		if bucket.start.Before(since) {
+			continue
+		}
+		for key, flows := range bucket.flows {
+			totals[key] += flows
+		}
+	}
+	a.Unlock()
+
+	top := &TopFlows{
+		Window:   window.String(),
+		Since:    since,
+		Talkers:  []*FlowAggregate{},
+		Rejected: []*FlowAggregate{},
+	}
+
+	for key, flows := range totals {
+		aggregate := &FlowAggregate{FlowKey: key, Flows: flows}
+		top.Talkers = append(top.Talkers, aggregate)
+		if key.Action == FlowRejected {
+			top.Rejected = append(top.Rejected, aggregate)
+		}
+	}
+
+	top.Talkers = sortFlowAggregates(top.Talkers, limit)
+	top.Rejected = sortFlowAggregates(top.Rejected, limit)
+
+	return top, nil
+}
+
+// add counts flows of a key in the bucket of now
+func (a *FlowAggregator) add(now time.Time, key FlowKey, count uint64) {
+
+	start := now.Truncate(FlowAggregationBucket)
+
+	a.Lock()
+	defer a.Unlock()
+
+	if n := len(a.buckets); n == 0 || !a.buckets[n-1].start.Equal(start) {
+		a.buckets = append(a.buckets, &flowBucket{start: start, flows: map[FlowKey]uint64{}})
+		a.prune(now)
+	}
+
+	a.buckets[len(a.buckets)-1].flows[key] += count
+}
+
+// prune removes the buckets older than the retention. The caller must hold
+// the lock.
+func (a *FlowAggregator) prune(now time.Time) {
+
+	oldest := now.Add(-a.retention).Truncate(FlowAggregationBucket)
+
+	i := 0
+	for i < len(a.buckets) && a.buckets[i].start.Before(oldest) {
+		i++
+	}
+
+	a.buckets = a.buckets[i:]
+}
+
+// flowPolicy returns the policy index of an end of a flow
+func flowPolicy(lookup PULookup, ep *collector.EndPoint) string {
+
+	if ep == nil || ep.Type != collector.EnpointTypePU {
+		return FlowExternal
+	}
+
+	pu := lookupPU(lookup, ep.ID)
+	if pu == nil || pu.PolicyIndex == "" {
+		return FlowUnknown
+	}
+
+	return pu.PolicyIndex
+}
+
+// sortFlowAggregates sorts the aggregates from the most flows and keeps up to
+// limit of them
+func sortFlowAggregates(aggregates []*FlowAggregate, limit int) []*FlowAggregate {
+
+	sort.Slice(aggregates, func(i, j int) bool {
+		a, b := aggregates[i], aggregates[j]
+		if a.Flows != b.Flows {
+			return a.Flows > b.Flows
+		}
+		if a.SourcePolicy != b.SourcePolicy {
+			return a.SourcePolicy < b.SourcePolicy
+		}
+		if a.DestinationPolicy != b.DestinationPolicy {
+			return a.DestinationPolicy < b.DestinationPolicy
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Action < b.Action
+	})
+
+	if limit > 0 && len(aggregates) > limit {
+		aggregates = aggregates[:limit]
+	}
+
+	return aggregates
+}
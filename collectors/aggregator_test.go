@@ -0,0 +1,186 @@
+package collectors
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// describeAggregates returns the aggregates as "source>destination:port action flows"
+func describeAggregates(aggregates []*FlowAggregate) []string {
+
+	described := []string{}
+	for _, a := range aggregates {
+		described = append(described, fmt.Sprintf("%s>%s:%d %s %d", a.SourcePolicy, a.DestinationPolicy, a.Port, a.Action, a.Flows))
+	}
+
+	return described
+}
+
+func TestFlowAggregatorKeys(t *testing.T) {
+
+	next := &recordingCollector{}
+	a, err := NewFlowAggregator(time.Hour, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.SetPULookup(fakeLookup{"web": {PolicyIndex: "frontend"}, "db": {PolicyIndex: "database"}, "new": {}})
+
+	pu := func(id string, port uint16) *collector.EndPoint {
+		return &collector.EndPoint{ID: id, IP: "10.0.0.1", Port: port, Type: collector.EnpointTypePU}
+	}
+	external := &collector.EndPoint{IP: "192.0.2.1", Port: 443, Type: collector.EndPointTypeExternalIP}
+
+	records := []*collector.FlowRecord{
+		{Source: pu("web", 5000), Destination: pu("db", 5432), Action: policy.Accept, Count: 3},
+		// The source port is not part of the key
+		{Source: pu("web", 5001), Destination: pu("db", 5432), Action: policy.Accept | policy.Log},
+		{Source: pu("web", 5000), Destination: pu("db", 5432), Action: policy.Reject},
+		{Source: external, Destination: pu("web", 80), Action: policy.Reject, Count: 2},
+		{Source: pu("gone", 5000), Destination: pu("new", 8080), Action: policy.Accept},
+		{Source: pu("web", 5000), Action: policy.Reject},
+	}
+	for _, record := range records {
+		a.CollectFlowEvent(record)
+	}
+
+	if len(next.flows) != len(records) {
+		t.Errorf("flows passed on = %d, want %d", len(next.flows), len(records))
+	}
+
+	top, err := a.Top(time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	talkers := []string{
+		"frontend>database:5432 accepted 4",
+		"external>frontend:80 rejected 2",
+		"frontend>database:5432 rejected 1",
+		"frontend>external:0 rejected 1",
+		"unknown>unknown:8080 accepted 1",
+	}
+	if got := describeAggregates(top.Talkers); fmt.Sprint(got) != fmt.Sprint(talkers) {
+		t.Errorf("talkers = %q, want %q", got, talkers)
+	}
+
+	rejected := []string{
+		"external>frontend:80 rejected 2",
+		"frontend>database:5432 rejected 1",
+		"frontend>external:0 rejected 1",
+	}
+	if got := describeAggregates(top.Rejected); fmt.Sprint(got) != fmt.Sprint(rejected) {
+		t.Errorf("rejected = %q, want %q", got, rejected)
+	}
+}
+
+func TestFlowAggregatorTop(t *testing.T) {
+
+	// The flows are counted from the oldest, as they are reported
+	now := time.Now()
+	counts := []struct {
+		age   time.Duration
+		key   FlowKey
+		flows uint64
+	}{
+		{age: 2 * time.Hour, key: FlowKey{"d", "a", 443, FlowRejected}, flows: 100},
+		{age: 3 * time.Minute, key: FlowKey{"c", "a", 443, FlowRejected}, flows: 10},
+		{key: FlowKey{"a", "b", 80, FlowAccepted}, flows: 5},
+		{key: FlowKey{"a", "c", 80, FlowAccepted}, flows: 2},
+		{key: FlowKey{"b", "c", 22, FlowRejected}, flows: 2},
+		{key: FlowKey{"c", "a", 443, FlowRejected}, flows: 1},
+	}
+
+	tests := []struct {
+		name     string
+		window   time.Duration
+		limit    int
+		talkers  []string
+		rejected []string
+	}{
+		{
+			name:     "all",
+			window:   time.Minute,
+			talkers:  []string{"a>b:80 accepted 5", "a>c:80 accepted 2", "b>c:22 rejected 2", "c>a:443 rejected 1"},
+			rejected: []string{"b>c:22 rejected 2", "c>a:443 rejected 1"},
+		},
+		{
+			name:     "limited",
+			window:   time.Minute,
+			limit:    1,
+			talkers:  []string{"a>b:80 accepted 5"},
+			rejected: []string{"b>c:22 rejected 2"},
+		},
+		{
+			name:     "longer window",
+			window:   5 * time.Minute,
+			limit:    2,
+			talkers:  []string{"c>a:443 rejected 11", "a>b:80 accepted 5"},
+			rejected: []string{"c>a:443 rejected 11", "b>c:22 rejected 2"},
+		},
+		{
+			name:     "whole retention",
+			window:   time.Hour,
+			limit:    1,
+			talkers:  []string{"c>a:443 rejected 11"},
+			rejected: []string{"c>a:443 rejected 11"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			a, err := NewFlowAggregator(time.Hour, &recordingCollector{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range counts {
+				a.add(now.Add(-c.age), c.key, c.flows)
+			}
+
+			top, err := a.Top(tt.window, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeAggregates(top.Talkers); fmt.Sprint(got) != fmt.Sprint(tt.talkers) {
+				t.Errorf("talkers = %q, want %q", got, tt.talkers)
+			}
+			if got := describeAggregates(top.Rejected); fmt.Sprint(got) != fmt.Sprint(tt.rejected) {
+				t.Errorf("rejected = %q, want %q", got, tt.rejected)
+			}
+			if top.Window != tt.window.String() || top.Since.After(time.Now().Add(-tt.window)) {
+				t.Errorf("window = %s since %s, want %s", top.Window, top.Since, tt.window)
+			}
+		})
+	}
+}
+
+func TestFlowAggregatorLimits(t *testing.T) {
+
+	if _, err := NewFlowAggregator(time.Second, &recordingCollector{}); err == nil {
+		t.Errorf("expected an error for a retention shorter than a bucket")
+	}
+
+	a, err := NewFlowAggregator(time.Hour, &recordingCollector{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, window := range []time.Duration{0, -time.Minute, 2 * time.Hour} {
+		if _, err = a.Top(window, 10); err == nil {
+			t.Errorf("expected an error for a window of %s", window)
+		}
+	}
+
+	// Old buckets are pruned as new ones are added
+	now := time.Now()
+	a.add(now.Add(-2*time.Hour), FlowKey{"a", "b", 80, FlowAccepted}, 1)
+	a.add(now.Add(-30*time.Minute), FlowKey{"a", "b", 80, FlowAccepted}, 1)
+	a.add(now, FlowKey{"a", "b", 80, FlowAccepted}, 1)
+	if len(a.buckets) != 2 {
+		t.Errorf("buckets = %d, want 2", len(a.buckets))
+	}
+}
@@ -0,0 +1,72 @@
+package collectors
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// AuditCollector reports the flows accepted by a reject rule in audit mode as
+// flows that would have been rejected. All the events are passed on to the
+// next collector.
+type AuditCollector struct {
+	next collector.EventCollector
+}
+
+// NewAuditCollector creates a collector reporting the audited flows before
+// passing all the events to next
+func NewAuditCollector(next collector.EventCollector) *AuditCollector {
+
+	return &AuditCollector{
+		next: next,
+	}
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (c *AuditCollector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	if policyID, ok := WouldReject(record); ok {
+		zap.L().Warn("Flow would have been rejected",
+			zap.String("contextID", record.ContextID),
+			zap.String("policyID", policyID),
+			zap.String("source", endpoint(record.Source)),
+			zap.String("destination", endpoint(record.Destination)),
+			zap.Int("count", record.Count),
+		)
+	}
+
+	c.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (c *AuditCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.next.CollectContainerEvent(record)
+}
+
+// WouldReject returns true if a flow was accepted by a reject rule in audit
+// mode, together with the policy ID of that rule
+func WouldReject(record *collector.FlowRecord) (string, bool) {
+
+	if !record.Action.Accepted() {
+		return "", false
+	}
+
+	return policyexample.ParseAuditPolicyID(record.PolicyID)
+}
+
+// endpoint returns a short description of the end of a flow
+func endpoint(ep *collector.EndPoint) string {
+
+	if ep == nil {
+		return ""
+	}
+
+	if ep.ID != "" {
+		return fmt.Sprintf("%s (%s:%d)", ep.ID, ep.IP, ep.Port)
+	}
+
+	return fmt.Sprintf("%s:%d", ep.IP, ep.Port)
+}
@@ -0,0 +1,151 @@
+package collectors
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// recordingCollector records the events passed on by a collector
+type recordingCollector struct {
+	flows      []*collector.FlowRecord
+	containers []*collector.ContainerRecord
+}
+
+func (c *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	c.flows = append(c.flows, record)
+}
+
+func (c *recordingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	c.containers = append(c.containers, record)
+}
+
+// fakeController is a controller accepting every policy
+type fakeController struct {
+	controller.TriremeController
+}
+
+func (c *fakeController) Enforce(ctx context.Context, puID string, p *policy.PUPolicy, runtime *policy.PURuntime) error {
+	return nil
+}
+
+func (c *fakeController) UpdatePolicy(ctx context.Context, puID string, p *policy.PUPolicy, runtime *policy.PURuntime) error {
+	return nil
+}
+
+// matchingACL returns the first ACL of a protocol whose address contains an IP
+func matchingACL(t *testing.T, acls policy.IPRuleList, ip, protocol string) policy.IPRule {
+
+	for _, rule := range acls {
+		_, network, err := net.ParseCIDR(rule.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.EqualFold(rule.Protocol, protocol) && network.Contains(net.ParseIP(ip)) {
+			return rule
+		}
+	}
+
+	t.Fatalf("no ACL matches %s/%s in %+v", ip, protocol, acls)
+	return policy.IPRule{}
+}
+
+func TestWouldReject(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		action   policy.ActionType
+		policyID string
+		rejected string
+		ok       bool
+	}{
+		{name: "audited", action: policy.Accept | policy.Log, policyID: policyexample.AuditPolicyID("ssh"), rejected: "ssh", ok: true},
+		{name: "accepted", action: policy.Accept, policyID: "http"},
+		{name: "rejected", action: policy.Reject, policyID: policyexample.AuditPolicyID("ssh")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			next := &recordingCollector{}
+			c := NewAuditCollector(next)
+
+			record := &collector.FlowRecord{Action: tt.action, PolicyID: tt.policyID}
+			rejected, ok := WouldReject(record)
+			if rejected != tt.rejected || ok != tt.ok {
+				t.Errorf("WouldReject = %q, %t, want %q, %t", rejected, ok, tt.rejected, tt.ok)
+			}
+
+			// All the events are passed on
+			c.CollectFlowEvent(record)
+			c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "pu"})
+			if len(next.flows) != 1 || next.flows[0] != record || len(next.containers) != 1 {
+				t.Errorf("next got %d flows and %d container events, want 1 and 1", len(next.flows), len(next.containers))
+			}
+		})
+	}
+}
+
+func TestAuditCollectorReportsTheDefaultDeny(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "collectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	file := filepath.Join(dir, "policy.yaml")
+	content := `
+web:
+  NetworkACLs:
+    - {Address: 10.0.0.0/8, Port: "22", Protocol: tcp, Policy: {Action: reject, PolicyID: ssh}}
+`
+	if err = ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := policyexample.NewCustomPolicyResolver(&fakeController{}, nil, file, false, policyexample.OptionAudit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := policy.NewPURuntime("web", 1, "", policy.NewTagStoreFromMap(map[string]string{"@usr:PolicyIndex": "web"}), policy.ExtendedMap{}, common.LinuxProcessPU, nil)
+	if err = p.HandlePUEvent(context.Background(), "web", common.EventStart, runtime); err != nil {
+		t.Fatal(err)
+	}
+	enforced, err := p.PUPolicy("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No rule of the policy file matches a flow from the Internet, which is
+	// reported with the rule of the enforced policy that accepted it
+	rule := matchingACL(t, enforced.NetworkACLs, "192.0.2.1", "tcp")
+	record := &collector.FlowRecord{
+		ContextID:   "web",
+		Count:       1,
+		Source:      &collector.EndPoint{IP: "192.0.2.1", Port: 40000, Type: collector.EndPointTypeExternalIP},
+		Destination: &collector.EndPoint{ID: "web", IP: "10.0.0.2", Port: 8080, Type: collector.EnpointTypePU},
+		Action:      rule.Policy.Action,
+		PolicyID:    rule.Policy.PolicyID,
+	}
+
+	next := &recordingCollector{}
+	NewAuditCollector(next).CollectFlowEvent(record)
+
+	if rejected, ok := WouldReject(record); !ok || rejected != "default" {
+		t.Errorf("WouldReject = %q, %t, want the default deny", rejected, ok)
+	}
+	if len(next.flows) != 1 || next.flows[0] != record {
+		t.Errorf("flows passed on = %d, want the flow", len(next.flows))
+	}
+}
@@ -0,0 +1,187 @@
+package collectors
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// Types of the entries of the flow log
+const (
+	FlowLogFlow      = "flow"
+	FlowLogContainer = "container"
+)
+
+// PULookup returns the status of the PUs handled by the daemon
+type PULookup interface {
+	PU(puID string) (*policyexample.PUStatus, error)
+}
+
+// FlowLogEndpoint is an end of a flow in the flow log
+type FlowLogEndpoint struct {
+	ID   string `json:",omitempty"`
+	IP   string
+	Port uint16
+	Name string   `json:",omitempty"`
+	Tags []string `json:",omitempty"`
+}
+
+// FlowLogEntry is a line of the flow log. Flow entries have a source, a
+// destination and an action, container entries an event.
+type FlowLogEntry struct {
+	Time        time.Time
+	Type        string
+	ContextID   string
+	Count       int              `json:",omitempty"`
+	Source      *FlowLogEndpoint `json:",omitempty"`
+	Destination *FlowLogEndpoint `json:",omitempty"`
+	Action      string           `json:",omitempty"`
+	PolicyID    string           `json:",omitempty"`
+	DropReason  string           `json:",omitempty"`
+	Encrypted   bool             `json:",omitempty"`
+	Event       string           `json:",omitempty"`
+	IPAddress   string           `json:",omitempty"`
+	Name        string           `json:",omitempty"`
+	Tags        []string         `json:",omitempty"`
+}
+
+// FlowLogCollector writes every flow and container event as a line of JSON.
+// The names and tags of the PUs come from the lookup, once it is set. All
+// the events are passed on to the next collector.
+type FlowLogCollector struct {
+	encoder *json.Encoder
+	lookup  PULookup
+	next    collector.EventCollector
+	sync.Mutex
+}
+
+// NewFlowLogCollector creates a collector writing the events to writer before
+// passing them to next
+func NewFlowLogCollector(writer io.Writer, next collector.EventCollector) *FlowLogCollector {
+
+	return &FlowLogCollector{
+		encoder: json.NewEncoder(writer),
+		next:    next,
+	}
+}
+
+// SetPULookup sets where the names and the tags of the PUs are found. The
+// policy resolver is created after the collector, so it is set later.
+func (c *FlowLogCollector) SetPULookup(lookup PULookup) {
+
+	c.Lock()
+	c.lookup = lookup
+	c.Unlock()
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (c *FlowLogCollector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	entry := &FlowLogEntry{
+		Time:        time.Now(),
+		Type:        FlowLogFlow,
+		ContextID:   record.ContextID,
+		Count:       record.Count,
+		Source:      newFlowLogEndpoint(c.getPULookup(), record, record.Source),
+		Destination: newFlowLogEndpoint(c.getPULookup(), record, record.Destination),
+		Action:      policyexample.ActionName(record.Action),
+		PolicyID:    record.PolicyID,
+		DropReason:  record.DropReason,
+		Encrypted:   record.Encrypted,
+	}
+
+	c.write(entry)
+
+	c.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (c *FlowLogCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	entry := &FlowLogEntry{
+		Time:      time.Now(),
+		Type:      FlowLogContainer,
+		ContextID: record.ContextID,
+		Event:     record.Event,
+		IPAddress: record.IPAddress,
+	}
+
+	if record.Tags != nil {
+		entry.Tags = record.Tags.GetSlice()
+	}
+	if pu := lookupPU(c.getPULookup(), record.ContextID); pu != nil {
+		entry.Name = pu.Name
+	}
+
+	c.write(entry)
+
+	c.next.CollectContainerEvent(record)
+}
+
+// getPULookup returns the lookup of the PUs, or nil if it is not set yet
+func (c *FlowLogCollector) getPULookup() PULookup {
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lookup
+}
+
+// newFlowLogEndpoint describes an end of a flow. The tags of the flow record
+// are the ones of the PU reporting it.
+func newFlowLogEndpoint(lookup PULookup, record *collector.FlowRecord, ep *collector.EndPoint) *FlowLogEndpoint {
+
+	if ep == nil {
+		return nil
+	}
+
+	endpoint := &FlowLogEndpoint{
+		IP:   ep.IP,
+		Port: ep.Port,
+	}
+
+	if ep.Type != collector.EnpointTypePU {
+		return endpoint
+	}
+
+	endpoint.ID = ep.ID
+	if pu := lookupPU(lookup, ep.ID); pu != nil {
+		endpoint.Name = pu.Name
+		endpoint.Tags = pu.Tags
+	} else if ep.ID == record.ContextID && record.Tags != nil {
+		endpoint.Tags = record.Tags.GetSlice()
+	}
+
+	return endpoint
+}
+
+// lookupPU returns the status of a PU, or nil if it is unknown
+func lookupPU(lookup PULookup, puID string) *policyexample.PUStatus {
+
+	if lookup == nil || puID == "" {
+		return nil
+	}
+
+	pu, err := lookup.PU(puID)
+	if err != nil {
+		return nil
+	}
+
+	return pu
+}
+
+// write writes an entry as a line of JSON
+func (c *FlowLogCollector) write(entry *FlowLogEntry) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.encoder.Encode(entry); err != nil {
+		zap.L().Error("Unable to write flow log", zap.Error(err))
+	}
+}
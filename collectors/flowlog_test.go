@@ -0,0 +1,176 @@
+package collectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// readFlowLog decodes the single line written to the flow log and checks
+// that it has a time
+func readFlowLog(t *testing.T, buf *bytes.Buffer) *FlowLogEntry {
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("flow log = %q, want one line", buf.String())
+	}
+
+	entry := &FlowLogEntry{}
+	if err := json.Unmarshal([]byte(lines[0]), entry); err != nil {
+		t.Fatalf("invalid flow log line %q: %s", lines[0], err)
+	}
+	if time.Since(entry.Time) > time.Minute {
+		t.Errorf("time = %s, want now", entry.Time)
+	}
+	entry.Time = time.Time{}
+
+	return entry
+}
+
+func TestFlowLogCollectorFlows(t *testing.T) {
+
+	lookup := fakeLookup{
+		"web": {Name: "web-1", Tags: []string{"app=web"}},
+		"db":  {Name: "db-1", Tags: []string{"app=db"}},
+	}
+
+	pu := func(id string, port uint16) *collector.EndPoint {
+		return &collector.EndPoint{ID: id, IP: "10.0.0.1", Port: port, Type: collector.EnpointTypePU}
+	}
+	external := &collector.EndPoint{IP: "192.0.2.1", Port: 40000, Type: collector.EndPointTypeExternalIP}
+
+	tests := []struct {
+		name   string
+		record *collector.FlowRecord
+		want   *FlowLogEntry
+	}{
+		{
+			name: "between PUs",
+			record: &collector.FlowRecord{
+				ContextID:   "db",
+				Count:       2,
+				Source:      pu("web", 5000),
+				Destination: pu("db", 5432),
+				Action:      policy.Accept | policy.Log,
+				PolicyID:    "web-to-db",
+				Encrypted:   true,
+			},
+			want: &FlowLogEntry{
+				Type:        FlowLogFlow,
+				ContextID:   "db",
+				Count:       2,
+				Source:      &FlowLogEndpoint{ID: "web", IP: "10.0.0.1", Port: 5000, Name: "web-1", Tags: []string{"app=web"}},
+				Destination: &FlowLogEndpoint{ID: "db", IP: "10.0.0.1", Port: 5432, Name: "db-1", Tags: []string{"app=db"}},
+				Action:      "accept|log",
+				PolicyID:    "web-to-db",
+				Encrypted:   true,
+			},
+		},
+		{
+			name: "from an external address",
+			record: &collector.FlowRecord{
+				ContextID:   "web",
+				Count:       1,
+				Source:      external,
+				Destination: pu("web", 80),
+				Action:      policy.Reject,
+				PolicyID:    "default",
+				DropReason:  "policy",
+			},
+			want: &FlowLogEntry{
+				Type:        FlowLogFlow,
+				ContextID:   "web",
+				Count:       1,
+				Source:      &FlowLogEndpoint{IP: "192.0.2.1", Port: 40000},
+				Destination: &FlowLogEndpoint{ID: "web", IP: "10.0.0.1", Port: 80, Name: "web-1", Tags: []string{"app=web"}},
+				Action:      "reject",
+				PolicyID:    "default",
+				DropReason:  "policy",
+			},
+		},
+		{
+			// The tags of the record are the ones of the PU reporting it
+			name: "unknown PUs",
+			record: &collector.FlowRecord{
+				ContextID:   "new",
+				Source:      pu("new", 5000),
+				Destination: pu("gone", 8080),
+				Tags:        policy.NewTagStoreFromMap(map[string]string{"app": "new"}),
+				Action:      policy.Accept,
+			},
+			want: &FlowLogEntry{
+				Type:        FlowLogFlow,
+				ContextID:   "new",
+				Source:      &FlowLogEndpoint{ID: "new", IP: "10.0.0.1", Port: 5000, Tags: []string{"app=new"}},
+				Destination: &FlowLogEndpoint{ID: "gone", IP: "10.0.0.1", Port: 8080},
+				Action:      "accept",
+			},
+		},
+		{
+			name:   "without end points",
+			record: &collector.FlowRecord{ContextID: "web", Action: policy.Reject},
+			want:   &FlowLogEntry{Type: FlowLogFlow, ContextID: "web", Action: "reject"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			next := &recordingCollector{}
+			c := NewFlowLogCollector(buf, next)
+			c.SetPULookup(lookup)
+
+			c.CollectFlowEvent(tt.record)
+
+			if entry := readFlowLog(t, buf); !reflect.DeepEqual(entry, tt.want) {
+				got, _ := json.Marshal(entry)    // nolint
+				want, _ := json.Marshal(tt.want) // nolint
+				t.Errorf("entry = %s, want %s", got, want)
+			}
+			if len(next.flows) != 1 || next.flows[0] != tt.record {
+				t.Errorf("flows passed on = %d, want the flow", len(next.flows))
+			}
+		})
+	}
+}
+
+func TestFlowLogCollectorContainers(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	next := &recordingCollector{}
+	c := NewFlowLogCollector(buf, next)
+
+	// The names are only known once the lookup is set
+	record := &collector.ContainerRecord{
+		ContextID: "web",
+		IPAddress: "10.0.0.1",
+		Tags:      policy.NewTagStoreFromMap(map[string]string{"app": "web"}),
+		Event:     "start",
+	}
+	want := &FlowLogEntry{Type: FlowLogContainer, ContextID: "web", Event: "start", IPAddress: "10.0.0.1", Tags: []string{"app=web"}}
+
+	c.CollectContainerEvent(record)
+	if entry := readFlowLog(t, buf); !reflect.DeepEqual(entry, want) {
+		t.Errorf("entry = %+v, want %+v", entry, want)
+	}
+
+	buf.Reset()
+	c.SetPULookup(fakeLookup{"web": {Name: "web-1"}})
+	want.Name = "web-1"
+
+	c.CollectContainerEvent(record)
+	if entry := readFlowLog(t, buf); !reflect.DeepEqual(entry, want) {
+		t.Errorf("entry = %+v, want %+v", entry, want)
+	}
+
+	if len(next.containers) != 2 || next.containers[0] != record {
+		t.Errorf("container events passed on = %d, want 2", len(next.containers))
+	}
+}
@@ -0,0 +1,107 @@
+package collectors
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.uber.org/zap"
+)
+
+// ipfixProtocolTCP is the protocol of the exported flows. Trireme authorizes
+// TCP connections and its flow records do not carry the protocol.
+const ipfixProtocolTCP = 6
+
+// IPFIXCollector exports the flows reported by Trireme to an IPFIX collector.
+// The names of the PUs come from the lookup, once it is set. All the events are
+// passed on to the next collector.
+type IPFIXCollector struct {
+	exporter *IPFIXExporter
+	lookup   PULookup
+	next     collector.EventCollector
+	sync.Mutex
+}
+
+// NewIPFIXCollector creates a collector exporting the flows with exporter
+// before passing them to next
+func NewIPFIXCollector(exporter *IPFIXExporter, next collector.EventCollector) *IPFIXCollector {
+
+	return &IPFIXCollector{
+		exporter: exporter,
+		next:     next,
+	}
+}
+
+// SetPULookup sets where the names of the PUs are found. The policy resolver
+// is created after the collector, so it is set later.
+func (c *IPFIXCollector) SetPULookup(lookup PULookup) {
+
+	c.Lock()
+	c.lookup = lookup
+	c.Unlock()
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (c *IPFIXCollector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	ipfixRecord, err := c.ipfixRecord(record)
+	if err == nil {
+		err = c.exporter.Export(ipfixRecord)
+	}
+	if err != nil {
+		zap.L().Error("Unable to export flow to IPFIX", zap.String("contextID", record.ContextID), zap.Error(err))
+	}
+
+	c.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (c *IPFIXCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.next.CollectContainerEvent(record)
+}
+
+// ipfixRecord converts a flow record to an IPFIX record
+func (c *IPFIXCollector) ipfixRecord(record *collector.FlowRecord) (*IPFIXRecord, error) {
+
+	if record.Source == nil || record.Destination == nil {
+		return nil, fmt.Errorf("flow without source or destination")
+	}
+
+	c.Lock()
+	lookup := c.lookup
+	c.Unlock()
+
+	source := newFlowLogEndpoint(lookup, record, record.Source)
+	destination := newFlowLogEndpoint(lookup, record, record.Destination)
+
+	ipfixRecord := &IPFIXRecord{
+		SourceIP:          net.ParseIP(source.IP),
+		DestinationIP:     net.ParseIP(destination.IP),
+		SourcePort:        source.Port,
+		DestinationPort:   destination.Port,
+		Protocol:          ipfixProtocolTCP,
+		FlowCount:         uint64(record.Count),
+		FirewallEvent:     IPFIXFlowCreated,
+		Time:              time.Now(),
+		SourcePUID:        source.ID,
+		DestinationPUID:   destination.ID,
+		PolicyID:          record.PolicyID,
+		SourcePUName:      source.Name,
+		DestinationPUName: destination.Name,
+	}
+
+	if ipfixRecord.SourceIP == nil || ipfixRecord.DestinationIP == nil {
+		return nil, fmt.Errorf("invalid addresses %s and %s", source.IP, destination.IP)
+	}
+	if record.Action.Rejected() {
+		ipfixRecord.FirewallEvent = IPFIXFlowDenied
+	}
+	if record.Count <= 0 {
+		ipfixRecord.FlowCount = 1
+	}
+
+	return ipfixRecord, nil
+}
@@ -0,0 +1,370 @@
+package collectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// decodedField is a field of a template decoded from a message
+type decodedField struct {
+	id         uint16
+	length     uint16
+	enterprise uint32
+}
+
+// decodedMessage is an IPFIX message decoded with the templates it carries or
+// the templates of the previous messages
+type decodedMessage struct {
+	length            uint16
+	sequence          uint32
+	observationDomain uint32
+	templates         map[uint16][]decodedField
+	templateID        uint16
+	// values are the values of the data record by field, in hexadecimal for
+	// the fixed length fields
+	values map[string]string
+}
+
+// fieldName names a field of a template by its ID and enterprise number
+func fieldName(field decodedField) string {
+
+	if field.enterprise != 0 {
+		return fmt.Sprintf("%d/%d", field.enterprise, field.id)
+	}
+
+	return fmt.Sprintf("%d", field.id)
+}
+
+// decodeIPFIX decodes a message with a data set and possibly a template set
+func decodeIPFIX(t *testing.T, data []byte, templates map[uint16][]decodedField) *decodedMessage {
+
+	r := bytes.NewReader(data)
+	read := func(v interface{}) {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			t.Fatalf("truncated message: %s", err)
+		}
+	}
+
+	var version uint16
+	var exportTime uint32
+	m := &decodedMessage{templates: templates, values: map[string]string{}}
+	read(&version)
+	read(&m.length)
+	read(&exportTime)
+	read(&m.sequence)
+	read(&m.observationDomain)
+	if version != ipfixVersion || int(m.length) != len(data) {
+		t.Fatalf("header = version %d, length %d, want version %d, length %d", version, m.length, ipfixVersion, len(data))
+	}
+
+	for r.Len() > 0 {
+		var setID, setLength uint16
+		read(&setID)
+		read(&setLength)
+		end := r.Len() - int(setLength) + ipfixSetHeaderLength
+
+		if setID == ipfixTemplateSetID {
+			for r.Len() > end {
+				var templateID, count uint16
+				read(&templateID)
+				read(&count)
+				fields := []decodedField{}
+				for i := 0; i < int(count); i++ {
+					field := decodedField{}
+					read(&field.id)
+					read(&field.length)
+					if field.id&ipfixEnterpriseBit != 0 {
+						field.id &^= ipfixEnterpriseBit
+						read(&field.enterprise)
+					}
+					fields = append(fields, field)
+				}
+				m.templates[templateID] = fields
+			}
+			continue
+		}
+
+		fields, ok := m.templates[setID]
+		if !ok {
+			t.Fatalf("data set %d without template", setID)
+		}
+		m.templateID = setID
+		for _, field := range fields {
+			length := int(field.length)
+			if field.length == ipfixVariableLength {
+				var short uint8
+				read(&short)
+				length = int(short)
+				if short == 255 {
+					var long uint16
+					read(&long)
+					length = int(long)
+				}
+			}
+			value := make([]byte, length)
+			read(value)
+			if field.length == ipfixVariableLength {
+				m.values[fieldName(field)] = string(value)
+			} else {
+				m.values[fieldName(field)] = fmt.Sprintf("%x", value)
+			}
+		}
+		if r.Len() != end {
+			t.Fatalf("data set %d has %d bytes left", setID, r.Len()-end)
+		}
+	}
+
+	return m
+}
+
+// enterpriseField names an enterprise-specific field
+func enterpriseField(id int) string {
+	return fmt.Sprintf("%d/%d", IPFIXEnterpriseNumber, id)
+}
+
+func TestIPFIXExporter(t *testing.T) {
+
+	l := newUDPListener(t)
+	defer l.Close() // nolint
+
+	e, err := NewIPFIXExporter("udp://"+l.LocalAddr().String(), 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observed := time.Unix(1500000000, 123000000)
+	longName := strings.Repeat("n", 2000)
+	records := []*IPFIXRecord{
+		{
+			SourceIP:          net.ParseIP("10.0.0.2"),
+			DestinationIP:     net.ParseIP("10.0.0.1"),
+			SourcePort:        5000,
+			DestinationPort:   443,
+			Protocol:          ipfixProtocolTCP,
+			FlowCount:         3,
+			FirewallEvent:     IPFIXFlowDenied,
+			Time:              observed,
+			SourcePUID:        "web-id",
+			DestinationPUID:   "",
+			PolicyID:          "ssh",
+			SourcePUName:      "web",
+			DestinationPUName: longName,
+		},
+		{
+			SourceIP:        net.ParseIP("2001:db8::2"),
+			DestinationIP:   net.ParseIP("10.0.0.1"),
+			SourcePort:      80,
+			DestinationPort: 8080,
+			Protocol:        ipfixProtocolTCP,
+			FlowCount:       1,
+			FirewallEvent:   IPFIXFlowCreated,
+			Time:            observed,
+		},
+	}
+	for _, record := range records {
+		if err = e.Export(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	datagrams := readDatagrams(t, l)
+	if len(datagrams) != 2 {
+		t.Fatalf("messages = %d, want 2", len(datagrams))
+	}
+
+	// The templates are only in the first message
+	templates := map[uint16][]decodedField{}
+	first := decodeIPFIX(t, []byte(datagrams[0]), templates)
+	if len(first.templates) != 2 {
+		t.Fatalf("templates = %v, want IPv4 and IPv6", first.templates)
+	}
+	for templateID, addressID := range map[uint16]uint16{IPFIXTemplateIPv4: ieSourceIPv4Address, IPFIXTemplateIPv6: ieSourceIPv6Address} {
+		fields := templates[templateID]
+		if len(fields) != 13 || fields[0].id != addressID || fields[12] != (decodedField{id: IPFIXDestinationPUName, length: ipfixVariableLength, enterprise: IPFIXEnterpriseNumber}) {
+			t.Errorf("template %d = %v", templateID, fields)
+		}
+	}
+
+	second := decodeIPFIX(t, []byte(datagrams[1]), templates)
+
+	tests := []struct {
+		name    string
+		message *decodedMessage
+		want    *decodedMessage
+	}{
+		{
+			name:    "IPv4",
+			message: first,
+			want: &decodedMessage{
+				sequence:          0,
+				observationDomain: 7,
+				templateID:        IPFIXTemplateIPv4,
+				values: map[string]string{
+					"8":                "0a000002",
+					"12":               "0a000001",
+					"7":                "1388",
+					"11":               "01bb",
+					"4":                "06",
+					"3":                "0000000000000003",
+					"233":              "03",
+					"323":              fmt.Sprintf("%016x", 1500000000123),
+					enterpriseField(1): "web-id",
+					enterpriseField(2): "",
+					enterpriseField(3): "ssh",
+					enterpriseField(4): "web",
+					enterpriseField(5): longName[:ipfixMaxStringLength],
+				},
+			},
+		},
+		{
+			name:    "IPv6",
+			message: second,
+			want: &decodedMessage{
+				sequence:          1,
+				observationDomain: 7,
+				templateID:        IPFIXTemplateIPv6,
+				values: map[string]string{
+					"27":               "20010db8000000000000000000000002",
+					"28":               "00000000000000000000ffff0a000001",
+					"7":                "0050",
+					"11":               "1f90",
+					"4":                "06",
+					"3":                "0000000000000001",
+					"233":              "01",
+					"323":              fmt.Sprintf("%016x", 1500000000123),
+					enterpriseField(1): "",
+					enterpriseField(2): "",
+					enterpriseField(3): "",
+					enterpriseField(4): "",
+					enterpriseField(5): "",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			m := tt.message
+			if m.sequence != tt.want.sequence || m.observationDomain != tt.want.observationDomain || m.templateID != tt.want.templateID {
+				t.Errorf("message = sequence %d, domain %d, template %d, want %d, %d, %d",
+					m.sequence, m.observationDomain, m.templateID, tt.want.sequence, tt.want.observationDomain, tt.want.templateID)
+			}
+			if len(m.values) != len(tt.want.values) {
+				t.Errorf("fields = %d, want %d", len(m.values), len(tt.want.values))
+			}
+			for name, want := range tt.want.values {
+				if value := m.values[name]; value != want {
+					t.Errorf("field %s = %.40q, want %.40q", name, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIPFIXExporterDropsWhenQueueIsFull(t *testing.T) {
+
+	l := newUDPListener(t)
+	defer l.Close() // nolint
+
+	e, err := newIPFIXExporter("udp://"+l.LocalAddr().String(), 1, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := &IPFIXRecord{SourceIP: net.ParseIP("10.0.0.2"), DestinationIP: net.ParseIP("10.0.0.1")}
+	if err = e.Export(&IPFIXRecord{SourceIP: net.ParseIP("10.0.0.2")}); err == nil {
+		t.Errorf("expected an error for a record without destination")
+	}
+
+	// The records wait in the queue while the connection is in use
+	before := droppedFlows(t, "ipfix")
+	e.Lock()
+	for i := 0; i < 4; i++ {
+		if err = e.Export(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dropped := droppedFlows(t, "ipfix") - before
+	e.Unlock()
+
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped < 2 {
+		t.Errorf("dropped = %v, want at least 2", dropped)
+	}
+	if messages := readDatagrams(t, l); float64(len(messages))+dropped != 4 {
+		t.Errorf("messages = %d, dropped = %v, want 4 in total", len(messages), dropped)
+	}
+}
+
+func TestIPFIXCollector(t *testing.T) {
+
+	l := newUDPListener(t)
+	defer l.Close() // nolint
+
+	e, err := NewIPFIXExporter("udp://"+l.LocalAddr().String(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := &recordingCollector{}
+	c := NewIPFIXCollector(e, next)
+	c.SetPULookup(fakeLookup{"db": {Name: "db-1"}})
+
+	records := []*collector.FlowRecord{
+		{
+			ContextID:   "db",
+			Source:      &collector.EndPoint{IP: "10.0.0.2", Port: 5000, Type: collector.EndPointTypeExternalIP},
+			Destination: &collector.EndPoint{ID: "db", IP: "10.0.0.1", Port: 5432, Type: collector.EnpointTypePU},
+			Action:      policy.Reject,
+			PolicyID:    "default",
+		},
+		// Flows without addresses are not exported, but are passed on
+		{ContextID: "db", Action: policy.Accept},
+	}
+	for _, record := range records {
+		c.CollectFlowEvent(record)
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(next.flows) != 2 {
+		t.Errorf("flows passed on = %d, want 2", len(next.flows))
+	}
+
+	datagrams := readDatagrams(t, l)
+	if len(datagrams) != 1 {
+		t.Fatalf("messages = %d, want 1", len(datagrams))
+	}
+
+	m := decodeIPFIX(t, []byte(datagrams[0]), map[uint16][]decodedField{})
+	want := map[string]string{
+		"12":               "0a000001",
+		"11":               "1538",
+		"3":                "0000000000000001",
+		"233":              "03",
+		enterpriseField(2): "db",
+		enterpriseField(3): "default",
+		enterpriseField(5): "db-1",
+	}
+	for name, value := range want {
+		if m.values[name] != value {
+			t.Errorf("field %s = %q, want %q", name, m.values[name], value)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package collectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// IPFIXEnterpriseNumber is the enterprise number of the enterprise-specific
+// information elements. 32473 is the number reserved for documentation.
+const IPFIXEnterpriseNumber = 32473
+
+// Enterprise-specific information elements exported with IPFIXEnterpriseNumber.
+// They are all variable length strings.
+const (
+	IPFIXSourcePUID        = 1
+	IPFIXDestinationPUID   = 2
+	IPFIXPolicyID          = 3
+	IPFIXSourcePUName      = 4
+	IPFIXDestinationPUName = 5
+)
+
+// IDs of the templates of the IPv4 and IPv6 flows
+const (
+	IPFIXTemplateIPv4 = 256
+	IPFIXTemplateIPv6 = 257
+)
+
+const (
+	ipfixVersion             = 10
+	ipfixTemplateSetID       = 2
+	ipfixMessageHeaderLength = 16
+	ipfixSetHeaderLength     = 4
+	// ipfixVariableLength is the length of the variable length fields
+	ipfixVariableLength = 65535
+	// ipfixEnterpriseBit marks the enterprise-specific fields of the templates
+	ipfixEnterpriseBit = 0x8000
+	// ipfixMaxStringLength keeps the messages within a UDP datagram
+	ipfixMaxStringLength = 1024
+	// ipfixWriteTimeout is the time a message has to be sent
+	ipfixWriteTimeout = 5 * time.Second
+	// ipfixQueueSize is the number of records waiting to be sent before the
+	// new ones are dropped
+	ipfixQueueSize = 1024
+)
+
+// IANA information elements
+const (
+	ieDeltaFlowCount              = 3
+	ieProtocolIdentifier          = 4
+	ieSourceTransportPort         = 7
+	ieSourceIPv4Address           = 8
+	ieDestinationTransportPort    = 11
+	ieDestinationIPv4Address      = 12
+	ieSourceIPv6Address           = 27
+	ieDestinationIPv6Address      = 28
+	ieFirewallEvent               = 233
+	ieObservationTimeMilliseconds = 323
+)
+
+// Values of the firewallEvent information element
+const (
+	IPFIXFlowCreated = 1
+	IPFIXFlowDenied  = 3
+)
+
+// IPFIXRecord is a flow exported as an IPFIX data record
+type IPFIXRecord struct {
+	SourceIP          net.IP
+	DestinationIP     net.IP
+	SourcePort        uint16
+	DestinationPort   uint16
+	Protocol          uint8
+	FlowCount         uint64
+	FirewallEvent     uint8
+	Time              time.Time
+	SourcePUID        string
+	DestinationPUID   string
+	PolicyID          string
+	SourcePUName      string
+	DestinationPUName string
+}
+
+// ipfixField is a field of a template and the way it is encoded in the data
+// records
+type ipfixField struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+	encode     func(buf *bytes.Buffer, r *IPFIXRecord)
+}
+
+// IPFIXExporter exports flow records to an IPFIX collector over UDP or TCP
+// (RFC 7011). The templates are sent before the first data record of every
+// connection and, over UDP, resent every templateRefresh. The records are
+// queued and sent in the background: they are dropped when the queue is full.
+type IPFIXExporter struct {
+	network           string
+	address           string
+	observationDomain uint32
+	templateRefresh   time.Duration
+	queue             *sendQueue
+	conn              net.Conn
+	closed            bool
+	sequence          uint32
+	templatesSent     time.Time
+	sync.Mutex
+}
+
+// NewIPFIXExporter connects to the IPFIX collector at the given URL, such as
+// udp://host:4739 or tcp://host:4739. A zero templateRefresh never resends the
+// templates.
+func NewIPFIXExporter(rawurl string, observationDomain uint32, templateRefresh time.Duration) (*IPFIXExporter, error) {
+
+	return newIPFIXExporter(rawurl, observationDomain, templateRefresh, ipfixQueueSize)
+}
+
+// newIPFIXExporter connects to the IPFIX collector at the given URL and
+// queues at most queueSize records
+func newIPFIXExporter(rawurl string, observationDomain uint32, templateRefresh time.Duration, queueSize int) (*IPFIXExporter, error) {
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPFIX address %s: %s", rawurl, err)
+	}
+
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("invalid IPFIX address %s: transport must be udp or tcp", rawurl)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid IPFIX address %s: missing host", rawurl)
+	}
+
+	e := &IPFIXExporter{
+		network:           u.Scheme,
+		address:           u.Host,
+		observationDomain: observationDomain,
+		templateRefresh:   templateRefresh,
+	}
+
+	if err = e.connect(); err != nil {
+		return nil, err
+	}
+
+	e.queue = newSendQueue("ipfix", queueSize, func(record interface{}) error {
+		return e.send(record.(*IPFIXRecord))
+	})
+
+	return e, nil
+}
+
+// Export queues a record to be sent in an IPFIX message, preceded by the
+// templates if they are due. Flows with an IPv6 address use the IPv6
+// template. The record is dropped if the queue is full, and the errors
+// sending it are logged.
+func (e *IPFIXExporter) Export(record *IPFIXRecord) error {
+
+	if record.SourceIP.To16() == nil || record.DestinationIP.To16() == nil {
+		return fmt.Errorf("invalid addresses %s and %s", record.SourceIP, record.DestinationIP)
+	}
+
+	e.queue.push(record)
+
+	return nil
+}
+
+// Close sends the queued records and closes the connection to the collector
+func (e *IPFIXExporter) Close() error {
+
+	e.queue.close(ipfixWriteTimeout)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.closed = true
+	if e.conn == nil {
+		return nil
+	}
+
+	err := e.conn.Close()
+	e.conn = nil
+
+	return err
+}
+
+// send sends a queued record, reconnecting once if it fails. Nothing is sent
+// once the exporter is closed.
+func (e *IPFIXExporter) send(record *IPFIXRecord) error {
+
+	templateID := uint16(IPFIXTemplateIPv6)
+	if record.SourceIP.To4() != nil && record.DestinationIP.To4() != nil {
+		templateID = IPFIXTemplateIPv4
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if e.closed {
+		return nil
+	}
+
+	if err := e.write(templateID, record); err == nil {
+		return nil
+	}
+
+	// Reconnect once, the collector may have closed the connection
+	if err := e.connect(); err != nil {
+		return err
+	}
+
+	return e.write(templateID, record)
+}
+
+// connect opens the connection to the collector, closing the current one.
+// Every TCP connection is a new transport session, with its own sequence
+// numbers and templates. The caller must hold the lock, except during the
+// creation of the exporter.
+func (e *IPFIXExporter) connect() error {
+
+	if e.conn != nil {
+		e.conn.Close() // nolint
+		e.conn = nil
+	}
+
+	conn, err := net.DialTimeout(e.network, e.address, ipfixWriteTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to IPFIX collector %s: %s", e.address, err)
+	}
+
+	e.conn = conn
+	e.templatesSent = time.Time{}
+	if e.network == "tcp" {
+		e.sequence = 0
+	}
+
+	return nil
+}
+
+// write sends a message with a data record, and the templates if they are
+// due. The caller must hold the lock.
+func (e *IPFIXExporter) write(templateID uint16, record *IPFIXRecord) error {
+
+	if e.conn == nil {
+		return fmt.Errorf("not connected to IPFIX collector %s", e.address)
+	}
+
+	now := time.Now()
+	sendTemplates := e.templatesSent.IsZero() ||
+		(e.network == "udp" && e.templateRefresh > 0 && now.Sub(e.templatesSent) >= e.templateRefresh)
+
+	sets := &bytes.Buffer{}
+	if sendTemplates {
+		writeTemplateSet(sets)
+	}
+	writeDataSet(sets, templateID, record)
+
+	message := &bytes.Buffer{}
+	binary.Write(message, binary.BigEndian, uint16(ipfixVersion))                        // nolint
+	binary.Write(message, binary.BigEndian, uint16(ipfixMessageHeaderLength+sets.Len())) // nolint
+	binary.Write(message, binary.BigEndian, uint32(now.Unix()))                          // nolint
+	binary.Write(message, binary.BigEndian, e.sequence)                                  // nolint
+	binary.Write(message, binary.BigEndian, e.observationDomain)                         // nolint
+	message.Write(sets.Bytes())                                                          // nolint
+
+	e.conn.SetWriteDeadline(now.Add(ipfixWriteTimeout)) // nolint
+	if _, err := e.conn.Write(message.Bytes()); err != nil {
+		return err
+	}
+
+	// The sequence number counts the data records sent before the message
+	e.sequence++
+	if sendTemplates {
+		e.templatesSent = now
+	}
+
+	return nil
+}
+
+// writeTemplateSet writes the set of the IPv4 and IPv6 templates
+func writeTemplateSet(buf *bytes.Buffer) {
+
+	set := &bytes.Buffer{}
+	for _, templateID := range []uint16{IPFIXTemplateIPv4, IPFIXTemplateIPv6} {
+		fields := ipfixFields(templateID)
+		binary.Write(set, binary.BigEndian, templateID)          // nolint
+		binary.Write(set, binary.BigEndian, uint16(len(fields))) // nolint
+		for _, field := range fields {
+			id := field.id
+			if field.enterprise {
+				id |= ipfixEnterpriseBit
+			}
+			binary.Write(set, binary.BigEndian, id)           // nolint
+			binary.Write(set, binary.BigEndian, field.length) // nolint
+			if field.enterprise {
+				binary.Write(set, binary.BigEndian, uint32(IPFIXEnterpriseNumber)) // nolint
+			}
+		}
+	}
+
+	writeSet(buf, ipfixTemplateSetID, set.Bytes())
+}
+
+// writeDataSet writes a set with a data record of the given template
+func writeDataSet(buf *bytes.Buffer, templateID uint16, record *IPFIXRecord) {
+
+	set := &bytes.Buffer{}
+	for _, field := range ipfixFields(templateID) {
+		field.encode(set, record)
+	}
+
+	writeSet(buf, templateID, set.Bytes())
+}
+
+// writeSet writes a set with its header
+func writeSet(buf *bytes.Buffer, setID uint16, content []byte) {
+
+	binary.Write(buf, binary.BigEndian, setID)                                     // nolint
+	binary.Write(buf, binary.BigEndian, uint16(ipfixSetHeaderLength+len(content))) // nolint
+	buf.Write(content)                                                             // nolint
+}
+
+// ipfixFields returns the fields of a template
+func ipfixFields(templateID uint16) []ipfixField {
+
+	addresses := []ipfixField{
+		{id: ieSourceIPv4Address, length: 4, encode: func(buf *bytes.Buffer, r *IPFIXRecord) { buf.Write(r.SourceIP.To4()) }},           // nolint
+		{id: ieDestinationIPv4Address, length: 4, encode: func(buf *bytes.Buffer, r *IPFIXRecord) { buf.Write(r.DestinationIP.To4()) }}, // nolint
+	}
+	if templateID == IPFIXTemplateIPv6 {
+		addresses = []ipfixField{
+			{id: ieSourceIPv6Address, length: 16, encode: func(buf *bytes.Buffer, r *IPFIXRecord) { buf.Write(r.SourceIP.To16()) }},           // nolint
+			{id: ieDestinationIPv6Address, length: 16, encode: func(buf *bytes.Buffer, r *IPFIXRecord) { buf.Write(r.DestinationIP.To16()) }}, // nolint
+		}
+	}
+
+	return append(addresses,
+		ipfixField{id: ieSourceTransportPort, length: 2, encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			binary.Write(buf, binary.BigEndian, r.SourcePort) // nolint
+		}},
+		ipfixField{id: ieDestinationTransportPort, length: 2, encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			binary.Write(buf, binary.BigEndian, r.DestinationPort) // nolint
+		}},
+		ipfixField{id: ieProtocolIdentifier, length: 1, encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			buf.WriteByte(r.Protocol) // nolint
+		}},
+		ipfixField{id: ieDeltaFlowCount, length: 8, encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			binary.Write(buf, binary.BigEndian, r.FlowCount) // nolint
+		}},
+		ipfixField{id: ieFirewallEvent, length: 1, encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			buf.WriteByte(r.FirewallEvent) // nolint
+		}},
+		ipfixField{id: ieObservationTimeMilliseconds, length: 8, encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			binary.Write(buf, binary.BigEndian, uint64(r.Time.UnixNano()/int64(time.Millisecond))) // nolint
+		}},
+		ipfixStringField(IPFIXSourcePUID, func(r *IPFIXRecord) string { return r.SourcePUID }),
+		ipfixStringField(IPFIXDestinationPUID, func(r *IPFIXRecord) string { return r.DestinationPUID }),
+		ipfixStringField(IPFIXPolicyID, func(r *IPFIXRecord) string { return r.PolicyID }),
+		ipfixStringField(IPFIXSourcePUName, func(r *IPFIXRecord) string { return r.SourcePUName }),
+		ipfixStringField(IPFIXDestinationPUName, func(r *IPFIXRecord) string { return r.DestinationPUName }),
+	)
+}
+
+// ipfixStringField returns an enterprise-specific variable length field
+func ipfixStringField(id uint16, value func(r *IPFIXRecord) string) ipfixField {
+
+	return ipfixField{
+		id:         id,
+		length:     ipfixVariableLength,
+		enterprise: true,
+		encode: func(buf *bytes.Buffer, r *IPFIXRecord) {
+			s := value(r)
+			if len(s) > ipfixMaxStringLength {
+				s = s[:ipfixMaxStringLength]
+			}
+			if len(s) < 255 {
+				buf.WriteByte(byte(len(s))) // nolint
+			} else {
+				buf.WriteByte(255)                                  // nolint
+				binary.Write(buf, binary.BigEndian, uint16(len(s))) // nolint
+			}
+			buf.WriteString(s) // nolint
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package collectors
+
+import (
+	"sync"
+
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"go.aporeto.io/trireme-lib/collector"
+)
+
+// MetricsCollector counts the flows reported by Trireme by action, policy
+// index of the PU reporting them and PolicyID. The policy indexes come from
+// the lookup, once it is set. All the events are passed on to the next
+// collector.
+type MetricsCollector struct {
+	lookup PULookup
+	next   collector.EventCollector
+	sync.Mutex
+}
+
+// NewMetricsCollector creates a collector counting the flows before passing
+// them to next
+func NewMetricsCollector(next collector.EventCollector) *MetricsCollector {
+
+	return &MetricsCollector{
+		next: next,
+	}
+}
+
+// SetPULookup sets where the policy indexes of the PUs are found. The policy
+// resolver is created after the collector, so it is set later.
+func (c *MetricsCollector) SetPULookup(lookup PULookup) {
+
+	c.Lock()
+	c.lookup = lookup
+	c.Unlock()
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (c *MetricsCollector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	action := "rejected"
+	if record.Action.Accepted() {
+		action = "accepted"
+	}
+
+	count := record.Count
+	if count <= 0 {
+		count = 1
+	}
+
+	metrics.Flows.WithLabelValues(action, c.policyIndex(record.ContextID), record.PolicyID).Add(float64(count))
+
+	c.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (c *MetricsCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.next.CollectContainerEvent(record)
+}
+
+// policyIndex returns the policy index of a PU, or unknown
+func (c *MetricsCollector) policyIndex(puID string) string {
+
+	c.Lock()
+	lookup := c.lookup
+	c.Unlock()
+
+	if lookup == nil {
+		return "unknown"
+	}
+
+	pu, err := lookup.PU(puID)
+	if err != nil || pu.PolicyIndex == "" {
+		return "unknown"
+	}
+
+	return pu.PolicyIndex
+}
@@ -0,0 +1,112 @@
+package collectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"github.com/aporeto-inc/trireme-example/policyexample"
+	dto "github.com/prometheus/client_model/go"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// fakeLookup is a PULookup knowing fixed PUs
+type fakeLookup map[string]*policyexample.PUStatus
+
+func (l fakeLookup) PU(puID string) (*policyexample.PUStatus, error) {
+
+	pu, ok := l[puID]
+	if !ok {
+		return nil, fmt.Errorf("unknown PU %s", puID)
+	}
+
+	return pu, nil
+}
+
+// flowCount returns the number of flows counted with the given labels
+func flowCount(t *testing.T, action, policyIndex, policyID string) float64 {
+
+	m := &dto.Metric{}
+	if err := metrics.Flows.WithLabelValues(action, policyIndex, policyID).Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m.GetCounter().GetValue()
+}
+
+func TestMetricsCollector(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		lookup      PULookup
+		record      *collector.FlowRecord
+		action      string
+		policyIndex string
+		count       float64
+	}{
+		{
+			name:        "accepted",
+			lookup:      fakeLookup{"web": {PolicyIndex: "frontend"}},
+			record:      &collector.FlowRecord{ContextID: "web", Action: policy.Accept, PolicyID: "metrics-http", Count: 3},
+			action:      "accepted",
+			policyIndex: "frontend",
+			count:       3,
+		},
+		{
+			name:        "rejected without count",
+			lookup:      fakeLookup{"web": {PolicyIndex: "frontend"}},
+			record:      &collector.FlowRecord{ContextID: "web", Action: policy.Reject, PolicyID: "metrics-ssh"},
+			action:      "rejected",
+			policyIndex: "frontend",
+			count:       1,
+		},
+		{
+			name:        "unknown PU",
+			lookup:      fakeLookup{},
+			record:      &collector.FlowRecord{ContextID: "gone", Action: policy.Accept, PolicyID: "metrics-gone", Count: 2},
+			action:      "accepted",
+			policyIndex: "unknown",
+			count:       2,
+		},
+		{
+			name:        "PU without policy index",
+			lookup:      fakeLookup{"web": {}},
+			record:      &collector.FlowRecord{ContextID: "web", Action: policy.Accept, PolicyID: "metrics-noindex"},
+			action:      "accepted",
+			policyIndex: "unknown",
+			count:       1,
+		},
+		{
+			name:        "lookup not set",
+			record:      &collector.FlowRecord{ContextID: "web", Action: policy.Reject | policy.Log, PolicyID: "metrics-nolookup"},
+			action:      "rejected",
+			policyIndex: "unknown",
+			count:       1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			next := &recordingCollector{}
+			c := NewMetricsCollector(next)
+			if tt.lookup != nil {
+				c.SetPULookup(tt.lookup)
+			}
+
+			// The counters are global: only their increase is checked
+			before := flowCount(t, tt.action, tt.policyIndex, tt.record.PolicyID)
+			c.CollectFlowEvent(tt.record)
+			if count := flowCount(t, tt.action, tt.policyIndex, tt.record.PolicyID) - before; count != tt.count {
+				t.Errorf("flows counted = %v, want %v", count, tt.count)
+			}
+
+			// All the events are passed on
+			c.CollectContainerEvent(&collector.ContainerRecord{ContextID: "web"})
+			if len(next.flows) != 1 || next.flows[0] != tt.record || len(next.containers) != 1 {
+				t.Errorf("events passed on = %d flows, %d containers, want 1 and 1", len(next.flows), len(next.containers))
+			}
+		})
+	}
+}
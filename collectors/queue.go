@@ -0,0 +1,103 @@
+package collectors
+
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"go.uber.org/zap"
+)
+
+// sendQueue sends the flows of a sink from a single goroutine, so that the
+// collectors never wait for the network. The flows pushed while the queue is
+// full are dropped and counted.
+type sendQueue struct {
+	sink    string
+	items   chan interface{}
+	send    func(item interface{}) error
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newSendQueue starts sending the items pushed to a queue of the given size
+// with send. The errors of send are logged.
+func newSendQueue(sink string, size int, send func(item interface{}) error) *sendQueue {
+
+	q := &sendQueue{
+		sink:    sink,
+		items:   make(chan interface{}, size),
+		send:    send,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+// push queues an item. It returns false if the queue is full or closed and
+// the item is dropped.
+func (q *sendQueue) push(item interface{}) bool {
+
+	select {
+	case <-q.done:
+		return false
+	default:
+	}
+
+	select {
+	case q.items <- item:
+		return true
+	default:
+		metrics.DroppedFlows.WithLabelValues(q.sink).Inc()
+		return false
+	}
+}
+
+// close stops the queue once the queued items are sent, waiting at most
+// timeout for them
+func (q *sendQueue) close(timeout time.Duration) {
+
+	select {
+	case <-q.done:
+		return
+	default:
+		close(q.done)
+	}
+
+	select {
+	case <-q.stopped:
+	case <-time.After(timeout):
+		zap.L().Warn("Flows still queued at exit", zap.String("sink", q.sink), zap.Int("flows", len(q.items)))
+	}
+}
+
+// run sends the items until the queue is closed, then sends the items left
+func (q *sendQueue) run() {
+
+	defer close(q.stopped)
+
+	for {
+		select {
+		case item := <-q.items:
+			q.sendItem(item)
+		case <-q.done:
+			for {
+				select {
+				case item := <-q.items:
+					q.sendItem(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendItem sends an item and logs the error
+func (q *sendQueue) sendItem(item interface{}) {
+
+	if err := q.send(item); err != nil {
+		zap.L().Error("Unable to send flow", zap.String("sink", q.sink), zap.Error(err))
+	}
+}
@@ -0,0 +1,192 @@
+package collectors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rotatedTimeFormat is the suffix of the rotated files
+const rotatedTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a file that is rotated when it reaches a size or an age.
+// The rotated files are renamed with the time of the rotation as a suffix,
+// and only the most recent ones are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	opened     time.Time
+	// rotateErr is the error of the last rotation, nil if it succeeded
+	rotateErr error
+	sync.Mutex
+}
+
+// NewRotatingFile opens a rotating file for appending. A zero maxSize or
+// maxAge disables the corresponding rotation, and a zero maxBackups keeps
+// all the rotated files.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Write implements io.Writer. The file is rotated before the data is written
+// if it would exceed its size, or if it is too old. If the file cannot be
+// rotated, the data is written to the current file and the error is logged.
+func (r *RotatingFile) Write(data []byte) (int, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return 0, fmt.Errorf("file %s is closed", r.path)
+	}
+
+	tooBig := r.maxSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxSize
+	tooOld := r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+	if tooBig || tooOld {
+		err := r.rotate()
+		switch {
+		case err != nil && r.rotateErr == nil:
+			zap.L().Error("Unable to rotate file - writing to the current file", zap.String("file", r.path), zap.Error(err))
+		case err == nil && r.rotateErr != nil:
+			zap.L().Info("File rotated again", zap.String("file", r.path))
+		}
+		r.rotateErr = err
+		if r.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the file
+func (r *RotatingFile) Close() error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+// open opens the file for appending. The age of an existing file counts from
+// the time it is opened.
+func (r *RotatingFile) open() error {
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.opened = time.Now()
+
+	return nil
+}
+
+// rotate renames the current file, opens a new one and removes the oldest
+// rotated files. If the file cannot be renamed, the current file is opened
+// again. The file is nil only if it cannot be opened. The caller must hold
+// the lock.
+func (r *RotatingFile) rotate() error {
+
+	err := r.file.Close()
+	r.file = nil
+
+	if err == nil {
+		err = os.Rename(r.path, r.path+"."+time.Now().Format(rotatedTimeFormat))
+	}
+
+	if oerr := r.open(); oerr != nil {
+		return oerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.removeBackups()
+
+	return nil
+}
+
+// removeBackups removes the rotated files beyond maxBackups. Only the files
+// named after the file with a rotation time suffix are rotated files: the
+// other files of the directory are left alone. The caller must hold the lock.
+func (r *RotatingFile) removeBackups() {
+
+	if r.maxBackups <= 0 {
+		return
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return
+	}
+
+	prefix := filepath.Base(r.path) + "."
+	backups := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, perr := time.Parse(rotatedTimeFormat, strings.TrimPrefix(name, prefix)); perr != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(r.path), name))
+	}
+
+	if len(backups) <= r.maxBackups {
+		return
+	}
+
+	// The time suffix sorts the rotated files from the oldest
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-r.maxBackups] {
+		os.Remove(backup) // nolint
+	}
+}
@@ -0,0 +1,154 @@
+package collectors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestRotatingFile opens a rotating file in a temporary directory and
+// returns it with a function closing it and removing the directory
+func newTestRotatingFile(t *testing.T, maxSize int64, maxBackups int) (*RotatingFile, func()) {
+
+	dir, err := ioutil.TempDir("", "collectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRotatingFile(filepath.Join(dir, "flows.log"), maxSize, 0, maxBackups)
+	if err != nil {
+		os.RemoveAll(dir) // nolint
+		t.Fatal(err)
+	}
+
+	return r, func() {
+		r.Close()         // nolint
+		os.RemoveAll(dir) // nolint
+	}
+}
+
+// writeRecords writes the records to the file. The rotated files are named
+// after the time of the rotation: each record is written in a new millisecond.
+func writeRecords(t *testing.T, r *RotatingFile, records ...string) {
+
+	for _, record := range records {
+		time.Sleep(2 * time.Millisecond)
+		if _, err := r.Write([]byte(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// dirContents returns the contents of the files of the directory of the
+// rotating file, by name
+func dirContents(t *testing.T, r *RotatingFile) map[string]string {
+
+	files, err := ioutil.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	for _, file := range files {
+		data, rerr := ioutil.ReadFile(filepath.Join(filepath.Dir(r.path), file.Name()))
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		contents[file.Name()] = string(data)
+	}
+
+	return contents
+}
+
+// backupContents returns the contents of the rotated files, from the oldest
+func backupContents(t *testing.T, r *RotatingFile) []string {
+
+	contents := dirContents(t, r)
+
+	names := []string{}
+	for name := range contents {
+		if len(name) == len("flows.log.")+len(rotatedTimeFormat) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	backups := []string{}
+	for _, name := range names {
+		backups = append(backups, contents[name])
+	}
+
+	return backups
+}
+
+func TestRotatingFileSize(t *testing.T) {
+
+	r, cleanup := newTestRotatingFile(t, 10, 0)
+	defer cleanup()
+
+	// A record is never split, and a file always gets at least one record
+	writeRecords(t, r, "one\n", "two\n", "three\n", "a long record\n", "four\n")
+
+	if backups := backupContents(t, r); fmt.Sprint(backups) != fmt.Sprint([]string{"one\ntwo\n", "three\n", "a long record\n"}) {
+		t.Errorf("rotated files = %q", backups)
+	}
+	if current := dirContents(t, r)["flows.log"]; current != "four\n" {
+		t.Errorf("current file = %q, want %q", current, "four\n")
+	}
+}
+
+func TestRotatingFileRetention(t *testing.T) {
+
+	r, cleanup := newTestRotatingFile(t, 4, 2)
+	defer cleanup()
+
+	// Files named like the rotated files are not rotated files
+	unrelated := []string{"flows.log.bak", "flows.log.1", "flows.log.20180101", "flows.logs.20180101T000000.000"}
+	for _, name := range unrelated {
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(r.path), name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeRecords(t, r, "one\n", "two\n", "six\n", "ten\n", "end\n")
+
+	if backups := backupContents(t, r); fmt.Sprint(backups) != fmt.Sprint([]string{"six\n", "ten\n"}) {
+		t.Errorf("rotated files = %q, want the last 2", backups)
+	}
+
+	contents := dirContents(t, r)
+	for _, name := range unrelated {
+		if contents[name] != name {
+			t.Errorf("file %s was removed", name)
+		}
+	}
+	if contents["flows.log"] != "end\n" {
+		t.Errorf("current file = %q, want %q", contents["flows.log"], "end\n")
+	}
+}
+
+func TestRotatingFileRotationFailure(t *testing.T) {
+
+	r, cleanup := newTestRotatingFile(t, 4, 0)
+	defer cleanup()
+
+	writeRecords(t, r, "one\n")
+
+	// The file cannot be renamed once it is removed
+	if err := os.Remove(r.path); err != nil {
+		t.Fatal(err)
+	}
+
+	writeRecords(t, r, "two\n", "six\n")
+
+	if backups := backupContents(t, r); len(backups) != 1 || backups[0] != "two\n" {
+		t.Errorf("rotated files = %q, want the record written after the failure", backups)
+	}
+	if current := dirContents(t, r)["flows.log"]; current != "six\n" {
+		t.Errorf("current file = %q, want %q", current, "six\n")
+	}
+}
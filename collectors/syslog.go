@@ -0,0 +1,242 @@
+package collectors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+	"github.com/aporeto-inc/trireme-example/versions"
+	"go.aporeto.io/trireme-lib/collector"
+)
+
+// Formats of the messages of the syslog sink
+const (
+	// SyslogFormatRFC5424 sends the flows as RFC 5424 structured data
+	SyslogFormatRFC5424 = "rfc5424"
+	// SyslogFormatCEF sends the flows as ArcSight CEF messages
+	SyslogFormatCEF = "cef"
+)
+
+const (
+	// syslogMsgID is the MSGID of the flow messages
+	syslogMsgID = "flow"
+	// syslogSDID is the ID of the structured data of the flows. 32473 is the
+	// enterprise number reserved for documentation.
+	syslogSDID = "flow@32473"
+)
+
+// SyslogCollector sends the flows reported by Trireme to a syslog server,
+// either as RFC 5424 structured data or as CEF messages. With rejectsOnly,
+// only the rejected flows and the flows that would have been rejected in audit
+// mode are sent. All the events are passed on to the next collector.
+type SyslogCollector struct {
+	writer      *SyslogWriter
+	format      string
+	rejectsOnly bool
+	lookup      PULookup
+	next        collector.EventCollector
+	sync.Mutex
+}
+
+// NewSyslogCollector creates a collector sending the flows to writer in the
+// given format before passing them to next
+func NewSyslogCollector(writer *SyslogWriter, format string, rejectsOnly bool, next collector.EventCollector) (*SyslogCollector, error) {
+
+	if format != SyslogFormatRFC5424 && format != SyslogFormatCEF {
+		return nil, fmt.Errorf("invalid syslog format %s: must be %s or %s", format, SyslogFormatRFC5424, SyslogFormatCEF)
+	}
+
+	return &SyslogCollector{
+		writer:      writer,
+		format:      format,
+		rejectsOnly: rejectsOnly,
+		next:        next,
+	}, nil
+}
+
+// SetPULookup sets where the names of the PUs are found. The policy resolver
+// is created after the collector, so it is set later.
+func (c *SyslogCollector) SetPULookup(lookup PULookup) {
+
+	c.Lock()
+	c.lookup = lookup
+	c.Unlock()
+}
+
+// CollectFlowEvent implements the EventCollector interface
+func (c *SyslogCollector) CollectFlowEvent(record *collector.FlowRecord) {
+
+	_, audit := WouldReject(record)
+	if !c.rejectsOnly || record.Action.Rejected() || audit {
+		c.send(record, audit)
+	}
+
+	c.next.CollectFlowEvent(record)
+}
+
+// CollectContainerEvent implements the EventCollector interface
+func (c *SyslogCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+
+	c.next.CollectContainerEvent(record)
+}
+
+// send sends a flow in the format of the collector
+func (c *SyslogCollector) send(record *collector.FlowRecord, audit bool) {
+
+	c.Lock()
+	lookup := c.lookup
+	c.Unlock()
+
+	source := newFlowLogEndpoint(lookup, record, record.Source)
+	destination := newFlowLogEndpoint(lookup, record, record.Destination)
+	action := policyexample.ActionName(record.Action)
+
+	severity := SyslogInfo
+	switch {
+	case record.Action.Rejected():
+		severity = SyslogWarning
+	case audit:
+		severity = SyslogNotice
+	}
+
+	if c.format == SyslogFormatCEF {
+		c.writer.WriteMessage(severity, syslogMsgID, "", cefMessage(record, source, destination, action, audit))
+		return
+	}
+
+	params := []string{
+		sdParam("action", action),
+		sdParam("policyID", record.PolicyID),
+	}
+	params = append(params, sdEndpoint("src", source)...)
+	params = append(params, sdEndpoint("dst", destination)...)
+	params = append(params, sdParam("count", strconv.Itoa(record.Count)))
+	if record.DropReason != "" {
+		params = append(params, sdParam("dropReason", record.DropReason))
+	}
+	if record.Encrypted {
+		params = append(params, sdParam("encrypted", "true"))
+	}
+	if audit {
+		params = append(params, sdParam("audit", "true"))
+	}
+
+	structuredData := "[" + syslogSDID + " " + strings.Join(params, " ") + "]"
+	msg := fmt.Sprintf("%s %s -> %s", action, describeFlowLogEndpoint(source), describeFlowLogEndpoint(destination))
+
+	c.writer.WriteMessage(severity, syslogMsgID, structuredData, msg)
+}
+
+// sdEndpoint returns the structured data parameters of an end of a flow
+func sdEndpoint(prefix string, ep *FlowLogEndpoint) []string {
+
+	if ep == nil {
+		return nil
+	}
+
+	params := []string{
+		sdParam(prefix+"IP", ep.IP),
+		sdParam(prefix+"Port", strconv.Itoa(int(ep.Port))),
+	}
+	if ep.ID != "" {
+		params = append(params, sdParam(prefix+"ID", ep.ID))
+	}
+	if ep.Name != "" {
+		params = append(params, sdParam(prefix+"Name", ep.Name))
+	}
+
+	return params
+}
+
+// sdParam returns a structured data parameter, escaping its value as required
+// by RFC 5424
+func sdParam(name, value string) string {
+
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+
+	return name + `="` + value + `"`
+}
+
+// describeFlowLogEndpoint returns a short description of an end of a flow
+func describeFlowLogEndpoint(ep *FlowLogEndpoint) string {
+
+	if ep == nil {
+		return "-"
+	}
+
+	address := fmt.Sprintf("%s:%d", ep.IP, ep.Port)
+	if ep.Name != "" {
+		return fmt.Sprintf("%s (%s)", ep.Name, address)
+	}
+
+	return address
+}
+
+// cefMessage returns a flow as a CEF message
+func cefMessage(record *collector.FlowRecord, source, destination *FlowLogEndpoint, action string, audit bool) string {
+
+	name := "Flow accepted"
+	severity := "1"
+	switch {
+	case record.Action.Rejected():
+		name = "Flow rejected"
+		severity = "5"
+	case audit:
+		name = "Flow would have been rejected"
+		severity = "3"
+	}
+
+	extensions := []string{
+		cefExtension("act", action),
+		cefExtension("cnt", strconv.Itoa(record.Count)),
+		cefExtension("cs1Label", "policyID"),
+		cefExtension("cs1", record.PolicyID),
+	}
+	if source != nil {
+		extensions = append(extensions,
+			cefExtension("src", source.IP),
+			cefExtension("spt", strconv.Itoa(int(source.Port))),
+		)
+		if source.Name != "" {
+			extensions = append(extensions, cefExtension("cs2Label", "sourcePU"), cefExtension("cs2", source.Name))
+		}
+	}
+	if destination != nil {
+		extensions = append(extensions,
+			cefExtension("dst", destination.IP),
+			cefExtension("dpt", strconv.Itoa(int(destination.Port))),
+		)
+		if destination.Name != "" {
+			extensions = append(extensions, cefExtension("cs3Label", "destinationPU"), cefExtension("cs3", destination.Name))
+		}
+	}
+	if record.DropReason != "" {
+		extensions = append(extensions, cefExtension("reason", record.DropReason))
+	}
+
+	return strings.Join([]string{
+		"CEF:0",
+		cefHeader("Aporeto"),
+		cefHeader("trireme-example"),
+		cefHeader(versions.VERSION),
+		cefHeader("flow-" + action),
+		cefHeader(name),
+		severity,
+		strings.Join(extensions, " "),
+	}, "|")
+}
+
+// cefHeader escapes a field of the header of a CEF message
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`).Replace(value)
+}
+
+// cefExtension returns an extension of a CEF message, escaping its value
+func cefExtension(key, value string) string {
+
+	value = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+
+	return key + "=" + value
+}
@@ -0,0 +1,250 @@
+package collectors
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"github.com/aporeto-inc/trireme-example/versions"
+	dto "github.com/prometheus/client_model/go"
+	"go.aporeto.io/trireme-lib/collector"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// syslogHeader matches the header of the messages up to the MSGID
+const syslogHeader = `^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d) \S+ trireme-example \d+ flow `
+
+// newUDPListener listens for datagrams on a local port
+func newUDPListener(t *testing.T) net.PacketConn {
+
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+// readDatagrams reads datagrams until none is received for a while
+func readDatagrams(t *testing.T, l net.PacketConn) []string {
+
+	datagrams := []string{}
+	buf := make([]byte, 65536)
+	for {
+		l.SetReadDeadline(time.Now().Add(200 * time.Millisecond)) // nolint
+		n, _, err := l.ReadFrom(buf)
+		if err != nil {
+			return datagrams
+		}
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+}
+
+// droppedFlows returns the number of flows dropped by a sink
+func droppedFlows(t *testing.T, sink string) float64 {
+
+	m := &dto.Metric{}
+	if err := metrics.DroppedFlows.WithLabelValues(sink).Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m.GetCounter().GetValue()
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+
+	l := newUDPListener(t)
+	defer l.Close() // nolint
+
+	w, err := NewSyslogWriter("udp://"+l.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteMessage(SyslogWarning, syslogMsgID, `[flow@32473 action="reject"]`, "reject a -> b")
+	w.WriteMessage(SyslogInfo, syslogMsgID, "", "accept a -> b")
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		priority string
+		rest     string
+	}{
+		{priority: "132", rest: `[flow@32473 action="reject"] reject a -> b`},
+		{priority: "134", rest: `- accept a -> b`},
+	}
+
+	messages := readDatagrams(t, l)
+	if len(messages) != len(tests) {
+		t.Fatalf("messages = %q, want %d", messages, len(tests))
+	}
+	for i, tt := range tests {
+		match := regexp.MustCompile(syslogHeader + regexp.QuoteMeta(tt.rest) + "$").FindStringSubmatch(messages[i])
+		if match == nil || match[1] != tt.priority {
+			t.Errorf("message = %q, want priority %s and %q", messages[i], tt.priority, tt.rest)
+		}
+	}
+}
+
+func TestSyslogWriterTCPOctetCounting(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() // nolint
+
+	w, err := NewSyslogWriter("tcp://"+l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() // nolint
+
+	// New lines do not split the messages on TCP
+	texts := []string{"first", "second\nline", strings.Repeat("x", 2000)}
+	for _, text := range texts {
+		w.WriteMessage(SyslogInfo, syslogMsgID, "", text)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	for _, text := range texts {
+		length, rerr := r.ReadString(' ')
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		n, perr := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if perr != nil {
+			t.Fatalf("invalid message length %q", length)
+		}
+
+		message := make([]byte, n)
+		if _, rerr = io.ReadFull(r, message); rerr != nil {
+			t.Fatal(rerr)
+		}
+		if !regexp.MustCompile(syslogHeader + "- " + regexp.QuoteMeta(text) + "$").Match(message) {
+			t.Errorf("message = %q, want %q", message, text)
+		}
+	}
+
+	if rest, _ := r.ReadString(0); rest != "" { // nolint
+		t.Errorf("unexpected data after the messages: %q", rest)
+	}
+}
+
+func TestSyslogWriterDropsWhenQueueIsFull(t *testing.T) {
+
+	l := newUDPListener(t)
+	defer l.Close() // nolint
+
+	w, err := newSyslogWriter("udp://"+l.LocalAddr().String(), nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The messages wait in the queue while the connection is in use: one can
+	// be sent, one can wait, and the last ones are dropped
+	before := droppedFlows(t, "syslog")
+	w.Lock()
+	for i := 0; i < 4; i++ {
+		w.WriteMessage(SyslogInfo, syslogMsgID, "", strconv.Itoa(i))
+	}
+	dropped := droppedFlows(t, "syslog") - before
+	w.Unlock()
+
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dropped < 2 {
+		t.Errorf("dropped = %v, want at least 2", dropped)
+	}
+	if messages := readDatagrams(t, l); float64(len(messages))+dropped != 4 {
+		t.Errorf("messages = %q, dropped = %v, want 4 in total", messages, dropped)
+	}
+}
+
+func TestSyslogCollectorCEF(t *testing.T) {
+
+	l := newUDPListener(t)
+	defer l.Close() // nolint
+
+	w, err := NewSyslogWriter("udp://"+l.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewSyslogCollector(w, SyslogFormatCEF, false, &recordingCollector{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetPULookup(fakeLookup{"web": {Name: `web|1=a\b`}})
+
+	c.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:   "web",
+		Count:       2,
+		Source:      &collector.EndPoint{ID: "web", IP: "10.0.0.2", Port: 5000, Type: collector.EnpointTypePU},
+		Destination: &collector.EndPoint{IP: "10.0.0.1", Port: 443, Type: collector.EndPointTypeExternalIP},
+		Action:      policy.Reject,
+		PolicyID:    `ssh|admin=true\x`,
+	})
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := readDatagrams(t, l)
+	if len(messages) != 1 {
+		t.Fatalf("messages = %q, want 1", messages)
+	}
+
+	// In the extensions, only = and \ are escaped
+	want := `CEF:0|Aporeto|trireme-example|` + cefHeader(versions.VERSION) + `|flow-reject|Flow rejected|5|` +
+		`act=reject cnt=2 cs1Label=policyID cs1=ssh|admin\=true\\x src=10.0.0.2 spt=5000 ` +
+		`cs2Label=sourcePU cs2=web|1\=a\\b dst=10.0.0.1 dpt=443`
+	match := regexp.MustCompile(syslogHeader + `- (.*)$`).FindStringSubmatch(messages[0])
+	if match == nil || match[1] != "132" || match[3] != want {
+		t.Errorf("message = %q, want %q", messages[0], want)
+	}
+}
+
+func TestCEFEscaping(t *testing.T) {
+
+	tests := []struct {
+		value     string
+		header    string
+		extension string
+	}{
+		{value: "plain", header: "plain", extension: "plain"},
+		{value: "a|b", header: `a\|b`, extension: "a|b"},
+		{value: "a=b", header: "a=b", extension: `a\=b`},
+		{value: `a\b`, header: `a\\b`, extension: `a\\b`},
+		{value: "a\nb\r", header: "a\nb\r", extension: `a\nb\r`},
+		{value: `\|=`, header: `\\\|=`, extension: `\\|\=`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+
+			if header := cefHeader(tt.value); header != tt.header {
+				t.Errorf("header = %q, want %q", header, tt.header)
+			}
+			if extension := cefExtension("k", tt.value); extension != "k="+tt.extension {
+				t.Errorf("extension = %q, want %q", extension, "k="+tt.extension)
+			}
+		})
+	}
+}
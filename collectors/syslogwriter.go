@@ -0,0 +1,230 @@
+package collectors
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Syslog severities used by the sink
+const (
+	SyslogWarning = 4
+	SyslogNotice  = 5
+	SyslogInfo    = 6
+)
+
+const (
+	// syslogFacility is the local0 facility
+	syslogFacility = 16
+	// syslogAppName is the APP-NAME of the messages
+	syslogAppName = "trireme-example"
+	// syslogTimeFormat is the TIMESTAMP of the messages, limited to microseconds
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+	// syslogWriteTimeout is the time a message has to be sent
+	syslogWriteTimeout = 5 * time.Second
+	// syslogQueueSize is the number of messages waiting to be sent before the
+	// new ones are dropped
+	syslogQueueSize = 1024
+)
+
+// SyslogWriter sends RFC 5424 messages to a syslog server over UDP, TCP, TLS
+// or a local unix socket. Messages are framed with octet counting on TCP and
+// TLS (RFC 6587). The messages are queued and sent in the background: they
+// are dropped when the queue is full. The connection is re-established once
+// if a write fails.
+type SyslogWriter struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	hostname  string
+	procID    string
+	queue     *sendQueue
+	conn      net.Conn
+	closed    bool
+	// unixStream is true when connected to a stream unix socket
+	unixStream bool
+	sync.Mutex
+}
+
+// NewSyslogWriter connects to the syslog server at the given URL, such as
+// udp://host:514, tcp://host:601, tls://host:6514 or unix:///dev/log. The TLS
+// configuration is only used with tls://.
+func NewSyslogWriter(rawurl string, tlsConfig *tls.Config) (*SyslogWriter, error) {
+
+	return newSyslogWriter(rawurl, tlsConfig, syslogQueueSize)
+}
+
+// newSyslogWriter connects to the syslog server at the given URL and queues
+// at most queueSize messages
+func newSyslogWriter(rawurl string, tlsConfig *tls.Config, queueSize int) (*SyslogWriter, error) {
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %s: %s", rawurl, err)
+	}
+
+	w := &SyslogWriter{
+		network:   u.Scheme,
+		address:   u.Host,
+		tlsConfig: tlsConfig,
+		procID:    strconv.Itoa(os.Getpid()),
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %s: missing host", rawurl)
+		}
+	case "unix":
+		w.address = u.Path
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid syslog address %s: missing socket path", rawurl)
+		}
+	default:
+		return nil, fmt.Errorf("invalid syslog address %s: transport must be udp, tcp, tls or unix", rawurl)
+	}
+
+	if w.hostname, err = os.Hostname(); err != nil {
+		w.hostname = "-"
+	}
+
+	if err = w.connect(); err != nil {
+		return nil, err
+	}
+
+	w.queue = newSendQueue("syslog", queueSize, func(message interface{}) error {
+		return w.send(message.(string))
+	})
+
+	return w, nil
+}
+
+// WriteMessage queues a message with the given severity, MSGID, structured
+// data and text. An empty structured data is sent as the nil value. The
+// message is dropped if the queue is full, and the errors sending it are
+// logged.
+func (w *SyslogWriter) WriteMessage(severity int, msgID, structuredData, msg string) {
+
+	if structuredData == "" {
+		structuredData = "-"
+	}
+
+	message := fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s",
+		syslogFacility*8+severity,
+		time.Now().Format(syslogTimeFormat),
+		w.hostname,
+		syslogAppName,
+		w.procID,
+		msgID,
+		structuredData,
+		msg,
+	)
+
+	w.queue.push(message)
+}
+
+// Close sends the queued messages and closes the connection to the server
+func (w *SyslogWriter) Close() error {
+
+	w.queue.close(syslogWriteTimeout)
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+// send sends a queued message, reconnecting once if it fails. Nothing is sent
+// once the writer is closed.
+func (w *SyslogWriter) send(message string) error {
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	if err := w.write(message); err == nil {
+		return nil
+	}
+
+	// Reconnect once, the server may have closed the connection
+	if err := w.connect(); err != nil {
+		return err
+	}
+
+	return w.write(message)
+}
+
+// connect opens the connection to the server, closing the current one. The
+// caller must hold the lock, except during the creation of the writer.
+func (w *SyslogWriter) connect() error {
+
+	if w.conn != nil {
+		w.conn.Close() // nolint
+		w.conn = nil
+	}
+
+	var conn net.Conn
+	var err error
+
+	dialer := &net.Dialer{Timeout: syslogWriteTimeout}
+	switch w.network {
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.address, w.tlsConfig)
+	case "unix":
+		// Like log/syslog, try a datagram socket first
+		conn, err = dialer.Dial("unixgram", w.address)
+		w.unixStream = false
+		if err != nil {
+			conn, err = dialer.Dial("unix", w.address)
+			w.unixStream = true
+		}
+	default:
+		conn, err = dialer.Dial(w.network, w.address)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to connect to syslog server %s: %s", w.address, err)
+	}
+
+	w.conn = conn
+
+	return nil
+}
+
+// write sends a message over the current connection. The caller must hold the
+// lock.
+func (w *SyslogWriter) write(message string) error {
+
+	if w.conn == nil {
+		return fmt.Errorf("not connected to syslog server %s", w.address)
+	}
+
+	switch {
+	case w.network == "tcp" || w.network == "tls":
+		message = strconv.Itoa(len(message)) + " " + message
+	case w.unixStream:
+		// Messages are split on new lines on stream unix sockets
+		message += "\n"
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)) // nolint
+	_, err := w.conn.Write([]byte(message))
+
+	return err
+}
@@ -4,10 +4,10 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aporeto-inc/trireme-example/versions"
 	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"go.aporeto.io/trireme-lib/controller"
 	"go.uber.org/zap"
@@ -24,6 +24,18 @@ const (
 	PKI
 )
 
+// String returns the name of the Authentication method
+func (a AuthType) String() string {
+	switch a {
+	case PSK:
+		return "PSK"
+	case PKI:
+		return "PKI"
+	default:
+		return "unknown"
+	}
+}
+
 // ProductName is used in cobra/viper
 const ProductName = "trireme-example"
 
@@ -41,9 +53,59 @@ type Configuration struct {
 
 	// Set of Policies to be used with this example.
 	PolicyFile string
+	// PolicyFallback starts the daemon with the default policy if the policy file is invalid
+	PolicyFallback bool
+	// Audit turns the reject rules of all the policies into accept+log rules
+	Audit bool
+	// StateFile is where the daemon persists the enforced PUs. Empty disables persistency.
+	StateFile string
+
+	// FlowLogFile is where the flow and container events are written as JSON lines. Empty disables it.
+	FlowLogFile string
+	// FlowLogMaxSize is the size in MB at which the flow log is rotated. 0 disables it.
+	FlowLogMaxSize int
+	// FlowLogMaxAge is the age at which the flow log is rotated. 0 disables it.
+	FlowLogMaxAge time.Duration
+	// FlowLogMaxBackups is the number of rotated flow logs kept. 0 keeps them all.
+	FlowLogMaxBackups int
+
+	// FlowRetention is how long the aggregated flows are kept, the longest window of the top flows
+	FlowRetention time.Duration
+
+	// SyslogAddress is the URL of the syslog server where the flows are sent. Empty disables it.
+	SyslogAddress string
+	// SyslogFormat is the format of the syslog messages: rfc5424 or cef
+	SyslogFormat string
+	// SyslogRejectsOnly only sends the rejected flows to syslog
+	SyslogRejectsOnly bool
+	// SyslogCaCertPath is the CA used to verify the syslog server with tls://. Empty uses the system CAs.
+	SyslogCaCertPath string
+
+	// IPFIXAddress is the URL of the IPFIX collector where the flows are exported. Empty disables it.
+	IPFIXAddress string
+	// IPFIXTemplateRefresh is the interval at which the IPFIX templates are resent over UDP. 0 disables it.
+	IPFIXTemplateRefresh time.Duration
+	// IPFIXObservationDomain is the observation domain ID of the IPFIX messages
+	IPFIXObservationDomain uint32
+
+	// MetricsAddress is the TCP address where the Prometheus metrics are served. Empty disables it.
+	MetricsAddress string
+
+	// APISocket is the unix socket of the management API. Empty disables it.
+	APISocket string
+	// APIAddress is the TCP address of the management API. Empty disables it.
+	APIAddress string
+	// APICertPath is the certificate used for mTLS with the management API
+	APICertPath string
+	// APIKeyPath is the key used for mTLS with the management API
+	APIKeyPath string
+	// APICaCertPath is the CA used to verify peers of the management API
+	APICaCertPath string
 
 	// Launch Trireme-Example with support for CustomExtractor
 	CustomExtractor string
+	// ExtractorTimeout is the time the CustomExtractor has to return the metadata of a container
+	ExtractorTimeout time.Duration
 
 	// KeyPath is the path to the Key in PEM encoded format
 	KeyPath string
@@ -64,12 +126,25 @@ type Configuration struct {
 	// or into the host default namespace.
 	RemoteEnforcer bool
 
+	// DockerEnforcement defines if we activate/police Docker containers
 	DockerEnforcement bool
 	// LinuxProcesses defines if we activate//police LinuxProcesses
 	LinuxProcessesEnforcement bool
 
 	// Launch Trireme-Example with support for Swarm
 	SwarmMode bool
+	// SwarmCacheTTL is the time the labels of a swarm service are cached
+	SwarmCacheTTL time.Duration
+
+	// Launch Trireme-Example with support for docker-compose projects
+	ComposeMode bool
+
+	// Launch Trireme-Example with support for Kubernetes pods
+	KubernetesMode bool
+	// Kubeconfig is used to read the labels of the pods from the API server
+	Kubeconfig string
+	// KubernetesCacheTTL is the time the labels read from the API server are cached
+	KubernetesCacheTTL time.Duration
 
 	// Enforce defines if this process is an enforcer process (spawned into POD namespaces)
 	Enforce bool `mapstructure:"Enforce"`
@@ -96,8 +171,18 @@ const Usage = `trireme-example -h | --help
   trireme-example daemon
     [--target-networks=<networks>...]
     [--policy=<policyFile>]
+    [--policy-fallback]
+    [--audit]
+    [--state-file=<stateFile>]
+    [--flow-log=<file> [--flow-log-max-size=<MB>] [--flow-log-max-age=<duration>] [--flow-log-max-backups=<count>]]
+    [--flow-retention=<duration>]
+    [--syslog=<url> [--syslog-format=rfc5424|cef] [--syslog-rejects-only] [--syslog-ca-cert-file=<caCertFile>]]
+    [--ipfix=<url> [--ipfix-template-refresh=<duration>] [--ipfix-observation-domain=<id>]]
+    [--metrics-address=<address>]
     [--usePKI]
-    [--swarm|--extractor <metadatafile>]
+    [--docker=<bool>]
+    [--linux-processes=<bool>]
+    [--swarm [--swarm-cache-ttl=<duration>]|--extractor <metadatafile> [--extractor-timeout=<duration>]|--kubernetes [--kubeconfig=<kubeconfig> [--kubernetes-cache-ttl=<duration>]]|--compose]
     [--keyFile=<keyFile>]
     [--certFile=<certFile>]
     [--caCertFile=<caCertFile>]
@@ -109,7 +194,47 @@ const Usage = `trireme-example -h | --help
   trireme-example enforce
     [--log-level=<log-level>]
 
+  trireme-example status
+    [--output=<format>]
+
+  trireme-example list
+    [--output=<format>]
+
+  trireme-example flows top
+    [--window=<duration>]
+    [--limit=<count>]
+    [--output=<format>]
+
+  trireme-example policy validate <policyFile>
+
+  trireme-example policy simulate
+    --policy=<policyFile>
+    --src=<tags|ip>
+    --dst=<tags|ip>
+    [--port=<port/protocol>]
+    [--audit]
+    [--output=<format>]
+
+  trireme-example policy explain <src> <dst>
+    [--port=<port/protocol>]
+    [--output=<format>]
+
+  trireme-example policy diff <oldPolicyFile> <newPolicyFile>
+    [--live]
+    [--output=<format>]
+
+  trireme-example policy import-k8s <manifest>...
+    [--strict]
+
+  trireme-example policy graph <policyFile>
+    [--live]
+    [--output=<format>]
+
   trireme-example <cgroup>
+
+  Management API options, for the daemon and the commands querying it:
+    [--api-socket=<socket>]
+    [--api-address=<address> --api-cert-file=<certFile> --api-key-file=<keyFile> --api-ca-cert-file=<caCertFile>]
 `
 
 // InitCLI processes all commands and option flags, loads the configuration and
@@ -117,7 +242,7 @@ const Usage = `trireme-example -h | --help
 // execute once ready to run the program. The arguments are the functions that
 // should get executed once the CLI is started. `setLogs` is called to prepare zap.
 // `banner` is called to print a CLI banner on daemon startup.
-func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configuration) error, setLogs func(logFormat, logLevel string) error, banner func()) *cobra.Command {
+func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc, policyFunc, statusFunc, flowsFunc func(*Configuration) error, setLogs func(logFormat, logLevel string) error, banner func()) *cobra.Command {
 	var config Configuration
 	config.Arguments = make(map[string]interface{})
 	// if we don't initialize these as booleans, the systemdutil.ExecuteCommandFromArguments()
@@ -130,7 +255,29 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 	viper.SetDefault("Auth", PSK)
 	viper.SetDefault("PSK", "BADPASS")
 	viper.SetDefault("PolicyFile", "")
+	viper.SetDefault("PolicyFallback", false)
+	viper.SetDefault("Audit", false)
+	viper.SetDefault("StateFile", "/var/lib/trireme-example/state.db")
+	viper.SetDefault("FlowLogFile", "")
+	viper.SetDefault("FlowLogMaxSize", 100)
+	viper.SetDefault("FlowLogMaxAge", 24*time.Hour)
+	viper.SetDefault("FlowLogMaxBackups", 7)
+	viper.SetDefault("FlowRetention", time.Hour)
+	viper.SetDefault("SyslogAddress", "")
+	viper.SetDefault("SyslogFormat", "rfc5424")
+	viper.SetDefault("SyslogRejectsOnly", false)
+	viper.SetDefault("SyslogCaCertPath", "")
+	viper.SetDefault("IPFIXAddress", "")
+	viper.SetDefault("IPFIXTemplateRefresh", 10*time.Minute)
+	viper.SetDefault("IPFIXObservationDomain", 1)
+	viper.SetDefault("MetricsAddress", "")
+	viper.SetDefault("APISocket", "/var/run/trireme-example.sock")
+	viper.SetDefault("APIAddress", "")
+	viper.SetDefault("APICertPath", "")
+	viper.SetDefault("APIKeyPath", "")
+	viper.SetDefault("APICaCertPath", "")
 	viper.SetDefault("CustomExtractor", "")
+	viper.SetDefault("ExtractorTimeout", 5*time.Second)
 	viper.SetDefault("KeyPath", "")
 	viper.SetDefault("CertPath", "")
 	viper.SetDefault("CaCertPath", "")
@@ -141,8 +288,13 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 	viper.SetDefault("LogLevel", "info")
 	viper.SetDefault("RemoteEnforcer", true)
 	viper.SetDefault("DockerEnforcement", true)
-	viper.SetDefault("LinuxProcessesEnforcement", false)
+	viper.SetDefault("LinuxProcessesEnforcement", true)
 	viper.SetDefault("SwarmMode", false)
+	viper.SetDefault("SwarmCacheTTL", 30*time.Second)
+	viper.SetDefault("ComposeMode", false)
+	viper.SetDefault("KubernetesMode", false)
+	viper.SetDefault("Kubeconfig", "")
+	viper.SetDefault("KubernetesCacheTTL", 30*time.Second)
 	viper.SetDefault("Enforce", false)
 	viper.SetDefault("Run", false)
 
@@ -159,6 +311,8 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 	// TODO: we probably need to declare them all manually to match all the
 	//       the variables from docopts
 	viper.AutomaticEnv()
+	viper.BindEnv("DockerEnforcement", TriremeEnvPrefix+"_DOCKER_ENFORCEMENT")
+	viper.BindEnv("LinuxProcessesEnforcement", TriremeEnvPrefix+"_LINUX_PROCESSES_ENFORCEMENT")
 
 	// now define all commands
 	// 1. run command
@@ -275,21 +429,69 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 	}
 	cmdDaemon.Flags().StringSlice("target-networks", nil, "The target networks that Trireme should apply authentication")
 	cmdDaemon.Flags().String("policy", "", "Policy file")
+	cmdDaemon.Flags().Bool("policy-fallback", false, "Start with the default policy if the policy file is invalid")
+	cmdDaemon.Flags().Bool("audit", false, "Log the flows the policies would reject instead of rejecting them")
+	cmdDaemon.Flags().String("state-file", "/var/lib/trireme-example/state.db", "File where the enforced PUs are persisted - empty to disable")
+	cmdDaemon.Flags().String("flow-log", "", "File where the flow and container events are written as JSON lines - empty to disable")
+	cmdDaemon.Flags().Int("flow-log-max-size", 100, "Size in MB at which the flow log is rotated - 0 to disable")
+	cmdDaemon.Flags().Duration("flow-log-max-age", 24*time.Hour, "Age at which the flow log is rotated - 0 to disable")
+	cmdDaemon.Flags().Int("flow-log-max-backups", 7, "Number of rotated flow logs kept - 0 to keep all")
+	cmdDaemon.Flags().Duration("flow-retention", time.Hour, "How long the aggregated flows are kept for flows top")
+	cmdDaemon.Flags().String("syslog", "", "URL of the syslog server where the flows are sent: udp://, tcp://, tls://<host>:<port> or unix://<socket> - empty to disable")
+	cmdDaemon.Flags().String("syslog-format", "rfc5424", "Format of the syslog messages: rfc5424 or cef")
+	cmdDaemon.Flags().Bool("syslog-rejects-only", false, "Only send the rejected flows to syslog")
+	cmdDaemon.Flags().String("syslog-ca-cert-file", "", "CA used to verify the syslog server with tls:// - empty to use the system CAs")
+	cmdDaemon.Flags().String("ipfix", "", "URL of the IPFIX collector where the flows are exported: udp:// or tcp://<host>:<port> - empty to disable")
+	cmdDaemon.Flags().Duration("ipfix-template-refresh", 10*time.Minute, "Interval at which the IPFIX templates are resent over UDP - 0 to disable")
+	cmdDaemon.Flags().Uint32("ipfix-observation-domain", 1, "Observation domain ID of the IPFIX messages")
+	cmdDaemon.Flags().String("metrics-address", "", "TCP address where the Prometheus metrics are served on /metrics - empty to disable")
 	fUsePKI = cmdDaemon.Flags().Bool("usePKI", false, "Use PKI for Trireme")
+	cmdDaemon.Flags().Bool("docker", true, "Enforce Docker containers")
+	cmdDaemon.Flags().Bool("linux-processes", true, "Enforce Linux processes and user sessions")
 	cmdDaemon.Flags().Bool("swarm", false, "Deploy Docker Swarm metadata extractor")
+	cmdDaemon.Flags().Duration("swarm-cache-ttl", 30*time.Second, "Time the labels of a swarm service are cached")
+	cmdDaemon.Flags().Bool("compose", false, "Deploy docker-compose metadata extractor")
+	cmdDaemon.Flags().Bool("kubernetes", false, "Deploy Kubernetes pod metadata extractor")
+	cmdDaemon.Flags().String("kubeconfig", "", "Kubeconfig used to read pod labels from the API server")
+	cmdDaemon.Flags().Duration("kubernetes-cache-ttl", 30*time.Second, "Time the labels of a pod read from the API server are cached")
 	cmdDaemon.Flags().String("extractor", "", "External metadata extractor")
+	cmdDaemon.Flags().Duration("extractor-timeout", 5*time.Second, "Time the external metadata extractor has to return")
 	cmdDaemon.Flags().String("certFile", "", "Certificate file")
 	cmdDaemon.Flags().String("keyFile", "", "Key file")
 	cmdDaemon.Flags().String("caCertFile", "", "CA certificate")
 	cmdDaemon.Flags().String("caKeyFile", "", "CA key")
 	viper.BindPFlag("ParsedTriremeNetworks", cmdDaemon.Flags().Lookup("target-networks"))
 	viper.BindPFlag("PolicyFile", cmdDaemon.Flags().Lookup("policy"))
+	viper.BindPFlag("PolicyFallback", cmdDaemon.Flags().Lookup("policy-fallback"))
+	viper.BindPFlag("Audit", cmdDaemon.Flags().Lookup("audit"))
+	viper.BindPFlag("StateFile", cmdDaemon.Flags().Lookup("state-file"))
+	viper.BindPFlag("FlowLogFile", cmdDaemon.Flags().Lookup("flow-log"))
+	viper.BindPFlag("FlowLogMaxSize", cmdDaemon.Flags().Lookup("flow-log-max-size"))
+	viper.BindPFlag("FlowLogMaxAge", cmdDaemon.Flags().Lookup("flow-log-max-age"))
+	viper.BindPFlag("FlowLogMaxBackups", cmdDaemon.Flags().Lookup("flow-log-max-backups"))
+	viper.BindPFlag("FlowRetention", cmdDaemon.Flags().Lookup("flow-retention"))
+	viper.BindPFlag("SyslogAddress", cmdDaemon.Flags().Lookup("syslog"))
+	viper.BindPFlag("SyslogFormat", cmdDaemon.Flags().Lookup("syslog-format"))
+	viper.BindPFlag("SyslogRejectsOnly", cmdDaemon.Flags().Lookup("syslog-rejects-only"))
+	viper.BindPFlag("SyslogCaCertPath", cmdDaemon.Flags().Lookup("syslog-ca-cert-file"))
+	viper.BindPFlag("IPFIXAddress", cmdDaemon.Flags().Lookup("ipfix"))
+	viper.BindPFlag("IPFIXTemplateRefresh", cmdDaemon.Flags().Lookup("ipfix-template-refresh"))
+	viper.BindPFlag("IPFIXObservationDomain", cmdDaemon.Flags().Lookup("ipfix-observation-domain"))
+	viper.BindPFlag("MetricsAddress", cmdDaemon.Flags().Lookup("metrics-address"))
 	viper.BindPFlag("CertPath", cmdDaemon.Flags().Lookup("certFile"))
 	viper.BindPFlag("KeyPath", cmdDaemon.Flags().Lookup("keyFile"))
 	viper.BindPFlag("CaCertPath", cmdDaemon.Flags().Lookup("caCertFile"))
 	viper.BindPFlag("CaKeyPath", cmdDaemon.Flags().Lookup("caKeyFile"))
+	viper.BindPFlag("DockerEnforcement", cmdDaemon.Flags().Lookup("docker"))
+	viper.BindPFlag("LinuxProcessesEnforcement", cmdDaemon.Flags().Lookup("linux-processes"))
 	viper.BindPFlag("SwarmMode", cmdDaemon.Flags().Lookup("swarm"))
+	viper.BindPFlag("SwarmCacheTTL", cmdDaemon.Flags().Lookup("swarm-cache-ttl"))
+	viper.BindPFlag("ComposeMode", cmdDaemon.Flags().Lookup("compose"))
+	viper.BindPFlag("KubernetesMode", cmdDaemon.Flags().Lookup("kubernetes"))
+	viper.BindPFlag("Kubeconfig", cmdDaemon.Flags().Lookup("kubeconfig"))
+	viper.BindPFlag("KubernetesCacheTTL", cmdDaemon.Flags().Lookup("kubernetes-cache-ttl"))
 	viper.BindPFlag("CustomExtractor", cmdDaemon.Flags().Lookup("extractor"))
+	viper.BindPFlag("ExtractorTimeout", cmdDaemon.Flags().Lookup("extractor-timeout"))
 
 	// 4. enforce command
 	var fLogLevelRemote *string
@@ -325,8 +527,261 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 		},
 	}
 
-	// 5. the root command: the main application entrypoint
-	pfVersion := pflag.BoolP("version", "V", false, "Prints version information and exits")
+	// 5. policy command
+	cmdPolicy := &cobra.Command{
+		Use:   "policy",
+		Short: "Manage Trireme policy files",
+		Long:  "Manage Trireme policy files",
+	}
+
+	cmdPolicyValidate := &cobra.Command{
+		Use:   "validate <policyFile>",
+		Short: "Validate a policy file",
+		Long:  "Validate a policy file and report all the errors found in it",
+		Args:  cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["validate"] = true
+			config.Arguments["<policyFile>"] = args[0]
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return policyFunc(&config)
+		},
+	}
+	cmdPolicy.AddCommand(cmdPolicyValidate)
+
+	var fSimulatePolicy, fSimulateSrc, fSimulateDst, fSimulatePort, fSimulateOutput *string
+	var fSimulateAudit *bool
+	cmdPolicySimulate := &cobra.Command{
+		Use:   "simulate",
+		Short: "Simulate a flow against a policy file",
+		Long:  "Evaluate a policy file for a flow between two PUs, or a PU and an IP address, without enforcing anything",
+		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["simulate"] = true
+			config.Arguments["--policy"] = *fSimulatePolicy
+			config.Arguments["--src"] = *fSimulateSrc
+			config.Arguments["--dst"] = *fSimulateDst
+			config.Arguments["--port"] = *fSimulatePort
+			config.Arguments["--audit"] = *fSimulateAudit
+			config.Arguments["--output"] = *fSimulateOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return policyFunc(&config)
+		},
+	}
+	fSimulatePolicy = cmdPolicySimulate.Flags().String("policy", "", "Policy file")
+	fSimulateSrc = cmdPolicySimulate.Flags().String("src", "", "Source: tags as key=value,key=value or an IP address")
+	fSimulateDst = cmdPolicySimulate.Flags().String("dst", "", "Destination: tags as key=value,key=value or an IP address")
+	fSimulatePort = cmdPolicySimulate.Flags().String("port", "", "Destination port and protocol as port/protocol, or icmp - required with an IP address")
+	fSimulateAudit = cmdPolicySimulate.Flags().Bool("audit", false, "Simulate the audit mode of the daemon")
+	fSimulateOutput = cmdPolicySimulate.Flags().StringP("output", "o", "table", "Output format: table or json")
+	cmdPolicySimulate.MarkFlagRequired("policy") // nolint
+	cmdPolicySimulate.MarkFlagRequired("src")    // nolint
+	cmdPolicySimulate.MarkFlagRequired("dst")    // nolint
+	cmdPolicy.AddCommand(cmdPolicySimulate)
+
+	var fExplainPort, fExplainOutput *string
+	cmdPolicyExplain := &cobra.Command{
+		Use:   "explain <src> <dst>",
+		Short: "Explain the policy decision for traffic between two PUs",
+		Long:  "Ask the daemon how the policies of two PUs, given by their ID or name, apply to the traffic from src to dst",
+		Args:  cobra.ExactArgs(2),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["explain"] = true
+			config.Arguments["<src>"] = args[0]
+			config.Arguments["<dst>"] = args[1]
+			config.Arguments["--port"] = *fExplainPort
+			config.Arguments["--output"] = *fExplainOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return policyFunc(&config)
+		},
+	}
+	fExplainPort = cmdPolicyExplain.Flags().String("port", "", "Destination port and protocol as port/protocol, or icmp")
+	fExplainOutput = cmdPolicyExplain.Flags().StringP("output", "o", "table", "Output format: table or json")
+	cmdPolicy.AddCommand(cmdPolicyExplain)
+
+	var fDiffLive *bool
+	var fDiffOutput *string
+	cmdPolicyDiff := &cobra.Command{
+		Use:   "diff <oldPolicyFile> <newPolicyFile>",
+		Short: "Compare two policy files",
+		Long:  "Show the ACLs and selectors added, removed and changed per policy index between two policy files, and with --live the enforced PUs of the daemon affected by the change",
+		Args:  cobra.ExactArgs(2),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["diff"] = true
+			config.Arguments["<oldPolicyFile>"] = args[0]
+			config.Arguments["<newPolicyFile>"] = args[1]
+			config.Arguments["--live"] = *fDiffLive
+			config.Arguments["--output"] = *fDiffOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return policyFunc(&config)
+		},
+	}
+	fDiffLive = cmdPolicyDiff.Flags().Bool("live", false, "Report the enforced PUs of the running daemon affected by the change")
+	fDiffOutput = cmdPolicyDiff.Flags().StringP("output", "o", "table", "Output format: table or json")
+	cmdPolicy.AddCommand(cmdPolicyDiff)
+
+	var fImportStrict *bool
+	cmdPolicyImportK8s := &cobra.Command{
+		Use:   "import-k8s <manifest>...",
+		Short: "Convert Kubernetes NetworkPolicy manifests into a policy file",
+		Long:  "Convert the Kubernetes NetworkPolicy objects of YAML or JSON manifests into policies and bindings, printed as a policy file. The constructs that cannot be converted are reported on stderr.",
+		Args:  cobra.MinimumNArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["import-k8s"] = true
+			config.Arguments["<manifest>"] = args
+			config.Arguments["--strict"] = *fImportStrict
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return policyFunc(&config)
+		},
+	}
+	fImportStrict = cmdPolicyImportK8s.Flags().Bool("strict", false, "Fail if a construct of the manifests cannot be converted")
+	cmdPolicy.AddCommand(cmdPolicyImportK8s)
+
+	var fGraphLive *bool
+	var fGraphOutput *string
+	cmdPolicyGraph := &cobra.Command{
+		Use:   "graph <policyFile>",
+		Short: "Draw who can talk to whom according to a policy file",
+		Long:  "Output the graph of the policies of a policy file, linked where their rules accept the traffic, and of the external networks of their ACLs, in Graphviz DOT or JSON. With --live, the PUs enforced by the daemon are added.",
+		Args:  cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["graph"] = true
+			config.Arguments["<policyFile>"] = args[0]
+			config.Arguments["--live"] = *fGraphLive
+			config.Arguments["--output"] = *fGraphOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return policyFunc(&config)
+		},
+	}
+	fGraphLive = cmdPolicyGraph.Flags().Bool("live", false, "Add the PUs enforced by the running daemon")
+	fGraphOutput = cmdPolicyGraph.Flags().StringP("output", "o", "dot", "Output format: dot or json")
+	cmdPolicy.AddCommand(cmdPolicyGraph)
+
+	// 6. status and list commands
+	var fStatusOutput, fListOutput *string
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of the Trireme daemon",
+		Long:  "Show the status of the Trireme daemon and the PUs it handles",
+		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["status"] = true
+			config.Arguments["--output"] = *fStatusOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return statusFunc(&config)
+		},
+	}
+	fStatusOutput = cmdStatus.Flags().StringP("output", "o", "table", "Output format: table or json")
+
+	cmdList := &cobra.Command{
+		Use:   "list",
+		Short: "List the PUs handled by the Trireme daemon",
+		Long:  "List the PUs handled by the Trireme daemon",
+		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["list"] = true
+			config.Arguments["--output"] = *fListOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return statusFunc(&config)
+		},
+	}
+	fListOutput = cmdList.Flags().StringP("output", "o", "table", "Output format: table or json")
+
+	// 7. flows command
+	cmdFlows := &cobra.Command{
+		Use:   "flows",
+		Short: "Show the flows seen by the Trireme daemon",
+		Long:  "Show the flows seen by the Trireme daemon",
+	}
+
+	var fTopWindow *time.Duration
+	var fTopLimit *int
+	var fTopOutput *string
+	cmdFlowsTop := &cobra.Command{
+		Use:   "top",
+		Short: "Show the top talkers and the top rejected flows",
+		Long:  "Show the pairs of policies with the most accepted and rejected flows over a window, per destination port",
+		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.Arguments["top"] = true
+			config.Arguments["--window"] = *fTopWindow
+			config.Arguments["--limit"] = *fTopLimit
+			config.Arguments["--output"] = *fTopOutput
+
+			// print configuration if in debug
+			zap.L().Debug("prepared config", config.Fields()...)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// errors are reported by the command itself
+			cmd.SilenceUsage = true
+			// execute the actual command
+			return flowsFunc(&config)
+		},
+	}
+	fTopWindow = cmdFlowsTop.Flags().Duration("window", 5*time.Minute, "Window over which the flows are counted")
+	fTopLimit = cmdFlowsTop.Flags().Int("limit", 10, "Number of flows shown in each table - 0 for all")
+	fTopOutput = cmdFlowsTop.Flags().StringP("output", "o", "table", "Output format: table or json")
+	cmdFlows.AddCommand(cmdFlowsTop)
+
+	// 8. the root command: the main application entrypoint
+	// The version flag is defined on the command and not on the global flag
+	// set, so that the command can be created more than once
+	var pfVersion *bool
 	rootCmd := &cobra.Command{
 		Use:  Usage,
 		Long: "Command for launching programs with Trireme policy.",
@@ -348,6 +803,12 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 			if err != nil {
 				return fmt.Errorf("error setting up logs: %s", err)
 			}
+
+			// unset current Trireme Env variables as to keep a clean state for the remote enforcer process.
+			// This must happen after the configuration was read from them.
+			unsetEnvVar(TriremeEnvPrefix)
+
+			setupTriremeSubProcessArgs(&config)
 			return nil
 		},
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -368,8 +829,8 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 			return cgroupFunc(&config)
 		},
 	}
-	rootCmd.AddCommand(cmdRun, cmdRm, cmdDaemon, cmdEnforce)
-	rootCmd.PersistentFlags().AddFlag(pflag.Lookup("version"))
+	rootCmd.AddCommand(cmdRun, cmdRm, cmdDaemon, cmdEnforce, cmdPolicy, cmdStatus, cmdList, cmdFlows)
+	pfVersion = rootCmd.PersistentFlags().BoolP("version", "V", false, "Prints version information and exits")
 	rootCmd.PersistentFlags().String("log-level", "info", "Log level")
 	rootCmd.PersistentFlags().String("log-format", "info", "Log Format")
 	// TODO: not used at all?
@@ -378,13 +839,18 @@ func InitCLI(runFunc, rmFunc, cgroupFunc, enforceFunc, daemonFunc func(*Configur
 	rootCmd.PersistentFlags().String("log-id", "", "Log identifier")
 	// TODO: not used at all?
 	rootCmd.PersistentFlags().Bool("log-to-console", true, "Log to console")
+	rootCmd.PersistentFlags().String("api-socket", "/var/run/trireme-example.sock", "Unix socket of the management API - empty to disable")
+	rootCmd.PersistentFlags().String("api-address", "", "TCP address of the management API, served with mTLS")
+	rootCmd.PersistentFlags().String("api-cert-file", "", "Certificate for mTLS with the management API")
+	rootCmd.PersistentFlags().String("api-key-file", "", "Key for mTLS with the management API")
+	rootCmd.PersistentFlags().String("api-ca-cert-file", "", "CA certificate used to verify the management API peer")
 	viper.BindPFlag("LogLevel", rootCmd.PersistentFlags().Lookup("log-level"))
 	viper.BindPFlag("LogFormat", rootCmd.PersistentFlags().Lookup("log-format"))
-
-	// unset current Trireme Env variables as to keep a clean state for the remote enforcer process.
-	unsetEnvVar(TriremeEnvPrefix)
-
-	setupTriremeSubProcessArgs(&config)
+	viper.BindPFlag("APISocket", rootCmd.PersistentFlags().Lookup("api-socket"))
+	viper.BindPFlag("APIAddress", rootCmd.PersistentFlags().Lookup("api-address"))
+	viper.BindPFlag("APICertPath", rootCmd.PersistentFlags().Lookup("api-cert-file"))
+	viper.BindPFlag("APIKeyPath", rootCmd.PersistentFlags().Lookup("api-key-file"))
+	viper.BindPFlag("APICaCertPath", rootCmd.PersistentFlags().Lookup("api-ca-cert-file"))
 
 	return rootCmd
 }
@@ -397,6 +863,15 @@ func (c *Configuration) Fields() []zapcore.Field {
 		zap.Bool("DockerEnforcement", c.DockerEnforcement),
 		zap.Bool("LinuxProcessesEnforcement", c.LinuxProcessesEnforcement),
 		zap.Bool("SwarmMode", c.SwarmMode),
+		zap.Bool("ComposeMode", c.ComposeMode),
+		zap.Bool("Audit", c.Audit),
+		zap.String("FlowLogFile", c.FlowLogFile),
+		zap.String("SyslogAddress", c.SyslogAddress),
+		zap.String("SyslogFormat", c.SyslogFormat),
+		zap.String("IPFIXAddress", c.IPFIXAddress),
+		zap.String("MetricsAddress", c.MetricsAddress),
+		zap.Bool("KubernetesMode", c.KubernetesMode),
+		zap.String("CustomExtractor", c.CustomExtractor),
 	}
 
 	if c.Auth == PSK {
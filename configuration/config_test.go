@@ -0,0 +1,57 @@
+package configuration
+
+import (
+	"os"
+	"testing"
+)
+
+func TestInitCLIReadsEnvBeforeUnsettingIt(t *testing.T) {
+
+	env := map[string]string{
+		TriremeEnvPrefix + "_DOCKER_ENFORCEMENT":          "false",
+		TriremeEnvPrefix + "_LINUX_PROCESSES_ENFORCEMENT": "true",
+	}
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Unsetenv(key) // nolint
+	}
+
+	var daemonConfig *Configuration
+	daemon := func(config *Configuration) error {
+		daemonConfig = config
+		return nil
+	}
+	unused := func(*Configuration) error { return nil }
+	setLogs := func(logFormat, logLevel string) error { return nil }
+
+	cmd := InitCLI(unused, unused, unused, unused, daemon, unused, unused, unused, setLogs, func() {})
+
+	// The variables are still set until the command runs
+	for key := range env {
+		if _, ok := os.LookupEnv(key); !ok {
+			t.Fatalf("%s was unset before the configuration was read", key)
+		}
+	}
+
+	cmd.SetArgs([]string{"daemon", "--api-socket", ""})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	if daemonConfig == nil {
+		t.Fatal("the daemon did not run")
+	}
+	if daemonConfig.DockerEnforcement || !daemonConfig.LinuxProcessesEnforcement {
+		t.Errorf("config = docker %t, linux processes %t, want false, true",
+			daemonConfig.DockerEnforcement, daemonConfig.LinuxProcessesEnforcement)
+	}
+
+	// The remote enforcers do not see the variables
+	for key := range env {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Errorf("%s is still set", key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package extractors
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// Labels set by docker-compose on the containers it creates
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	composeLabelPrefix  = "com.docker.compose."
+)
+
+// ComposeProjectTag is the tag holding the docker-compose project of a container
+const ComposeProjectTag = "project"
+
+// ComposeServiceTag is the tag holding the docker-compose service of a container
+const ComposeServiceTag = "service"
+
+// ComposeExtractor is an example metadata extractor for docker-compose. The
+// compose project and service of a container are turned into short project
+// and service tags. The other compose labels are dropped.
+func ComposeExtractor(info *types.ContainerJSON) (*policy.PURuntime, error) {
+
+	// Create the tags based on the docker labels
+	tags := policy.NewTagStoreFromMap(map[string]string{
+		"image": info.Config.Image,
+		"name":  info.Name,
+	})
+
+	for k, v := range info.Config.Labels {
+		switch {
+		case k == composeProjectLabel:
+			tags.AppendKeyValue(ComposeProjectTag, v)
+		case k == composeServiceLabel:
+			tags.AppendKeyValue(ComposeServiceTag, v)
+		case strings.HasPrefix(k, composeLabelPrefix):
+		default:
+			tags.AppendKeyValue(k, v)
+		}
+	}
+
+	ipa := policy.ExtendedMap{
+		"bridge": "0.0.0.0/0",
+	}
+
+	return policy.NewPURuntime(info.Name, info.State.Pid, "", tags, ipa, common.ContainerPU, nil), nil
+}
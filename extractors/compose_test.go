@@ -0,0 +1,47 @@
+package extractors
+
+import (
+	"testing"
+)
+
+func TestComposeExtractor(t *testing.T) {
+
+	runtime, err := ComposeExtractor(newTestContainer("/shop_web_1", map[string]string{
+		composeProjectLabel:                   "shop",
+		composeServiceLabel:                   "web",
+		"com.docker.compose.container-number": "1",
+		"com.docker.compose.config-hash":      "abcd",
+		"app":                                 "frontend",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := runtime.Tags()
+
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{key: ComposeProjectTag, value: "shop", ok: true},
+		{key: ComposeServiceTag, value: "web", ok: true},
+		{key: "app", value: "frontend", ok: true},
+		{key: "image", value: "nginx", ok: true},
+		{key: "name", value: "/shop_web_1", ok: true},
+		{key: composeProjectLabel},
+		{key: composeServiceLabel},
+		{key: "com.docker.compose.container-number"},
+		{key: "com.docker.compose.config-hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+
+			value, ok := tags.Get(tt.key)
+			if ok != tt.ok || value != tt.value {
+				t.Errorf("tag %s = %q (%t), want %q (%t)", tt.key, value, ok, tt.value, tt.ok)
+			}
+		})
+	}
+}
@@ -14,38 +14,49 @@ import (
 
 Standard example of an external docker metadata extractor.
 INPUT as arg[1]: The docker Container JSON with all the related information as defined in github.com/docker/docker/api/types
-OUTPUT on STDPUT: The JSON representation (marshalled) of the PURuntime as defined in go.aporeto.io/trireme-lib/policy
+OUTPUT on STDOUT: The JSON representation (marshalled) of the PURuntime as defined in go.aporeto.io/trireme-lib/policy
+ERRORS on STDERR, with a non-zero exit code.
 
 */
 
 func main() {
+	if len(os.Args) != 2 {
+		fail("usage: %s <container JSON>", os.Args[0])
+	}
+
 	jsonFromDocker := os.Args[1]
 	var m types.ContainerJSON
 
-	// Getting the Docker information out of the JSON format in the STDIN.
-	err := json.Unmarshal([]byte(jsonFromDocker), &m)
-	if err != nil {
-		fmt.Printf("Received error unmarshal: %s \n", err)
+	// Getting the Docker information out of the JSON format in the first argument.
+	if err := json.Unmarshal([]byte(jsonFromDocker), &m); err != nil {
+		fail("Received error unmarshal: %s", err)
 	}
 
 	// Use this local function to fill-in the Extractor.
 	extractorResult, err := exampleExternalDockerMetadataExtractor(&m)
 	if err != nil {
-		fmt.Printf("Received error extractor: %s \n", err)
+		fail("Received error extractor: %s", err)
 	}
 
 	// Transfer the resultin PURuntime in JSON format.
 	jsonResult, err := json.Marshal(extractorResult)
 	if err != nil {
-		fmt.Printf("Received error marshal: %s \n", err)
+		fail("Received error marshal: %s", err)
 	}
 
 	// Write it out on STDOUT.
 	if _, err = os.Stdout.Write(jsonResult); err != nil {
-		fmt.Printf("Failed to write JSON to stdout")
+		fail("Failed to write JSON to stdout: %s", err)
 	}
 }
 
+// fail reports an error on STDERR and exits with a non-zero code, so that
+// Trireme doesn't mistake the message for a PURuntime.
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...) // nolint
+	os.Exit(1)
+}
+
 func exampleExternalDockerMetadataExtractor(info *types.ContainerJSON) (*policy.PURuntime, error) {
 
 	tagsMap := policy.NewTagStoreFromMap(map[string]string{
@@ -0,0 +1,85 @@
+package extractors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// DefaultExternalExtractorTimeout is the time an external extractor has to return
+const DefaultExternalExtractorTimeout = 5 * time.Second
+
+// NewExternalExtractor returns a metadata extractor that delegates to an external
+// executable. The executable receives the JSON of the container as its first
+// argument and must write the JSON of the PURuntime on its standard output.
+// Executions that exceed the timeout are killed.
+func NewExternalExtractor(executable string, timeout time.Duration) (func(*types.ContainerJSON) (*policy.PURuntime, error), error) {
+
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		return nil, fmt.Errorf("external extractor not found: %s", err)
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultExternalExtractorTimeout
+	}
+
+	return func(info *types.ContainerJSON) (*policy.PURuntime, error) {
+
+		input, err := json.Marshal(info)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode container %s: %s", info.ID, err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(path, string(input))
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		// Run the extractor in its own process group, so that the processes
+		// it spawns are killed with it on timeout.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		if err = cmd.Start(); err != nil {
+			return nil, fmt.Errorf("unable to start external extractor %s: %s", path, err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case err = <-done:
+		case <-timer.C:
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) // nolint
+			<-done
+			return nil, fmt.Errorf("external extractor %s timed out after %s", path, timeout)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("external extractor %s failed: %s: %s", path, err, strings.TrimSpace(stderr.String()))
+		}
+
+		output := bytes.TrimSpace(stdout.Bytes())
+		if len(output) == 0 {
+			return nil, fmt.Errorf("external extractor %s returned no runtime", path)
+		}
+
+		runtime := &policy.PURuntime{}
+		if err = json.Unmarshal(output, runtime); err != nil {
+			return nil, fmt.Errorf("external extractor %s returned an invalid runtime: %s", path, err)
+		}
+
+		return runtime, nil
+	}, nil
+}
@@ -0,0 +1,166 @@
+package extractors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// newTestContainer returns the inspected Docker container with the given labels
+func newTestContainer(name string, labels map[string]string) *types.ContainerJSON {
+	return &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Name:  name,
+			State: &types.ContainerState{Pid: 42},
+		},
+		Config: &container.Config{
+			Image:  "nginx",
+			Labels: labels,
+		},
+	}
+}
+
+// writeTestScript writes an executable shell script in dir
+func writeTestScript(t *testing.T, dir, name, script string) string {
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// processGone returns true once a process has exited, waiting at most timeout
+func processGone(pid int, timeout time.Duration) bool {
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		// An exited process may be left as a zombie until it is reaped
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestExternalExtractor(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "extractors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{
+			// The container is passed as the first argument
+			name: "success",
+			script: `case "$1" in
+*'"Id":"c1"'*) echo '{"Name": "web", "Pid": 42, "Tags": {"Tags": ["app=web"]}}' ;;
+*) exit 3 ;;
+esac
+`,
+		},
+		{
+			name:   "non-zero exit",
+			script: "echo 'no labels' >&2\nexit 2\n",
+			err:    "failed: exit status 2: no labels",
+		},
+		{
+			name:   "malformed JSON",
+			script: `echo '{"Name": '` + "\n",
+			err:    "returned an invalid runtime",
+		},
+		{
+			name:   "no output",
+			script: "exit 0\n",
+			err:    "returned no runtime",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			extract, nerr := NewExternalExtractor(writeTestScript(t, dir, strconv.Itoa(i), tt.script), time.Minute)
+			if nerr != nil {
+				t.Fatal(nerr)
+			}
+
+			info := newTestContainer("/web", nil)
+			info.ID = "c1"
+
+			runtime, xerr := extract(info)
+			if tt.err != "" {
+				if xerr == nil || !strings.Contains(xerr.Error(), tt.err) {
+					t.Errorf("error = %v, want %q", xerr, tt.err)
+				}
+				return
+			}
+			if xerr != nil {
+				t.Fatal(xerr)
+			}
+
+			if value, ok := runtime.Tags().Get("app"); runtime.Name() != "web" || runtime.Pid() != 42 || !ok || value != "web" {
+				t.Errorf("runtime = %s %d %v, want web 42 app=web", runtime.Name(), runtime.Pid(), runtime.Tags())
+			}
+		})
+	}
+
+	if _, err = NewExternalExtractor(filepath.Join(dir, "missing"), time.Minute); err == nil {
+		t.Errorf("expected an error for a missing executable")
+	}
+}
+
+func TestExternalExtractorTimeout(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "extractors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	// The script starts a child that keeps its output open and records its PID
+	childFile := filepath.Join(dir, "child")
+	script := writeTestScript(t, dir, "slow", fmt.Sprintf("sleep 30 &\necho $! > %s\nwait\n", childFile))
+
+	extract, err := NewExternalExtractor(script, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err = extract(newTestContainer("/web", nil)); err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("extractor returned after %s, want about 200ms", elapsed)
+	}
+
+	content, err := ioutil.ReadFile(childFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatalf("invalid child PID %q", content)
+	}
+	if !processGone(child, 5*time.Second) {
+		t.Errorf("child process %d was not killed", child)
+	}
+}
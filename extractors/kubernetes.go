@@ -0,0 +1,246 @@
+package extractors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	dockerClient "github.com/docker/docker/client"
+)
+
+// Labels set by the kubelet on the Docker containers of a pod
+const (
+	kubernetesPodNameLabel       = "io.kubernetes.pod.name"
+	kubernetesPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	kubernetesContainerNameLabel = "io.kubernetes.container.name"
+	kubernetesDockerTypeLabel    = "io.kubernetes.docker.type"
+	kubernetesSandboxIDLabel     = "io.kubernetes.sandbox.id"
+	kubernetesLabelPrefix        = "io.kubernetes."
+	kubernetesAnnotationPrefix   = "annotation."
+	kubernetesInfraContainerName = "POD"
+	kubernetesSandboxType        = "podsandbox"
+)
+
+// DefaultKubernetesCacheTTL is the default time the labels of a pod are cached
+const DefaultKubernetesCacheTTL = 30 * time.Second
+
+// kubernetesRequestTimeout is the time a request to the API server or to
+// Docker has to complete. The requests of the Kubernetes client have no
+// context: the timeout is set on the client.
+const kubernetesRequestTimeout = 5 * time.Second
+
+// ErrKubernetesInfraContainer is returned for the infra (pause) container of a pod,
+// which is not handled as a PU.
+var ErrKubernetesInfraContainer = errors.New("kubernetes infra container ignored")
+
+// cachedPodLabels are the cached labels of a pod
+type cachedPodLabels struct {
+	labels  map[string]string
+	expires time.Time
+}
+
+// KubernetesExtractor is a metadata extractor for the Docker containers of
+// Kubernetes pods. It uses the namespace, the name and the labels of the pod
+// of a container for policy decisions. The labels read from the API server
+// are cached.
+type KubernetesExtractor struct {
+	client kubernetes.Interface
+	docker *dockerClient.Client
+	ttl    time.Duration
+	pods   map[string]*cachedPodLabels
+	sync.Mutex
+}
+
+// NewKubernetesExtractor creates a metadata extractor for Kubernetes. The labels
+// of the pods are taken from the labels the kubelet sets on their sandbox
+// containers, read with a Docker client configured from the environment. If
+// kubeconfig is not empty, the labels are read from the API server instead
+// and cached for ttl.
+func NewKubernetesExtractor(kubeconfig string, ttl time.Duration) (*KubernetesExtractor, error) {
+
+	docker, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Docker client: %s", err)
+	}
+
+	if kubeconfig == "" {
+		return newKubernetesExtractor(nil, docker, ttl), nil
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig %s: %s", kubeconfig, err)
+	}
+	config.Timeout = kubernetesRequestTimeout
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Kubernetes client: %s", err)
+	}
+
+	return newKubernetesExtractor(client, docker, ttl), nil
+}
+
+// newKubernetesExtractor creates a metadata extractor reading the labels of the
+// pods with the given client, if not nil, or from the sandbox containers with
+// the Docker client
+func newKubernetesExtractor(client kubernetes.Interface, docker *dockerClient.Client, ttl time.Duration) *KubernetesExtractor {
+
+	return &KubernetesExtractor{
+		client: client,
+		docker: docker,
+		ttl:    ttl,
+		pods:   map[string]*cachedPodLabels{},
+	}
+}
+
+// Extract implements the Docker metadata extractor. Containers that are not part
+// of a pod keep their Docker labels.
+func (k *KubernetesExtractor) Extract(info *types.ContainerJSON) (*policy.PURuntime, error) {
+
+	labels := info.Config.Labels
+
+	if labels[kubernetesDockerTypeLabel] == kubernetesSandboxType || labels[kubernetesContainerNameLabel] == kubernetesInfraContainerName {
+		return nil, ErrKubernetesInfraContainer
+	}
+
+	tags := policy.NewTagStoreFromMap(map[string]string{
+		"image": info.Config.Image,
+		"name":  info.Name,
+	})
+
+	podName, ok := labels[kubernetesPodNameLabel]
+	if !ok {
+		for key, value := range labels {
+			tags.AppendKeyValue("@usr:"+key, value)
+		}
+	} else {
+		podNamespace := labels[kubernetesPodNamespaceLabel]
+		tags.AppendKeyValue("@usr:namespace", podNamespace)
+		tags.AppendKeyValue("@usr:pod", podName)
+
+		for key, value := range k.podLabels(podNamespace, podName, labels[kubernetesSandboxIDLabel]) {
+			tags.AppendKeyValue("@usr:"+key, value)
+		}
+	}
+
+	ipa := policy.ExtendedMap{}
+	if info.NetworkSettings != nil {
+		ipa["bridge"] = info.NetworkSettings.IPAddress
+	}
+
+	return policy.NewPURuntime(info.Name, info.State.Pid, "", tags, ipa, common.ContainerPU, nil), nil
+}
+
+// podLabels returns the labels of a pod. They are read from the cache or the
+// API server if possible, or from the labels of the sandbox container of the
+// pod otherwise: the kubelet only sets the labels of the pod on that container.
+func (k *KubernetesExtractor) podLabels(namespace, name, sandboxID string) map[string]string {
+
+	if k.client != nil {
+		labels, err := k.apiPodLabels(namespace, name)
+		if err == nil {
+			return labels
+		}
+		zap.L().Warn("Unable to get pod from the API server - using sandbox labels",
+			zap.String("namespace", namespace),
+			zap.String("pod", name),
+			zap.Error(err),
+		)
+	}
+
+	labels, err := k.sandboxLabels(sandboxID)
+	if err != nil {
+		zap.L().Warn("Unable to get pod sandbox - using no labels",
+			zap.String("namespace", namespace),
+			zap.String("pod", name),
+			zap.String("sandbox", sandboxID),
+			zap.Error(err),
+		)
+	}
+
+	return labels
+}
+
+// sandboxLabels returns the labels of a pod set by the kubelet on its sandbox
+// container, without the labels of the kubelet itself
+func (k *KubernetesExtractor) sandboxLabels(sandboxID string) (map[string]string, error) {
+
+	labels := map[string]string{}
+
+	if sandboxID == "" {
+		return labels, fmt.Errorf("no %s label on the container", kubernetesSandboxIDLabel)
+	}
+
+	if k.docker == nil {
+		return labels, fmt.Errorf("no Docker client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesRequestTimeout)
+	defer cancel()
+
+	sandbox, err := k.docker.ContainerInspect(ctx, sandboxID)
+	if err != nil {
+		return labels, err
+	}
+	if sandbox.Config == nil {
+		return labels, nil
+	}
+
+	for key, value := range sandbox.Config.Labels {
+		if strings.HasPrefix(key, kubernetesLabelPrefix) || strings.HasPrefix(key, kubernetesAnnotationPrefix) {
+			continue
+		}
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
+// apiPodLabels returns the labels of a pod from the cache, or from the API
+// server if they are missing or expired
+func (k *KubernetesExtractor) apiPodLabels(namespace, name string) (map[string]string, error) {
+
+	key := namespace + "/" + name
+
+	k.Lock()
+	cached, ok := k.pods[key]
+	k.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.labels, nil
+	}
+
+	pod, err := k.client.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	k.Lock()
+	k.pods[key] = &cachedPodLabels{
+		labels:  pod.Labels,
+		expires: now.Add(k.ttl),
+	}
+	// Drop the pods that expired, they are gone or will be read again
+	for podKey, podLabels := range k.pods {
+		if now.After(podLabels.expires) {
+			delete(k.pods, podKey)
+		}
+	}
+	k.Unlock()
+
+	return pod.Labels, nil
+}
@@ -0,0 +1,320 @@
+package extractors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	dockerClient "github.com/docker/docker/client"
+)
+
+// testSandboxID is the ID of the sandbox container of the pod web-1
+const testSandboxID = "0123456789ab"
+
+// podContainerLabels returns the labels the kubelet sets on a container of the
+// pod web-1 of the namespace shop. The labels of the pod are only set on the
+// sandbox container.
+func podContainerLabels(containerName string) map[string]string {
+	return map[string]string{
+		kubernetesPodNameLabel:                    "web-1",
+		kubernetesPodNamespaceLabel:               "shop",
+		kubernetesContainerNameLabel:              containerName,
+		kubernetesSandboxIDLabel:                  testSandboxID,
+		"annotation.io.kubernetes.container.hash": "1234",
+	}
+}
+
+// newTestDockerClient returns a Docker client for a Docker API served by
+// handler, and a function to stop the server
+func newTestDockerClient(t *testing.T, handler http.HandlerFunc) (*dockerClient.Client, func()) {
+
+	server := httptest.NewServer(handler)
+
+	client, err := dockerClient.NewClient("tcp://"+server.Listener.Addr().String(), "1.29", nil, nil)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return client, server.Close
+}
+
+// writeJSON writes an object as the JSON body of a response
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Error(err)
+	}
+}
+
+// sandboxHandler serves the sandbox container of the pod web-1 on the Docker API
+func sandboxHandler(t *testing.T) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !strings.HasSuffix(r.URL.Path, "/containers/"+testSandboxID+"/json") {
+			http.Error(w, `{"message": "No such container"}`, http.StatusNotFound)
+			return
+		}
+
+		writeJSON(t, w, &types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: testSandboxID, Name: "/k8s_POD_web-1"},
+			Config: &container.Config{
+				Image: "k8s.gcr.io/pause:3.1",
+				Labels: map[string]string{
+					kubernetesPodNameLabel:                 "web-1",
+					kubernetesPodNamespaceLabel:            "shop",
+					kubernetesDockerTypeLabel:              kubernetesSandboxType,
+					"annotation.kubernetes.io/config.seen": "2018-06-01T10:00:00Z",
+					"app":                                  "web",
+				},
+			},
+		})
+	}
+}
+
+// apiServer is a fake Kubernetes API server knowing the pod web-1 of the
+// namespace shop
+type apiServer struct {
+	*httptest.Server
+	requests int32
+}
+
+// newAPIServer starts a fake API server answering with the given status
+func newAPIServer(t *testing.T, status int) *apiServer {
+
+	s := &apiServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&s.requests, 1)
+
+		if status != http.StatusOK {
+			http.Error(w, `{"kind": "Status", "status": "Failure"}`, status)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/shop/pods/web-1" {
+			http.Error(w, `{"kind": "Status", "status": "Failure", "reason": "NotFound"}`, http.StatusNotFound)
+			return
+		}
+
+		writeJSON(t, w, &v1.Pod{
+			TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web-1",
+				Namespace: "shop",
+				Labels:    map[string]string{"app": "web", "tier": "front"},
+			},
+		})
+	}))
+
+	return s
+}
+
+// client returns a Kubernetes client for the fake API server
+func (s *apiServer) client(t *testing.T) kubernetes.Interface {
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: s.URL, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestKubernetesExtract(t *testing.T) {
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-1",
+			Namespace: "shop",
+			Labels:    map[string]string{"app": "web", "tier": "front"},
+		},
+	}
+
+	api := newAPIServer(t, http.StatusOK)
+	defer api.Close()
+	failing := newAPIServer(t, http.StatusInternalServerError)
+	defer failing.Close()
+
+	docker, stop := newTestDockerClient(t, sandboxHandler(t))
+	defer stop()
+
+	labels := podContainerLabels("nginx")
+	noSandbox := podContainerLabels("nginx")
+	noSandbox[kubernetesSandboxIDLabel] = "gone"
+
+	tests := []struct {
+		name   string
+		client kubernetes.Interface
+		labels map[string]string
+		tags   map[string]string
+		absent []string
+	}{
+		{
+			name:   "labels from the API server",
+			client: api.client(t),
+			labels: labels,
+			tags:   map[string]string{"@usr:namespace": "shop", "@usr:pod": "web-1", "@usr:app": "web", "@usr:tier": "front"},
+			absent: []string{"@usr:" + kubernetesPodNameLabel},
+		},
+		{
+			name:   "labels from a client",
+			client: fake.NewSimpleClientset(pod),
+			labels: labels,
+			tags:   map[string]string{"@usr:namespace": "shop", "@usr:pod": "web-1", "@usr:app": "web", "@usr:tier": "front"},
+		},
+		{
+			name:   "labels from the sandbox",
+			labels: labels,
+			tags:   map[string]string{"@usr:namespace": "shop", "@usr:pod": "web-1", "@usr:app": "web"},
+			absent: []string{
+				"@usr:tier",
+				"@usr:" + kubernetesPodNameLabel,
+				"@usr:" + kubernetesDockerTypeLabel,
+				"@usr:annotation.kubernetes.io/config.seen",
+				"@usr:annotation.io.kubernetes.container.hash",
+			},
+		},
+		{
+			name:   "API server failing",
+			client: failing.client(t),
+			labels: labels,
+			tags:   map[string]string{"@usr:namespace": "shop", "@usr:pod": "web-1", "@usr:app": "web"},
+			absent: []string{"@usr:tier"},
+		},
+		{
+			name:   "sandbox missing",
+			labels: noSandbox,
+			tags:   map[string]string{"@usr:namespace": "shop", "@usr:pod": "web-1"},
+			absent: []string{"@usr:app", "@usr:annotation.io.kubernetes.container.hash"},
+		},
+		{
+			name:   "container not in a pod",
+			client: api.client(t),
+			labels: map[string]string{"app": "standalone"},
+			tags:   map[string]string{"@usr:app": "standalone", "image": "nginx"},
+			absent: []string{"@usr:namespace", "@usr:pod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			k := newKubernetesExtractor(tt.client, docker, time.Minute)
+
+			runtime, err := k.Extract(newTestContainer("/k8s_nginx_web-1", tt.labels))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags := runtime.Tags()
+			for key, want := range tt.tags {
+				if value, ok := tags.Get(key); !ok || value != want {
+					t.Errorf("tag %s = %q, want %q", key, value, want)
+				}
+			}
+			for _, key := range tt.absent {
+				if value, ok := tags.Get(key); ok {
+					t.Errorf("unexpected tag %s=%s", key, value)
+				}
+			}
+		})
+	}
+}
+
+func TestKubernetesExtractSkipsInfraContainer(t *testing.T) {
+
+	sandbox := podContainerLabels("")
+	delete(sandbox, kubernetesContainerNameLabel)
+	sandbox[kubernetesDockerTypeLabel] = kubernetesSandboxType
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{name: "sandbox", labels: sandbox},
+		{name: "pause", labels: podContainerLabels(kubernetesInfraContainerName)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			client := fake.NewSimpleClientset()
+			k := newKubernetesExtractor(client, nil, time.Minute)
+
+			if _, err := k.Extract(newTestContainer("/k8s_POD_web-1", tt.labels)); err != ErrKubernetesInfraContainer {
+				t.Errorf("error = %v, want %v", err, ErrKubernetesInfraContainer)
+			}
+			if len(client.Actions()) != 0 {
+				t.Errorf("the API server was queried for an infra container")
+			}
+		})
+	}
+}
+
+func TestKubernetesPodLabelsCache(t *testing.T) {
+
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "shop", Labels: map[string]string{"app": "web"}},
+	})
+
+	k := newKubernetesExtractor(client, nil, time.Minute)
+	for i := 0; i < 3; i++ {
+		if labels := k.podLabels("shop", "web-1", ""); labels["app"] != "web" {
+			t.Fatalf("labels = %v, want app=web", labels)
+		}
+	}
+	if len(client.Actions()) != 1 {
+		t.Errorf("requests = %d, want 1", len(client.Actions()))
+	}
+
+	// Expired labels are read again
+	k.pods["shop/web-1"].expires = time.Now().Add(-time.Second)
+	k.podLabels("shop", "web-1", "")
+	if len(client.Actions()) != 2 {
+		t.Errorf("requests = %d, want 2", len(client.Actions()))
+	}
+
+	// Missing pods are not cached
+	k.podLabels("shop", "db-1", "")
+	if _, ok := k.pods["shop/db-1"]; ok {
+		t.Errorf("missing pod db-1 was cached")
+	}
+}
+
+func TestKubernetesPodLabelsFromTheAPIServer(t *testing.T) {
+
+	api := newAPIServer(t, http.StatusOK)
+	defer api.Close()
+
+	k := newKubernetesExtractor(api.client(t), nil, time.Minute)
+	for i := 0; i < 3; i++ {
+		if labels := k.podLabels("shop", "web-1", ""); labels["app"] != "web" || labels["tier"] != "front" {
+			t.Fatalf("labels = %v, want app=web and tier=front", labels)
+		}
+	}
+	if requests := atomic.LoadInt32(&api.requests); requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+
+	// Missing pods are neither found nor cached
+	if labels := k.podLabels("shop", "db-1", ""); len(labels) != 0 {
+		t.Errorf("labels = %v, want none for a missing pod", labels)
+	}
+	if _, ok := k.pods["shop/db-1"]; ok {
+		t.Errorf("missing pod db-1 was cached")
+	}
+}
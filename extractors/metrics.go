@@ -0,0 +1,23 @@
+package extractors
+
+import (
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"github.com/docker/docker/api/types"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// Instrument returns an extractor counting the failures of extractor under the
+// given name. The Kubernetes infra containers are ignored on purpose and are
+// not counted.
+func Instrument(name string, extractor func(*types.ContainerJSON) (*policy.PURuntime, error)) func(*types.ContainerJSON) (*policy.PURuntime, error) {
+
+	return func(info *types.ContainerJSON) (*policy.PURuntime, error) {
+
+		runtime, err := extractor(info)
+		if err != nil && err != ErrKubernetesInfraContainer {
+			metrics.ExtractorErrors.WithLabelValues(name).Inc()
+		}
+
+		return runtime, err
+	}
+}
@@ -0,0 +1,52 @@
+package extractors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"github.com/docker/docker/api/types"
+	dto "github.com/prometheus/client_model/go"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// extractorErrors returns the number of failures counted for an extractor
+func extractorErrors(t *testing.T, name string) float64 {
+
+	m := &dto.Metric{}
+	if err := metrics.ExtractorErrors.WithLabelValues(name).Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m.GetCounter().GetValue()
+}
+
+func TestInstrument(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		err     error
+		counted float64
+	}{
+		{name: "instrument-success"},
+		{name: "instrument-failure", err: fmt.Errorf("no labels"), counted: 1},
+		{name: "instrument-infra", err: ErrKubernetesInfraContainer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			extractor := Instrument(tt.name, func(*types.ContainerJSON) (*policy.PURuntime, error) {
+				return nil, tt.err
+			})
+
+			before := extractorErrors(t, tt.name)
+			if _, err := extractor(newTestContainer("/web", nil)); err != tt.err {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+			if counted := extractorErrors(t, tt.name) - before; counted != tt.counted {
+				t.Errorf("failures counted = %v, want %v", counted, tt.counted)
+			}
+		})
+	}
+}
@@ -3,38 +3,116 @@ package extractors
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/policy"
+	"go.uber.org/zap"
 
 	dockerClient "github.com/docker/docker/client"
 )
 
+// DefaultSwarmCacheTTL is the default time the labels of a swarm service are cached
+const DefaultSwarmCacheTTL = 30 * time.Second
+
+const (
+	swarmServiceIDLabel   = "com.docker.swarm.service.id"
+	swarmServiceEventType = "service"
+	swarmEventsRetryDelay = 5 * time.Second
+)
+
+// serviceLabels are the cached labels of a swarm service
+type serviceLabels struct {
+	labels  map[string]string
+	expires time.Time
+}
+
 // SwarmExtractor is an example metadata extractor for swarm that uses the service
-// labels for policy decisions
-func SwarmExtractor(info *types.ContainerJSON) (*policy.PURuntime, error) {
+// labels for policy decisions. It shares one Docker client between all the container
+// events and caches the labels of the services.
+type SwarmExtractor struct {
+	client   *dockerClient.Client
+	ttl      time.Duration
+	services map[string]*serviceLabels
+	// generation changes every time cached labels are dropped, so that the
+	// labels read from Docker meanwhile are not cached
+	generation uint64
+	sync.Mutex
+}
 
-	// Create a docker client
-	defaultHeaders := map[string]string{"User-Agent": "engine-api-dockerClient-1.0"}
-	cli, err := dockerClient.NewClient("unix:///var/run/docker.sock", "v1.23", nil, defaultHeaders)
+// NewSwarmExtractor creates a swarm metadata extractor. The Docker client is configured
+// from the environment (DOCKER_HOST, DOCKER_API_VERSION, DOCKER_TLS_VERIFY and
+// DOCKER_CERT_PATH) and the labels of a service are cached for ttl.
+func NewSwarmExtractor(ttl time.Duration) (*SwarmExtractor, error) {
+
+	cli, err := dockerClient.NewEnvClient()
 	if err != nil {
-		return nil, fmt.Errorf("Error creating Docker client %s", err)
+		return nil, fmt.Errorf("unable to create Docker client: %s", err)
+	}
+
+	return newSwarmExtractor(cli, ttl), nil
+}
+
+// newSwarmExtractor creates a swarm metadata extractor with the given Docker client
+func newSwarmExtractor(client *dockerClient.Client, ttl time.Duration) *SwarmExtractor {
+
+	return &SwarmExtractor{
+		client:   client,
+		ttl:      ttl,
+		services: map[string]*serviceLabels{},
 	}
+}
+
+// Run watches the swarm service events to invalidate the cache until ctx is done
+func (s *SwarmExtractor) Run(ctx context.Context) {
+
+	args := filters.NewArgs()
+	args.Add("type", swarmServiceEventType)
+
+	for {
+		messages, errs := s.client.Events(ctx, types.EventsOptions{Filters: args})
+
+	watch:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message := <-messages:
+				s.handleEvent(message)
+			case err := <-errs:
+				zap.L().Warn("Lost swarm service events - retrying", zap.Error(err))
+				break watch
+			}
+		}
+
+		// Events may have been missed while disconnected
+		s.flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(swarmEventsRetryDelay):
+		}
+	}
+}
+
+// Extract implements the Docker metadata extractor
+func (s *SwarmExtractor) Extract(info *types.ContainerJSON) (*policy.PURuntime, error) {
 
 	// Get the labels from Docker. If it is a swarm service, get the labels from
 	// the service definition instead.
 	dockerLabels := info.Config.Labels
-	if _, ok := info.Config.Labels["com.docker.swarm.service.id"]; ok {
-
-		serviceID := info.Config.Labels["com.docker.swarm.service.id"]
-
-		service, _, err := cli.ServiceInspectWithRaw(context.Background(), serviceID, types.ServiceInspectOptions{})
+	if serviceID, ok := info.Config.Labels[swarmServiceIDLabel]; ok {
+		labels, err := s.serviceLabels(serviceID)
 		if err != nil {
-			return nil, fmt.Errorf("Failed get swarm labels: %s", err)
+			return nil, fmt.Errorf("failed to get swarm labels: %s", err)
 		}
 
-		dockerLabels = service.Spec.Labels
+		dockerLabels = labels
 	}
 
 	// Create the tags based on the docker labels
@@ -53,3 +131,61 @@ func SwarmExtractor(info *types.ContainerJSON) (*policy.PURuntime, error) {
 
 	return policy.NewPURuntime(info.Name, info.State.Pid, "", tags, ipa, common.ContainerPU, nil), nil
 }
+
+// serviceLabels returns the labels of a service from the cache, or from Docker
+// if they are missing or expired. The labels read from Docker are not cached if
+// the cache was invalidated meanwhile, as they may predate the change.
+func (s *SwarmExtractor) serviceLabels(serviceID string) (map[string]string, error) {
+
+	s.Lock()
+	cached, ok := s.services[serviceID]
+	generation := s.generation
+	s.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.labels, nil
+	}
+
+	service, _, err := s.client.ServiceInspectWithRaw(context.Background(), serviceID, types.ServiceInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	if s.generation == generation {
+		s.services[serviceID] = &serviceLabels{
+			labels:  service.Spec.Labels,
+			expires: time.Now().Add(s.ttl),
+		}
+	}
+	s.Unlock()
+
+	return service.Spec.Labels, nil
+}
+
+// handleEvent drops the cached labels of an updated or removed service
+func (s *SwarmExtractor) handleEvent(message events.Message) {
+
+	if message.Type != swarmServiceEventType {
+		return
+	}
+
+	zap.L().Debug("Swarm service changed",
+		zap.String("service", message.Actor.ID),
+		zap.String("action", string(message.Action)),
+	)
+
+	s.Lock()
+	delete(s.services, message.Actor.ID)
+	s.generation++
+	s.Unlock()
+}
+
+// flush drops all the cached labels
+func (s *SwarmExtractor) flush() {
+
+	s.Lock()
+	s.services = map[string]*serviceLabels{}
+	s.generation++
+	s.Unlock()
+}
@@ -0,0 +1,182 @@
+package extractors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// testServiceID is the ID of the swarm service of the test containers
+const testServiceID = "svc1"
+
+// serviceHandler serves the service svc1 on the Docker API. Its label version
+// is the number of requests received. before is called for every request
+// before answering, if not nil.
+func serviceHandler(t *testing.T, requests *int32, before func()) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !strings.HasSuffix(r.URL.Path, "/services/"+testServiceID) {
+			http.Error(w, `{"message": "service not found"}`, http.StatusNotFound)
+			return
+		}
+
+		version := atomic.AddInt32(requests, 1)
+		if before != nil {
+			before()
+		}
+
+		service := swarm.Service{ID: testServiceID}
+		service.Spec.Name = "web"
+		service.Spec.Labels = map[string]string{"app": "web", "version": strconv.Itoa(int(version))}
+		writeJSON(t, w, service)
+	}
+}
+
+// serviceVersion extracts a container of the service svc1 and returns the
+// version label of its tags
+func serviceVersion(t *testing.T, s *SwarmExtractor) string {
+
+	runtime, err := s.Extract(newTestContainer("/web.1", map[string]string{swarmServiceIDLabel: testServiceID}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, _ := runtime.Tags().Get("version") // nolint
+
+	return version
+}
+
+func TestSwarmServiceLabelsCache(t *testing.T) {
+
+	var requests int32
+	client, stop := newTestDockerClient(t, serviceHandler(t, &requests, nil))
+	defer stop()
+
+	s := newSwarmExtractor(client, time.Minute)
+
+	steps := []struct {
+		name     string
+		before   func()
+		version  string
+		requests int32
+	}{
+		{
+			name:     "miss",
+			version:  "1",
+			requests: 1,
+		},
+		{
+			name:     "hit",
+			version:  "1",
+			requests: 1,
+		},
+		{
+			name: "TTL expired",
+			before: func() {
+				s.services[testServiceID].expires = time.Now().Add(-time.Second)
+			},
+			version:  "2",
+			requests: 2,
+		},
+		{
+			name: "other event",
+			before: func() {
+				s.handleEvent(events.Message{Type: "container", Actor: events.Actor{ID: testServiceID}})
+			},
+			version:  "2",
+			requests: 2,
+		},
+		{
+			name: "service event",
+			before: func() {
+				s.handleEvent(events.Message{Type: swarmServiceEventType, Action: "update", Actor: events.Actor{ID: testServiceID}})
+			},
+			version:  "3",
+			requests: 3,
+		},
+		{
+			name:     "flush",
+			before:   s.flush,
+			version:  "4",
+			requests: 4,
+		},
+	}
+
+	// The steps depend on each other: they are not run as subtests
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+		if version := serviceVersion(t, s); version != step.version {
+			t.Errorf("%s: version = %s, want %s", step.name, version, step.version)
+		}
+		if got := atomic.LoadInt32(&requests); got != step.requests {
+			t.Errorf("%s: requests = %d, want %d", step.name, got, step.requests)
+		}
+	}
+
+	if _, err := s.Extract(newTestContainer("/db.1", map[string]string{swarmServiceIDLabel: "gone"})); err == nil {
+		t.Errorf("expected an error for a missing service")
+	}
+}
+
+func TestSwarmInvalidationDuringInspect(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		invalidate func(s *SwarmExtractor)
+	}{
+		{
+			name: "service event",
+			invalidate: func(s *SwarmExtractor) {
+				s.handleEvent(events.Message{Type: swarmServiceEventType, Action: "update", Actor: events.Actor{ID: testServiceID}})
+			},
+		},
+		{
+			name:       "flush",
+			invalidate: (*SwarmExtractor).flush,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// The cache is invalidated while the first request is in flight
+			var s *SwarmExtractor
+			var requests int32
+			client, stop := newTestDockerClient(t, serviceHandler(t, &requests, func() {
+				if atomic.LoadInt32(&requests) == 1 {
+					tt.invalidate(s)
+				}
+			}))
+			defer stop()
+
+			s = newSwarmExtractor(client, time.Minute)
+
+			if version := serviceVersion(t, s); version != "1" {
+				t.Errorf("version = %s, want 1", version)
+			}
+			s.Lock()
+			_, cached := s.services[testServiceID]
+			s.Unlock()
+			if cached {
+				t.Errorf("labels read before the invalidation were cached")
+			}
+
+			// The labels read afterwards are cached
+			if version := serviceVersion(t, s); version != "2" {
+				t.Errorf("version = %s, want 2", version)
+			}
+			if version := serviceVersion(t, s); version != "2" || atomic.LoadInt32(&requests) != 2 {
+				t.Errorf("version = %s after %d requests, want 2 from the cache", version, atomic.LoadInt32(&requests))
+			}
+		})
+	}
+}
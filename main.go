@@ -112,6 +112,9 @@ func main() {
 		triremecli.ProcessRun,
 		triremecli.ProcessEnforce,
 		triremecli.ProcessDaemon,
+		triremecli.ProcessPolicy,
+		triremecli.ProcessStatus,
+		triremecli.ProcessFlows,
 		setLogs,
 		func() {
 			banner("14", "20")
@@ -0,0 +1,153 @@
+package management
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/collectors"
+	"github.com/aporeto-inc/trireme-example/policyexample"
+)
+
+// Client queries the management API of the daemon
+type Client struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewClient creates a client of the management API. If address is not empty,
+// the API is reached over TCP with mutual TLS, otherwise on the unix socket.
+func NewClient(socket, address string, tlsConfig *tls.Config) *Client {
+
+	if address != "" {
+		return &Client{
+			client: &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			},
+			baseURL: "https://" + address,
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	return &Client{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		baseURL: "http://trireme-example",
+	}
+}
+
+// Health returns the health of the daemon
+func (c *Client) Health() (*Health, error) {
+
+	health := &Health{}
+
+	return health, c.do(http.MethodGet, "/health", health)
+}
+
+// Status returns the status of the daemon
+func (c *Client) Status() (*Status, error) {
+
+	status := &Status{}
+
+	return status, c.do(http.MethodGet, "/status", status)
+}
+
+// PUs returns the PUs handled by the daemon
+func (c *Client) PUs() ([]*policyexample.PUStatus, error) {
+
+	pus := []*policyexample.PUStatus{}
+
+	return pus, c.do(http.MethodGet, "/pus", &pus)
+}
+
+// PUPolicy returns the policy enforced for a PU
+func (c *Client) PUPolicy(puID string) (*policyexample.EnforcedPolicy, error) {
+
+	puPolicy := &policyexample.EnforcedPolicy{}
+
+	return puPolicy, c.do(http.MethodGet, "/pus/policy?id="+url.QueryEscape(puID), puPolicy)
+}
+
+// Explain returns the policy decision for the traffic between two PUs given by
+// their ID or name. The port is optional, in the form "port/protocol".
+func (c *Client) Explain(src, dst, port string) (*policyexample.Explanation, error) {
+
+	query := url.Values{}
+	query.Set("src", src)
+	query.Set("dst", dst)
+	if port != "" {
+		query.Set("port", port)
+	}
+
+	explanation := &policyexample.Explanation{}
+
+	return explanation, c.do(http.MethodGet, "/pus/explain?"+query.Encode(), explanation)
+}
+
+// Reload asks the daemon to reload its policy file
+func (c *Client) Reload() (*policyexample.ResolverStatus, error) {
+
+	status := &policyexample.ResolverStatus{}
+
+	return status, c.do(http.MethodPost, "/policy/reload", status)
+}
+
+// Bindings returns the bindings of the policy file of the daemon
+func (c *Client) Bindings() ([]*policyexample.Binding, error) {
+
+	bindings := []*policyexample.Binding{}
+
+	return bindings, c.do(http.MethodGet, "/policy/bindings", &bindings)
+}
+
+// TopFlows returns the top talkers and the top rejected flows over the window,
+// up to limit each. A limit of 0 returns all the flows.
+func (c *Client) TopFlows(window time.Duration, limit int) (*collectors.TopFlows, error) {
+
+	query := url.Values{}
+	query.Set("window", window.String())
+	query.Set("limit", strconv.Itoa(limit))
+
+	top := &collectors.TopFlows{}
+
+	return top, c.do(http.MethodGet, "/flows/top?"+query.Encode(), top)
+}
+
+// do sends a request to the API and decodes the response into out
+func (c *Client) do(method, path string, out interface{}) error {
+
+	req, err := http.NewRequest(method, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach the daemon: %s", err)
+	}
+	defer resp.Body.Close() // nolint
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{}
+		if err = json.NewDecoder(resp.Body).Decode(apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("daemon returned %s", resp.Status)
+		}
+		return fmt.Errorf("%s", apiErr.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,92 @@
+package management
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+)
+
+func TestClient(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "management")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint
+
+	resolver := &fakeResolver{
+		pus: []*policyexample.PUStatus{
+			{ID: "a", Name: "web", Type: "process", PolicyIndex: "web", Enforced: true},
+			{ID: "b", Name: "db", Type: "container", PolicyIndex: "default"},
+		},
+		policies: map[string]*policyexample.EnforcedPolicy{
+			"a": {ID: "a", PolicyIndex: "web", Action: "police"},
+		},
+	}
+
+	socket := filepath.Join(dir, "api.sock")
+	server, err := NewServer(resolver, nil, &DaemonInfo{Version: "1.0", AuthType: "PSK"}, socket, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = server.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %s, want 0600", info.Mode().Perm())
+	}
+
+	client := NewClient(socket, "", nil)
+
+	status, err := client.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != "1.0" || status.AuthType != "PSK" || status.PUs != 2 {
+		t.Errorf("status = %+v, want version 1.0, PSK and 2 PUs", status)
+	}
+
+	pus, err := client.PUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pus) != 2 || pus[0].Name != "web" || !pus[0].Enforced || pus[1].Type != "container" {
+		t.Errorf("PUs = %+v, want web and db", pus)
+	}
+
+	puPolicy, err := client.PUPolicy("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if puPolicy.PolicyIndex != "web" {
+		t.Errorf("policy = %+v, want web", puPolicy)
+	}
+
+	// The errors of the API are returned as is
+	if _, err = client.PUPolicy("c"); err == nil || err.Error() != "unknown PU c" {
+		t.Errorf("error = %v, want unknown PU c", err)
+	}
+	if _, err = client.TopFlows(DefaultFlowWindow, DefaultFlowLimit); err == nil || err.Error() != "flow aggregation is disabled" {
+		t.Errorf("error = %v, want flow aggregation is disabled", err)
+	}
+
+	// A daemon that is not running is reported
+	_, err = NewClient(filepath.Join(dir, "missing.sock"), "", nil).Health()
+	if err == nil || !strings.HasPrefix(err.Error(), "unable to reach the daemon") {
+		t.Errorf("error = %v, want unable to reach the daemon", err)
+	}
+}
@@ -0,0 +1,363 @@
+package management
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/collectors"
+	"github.com/aporeto-inc/trireme-example/policyexample"
+	"go.uber.org/zap"
+)
+
+// Resolver is the policy engine exposed by the management API
+type Resolver interface {
+	PUs() []*policyexample.PUStatus
+	PUPolicy(puID string) (*policyexample.EnforcedPolicy, error)
+	Status() *policyexample.ResolverStatus
+	Explain(src, dst, port, protocol string) (*policyexample.Explanation, error)
+	Reload(ctx context.Context) ([]*policyexample.PUReloadError, error)
+}
+
+// FlowStats are the aggregated flows exposed by the management API
+type FlowStats interface {
+	Top(window time.Duration, limit int) (*collectors.TopFlows, error)
+}
+
+// Server serves the management API of the daemon
+type Server struct {
+	resolver  Resolver
+	flows     FlowStats
+	info      *DaemonInfo
+	socket    string
+	address   string
+	tlsConfig *tls.Config
+	mux       *http.ServeMux
+	// ctx is the context the API is served with, used by the requests that
+	// outlive the connection
+	ctx context.Context
+}
+
+// NewServer creates the management API server. The API is served on the unix
+// socket and, if address is not empty, over TCP with mutual TLS. The flows are
+// optional.
+func NewServer(resolver Resolver, flows FlowStats, info *DaemonInfo, socket, address string, tlsConfig *tls.Config) (*Server, error) {
+
+	if socket == "" && address == "" {
+		return nil, fmt.Errorf("either a socket or an address is required")
+	}
+
+	if address != "" && tlsConfig == nil {
+		return nil, fmt.Errorf("TLS is required to serve the API on %s", address)
+	}
+
+	s := &Server{
+		resolver:  resolver,
+		flows:     flows,
+		info:      info,
+		socket:    socket,
+		address:   address,
+		tlsConfig: tlsConfig,
+		mux:       http.NewServeMux(),
+		ctx:       context.Background(),
+	}
+
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/pus", s.handlePUs)
+	s.mux.HandleFunc("/pus/policy", s.handlePUPolicy)
+	s.mux.HandleFunc("/pus/explain", s.handleExplain)
+	s.mux.HandleFunc("/policy/reload", s.handleReload)
+	s.mux.HandleFunc("/policy/bindings", s.handleBindings)
+	s.mux.HandleFunc("/flows/top", s.handleTopFlows)
+
+	return s, nil
+}
+
+// Run starts serving the API. It returns once the listeners are created, and
+// the API is served until the context is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+
+	s.ctx = ctx
+
+	listeners := []net.Listener{}
+
+	if s.socket != "" {
+		// Remove the socket left behind by a previous run
+		if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove socket %s: %s", s.socket, err)
+		}
+
+		l, err := net.Listen("unix", s.socket)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %s: %s", s.socket, err)
+		}
+
+		if err = os.Chmod(s.socket, 0600); err != nil {
+			l.Close() // nolint
+			return fmt.Errorf("unable to secure socket %s: %s", s.socket, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	if s.address != "" {
+		l, err := tls.Listen("tcp", s.address, s.tlsConfig)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close() // nolint
+			}
+			return fmt.Errorf("unable to listen on %s: %s", s.address, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	server := &http.Server{
+		Handler:      s.mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			zap.L().Info("Serving management API", zap.String("address", l.Addr().String()))
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				zap.L().Error("Management API stopped", zap.Error(err))
+			}
+		}(l)
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx) // nolint
+	}()
+
+	return nil
+}
+
+// handleHealth reports the health of the daemon
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	status := s.resolver.Status()
+
+	health := &Health{
+		Status:          "ok",
+		PolicyFile:      status.PolicyFile,
+		LastReload:      status.LastReload,
+		LastReloadError: status.LastReloadError,
+	}
+	if status.LastReloadError != "" || len(status.PUReloadErrors) > 0 {
+		health.Status = "degraded"
+	}
+
+	writeJSON(w, http.StatusOK, health)
+}
+
+// handleStatus reports the status of the daemon
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	status := s.resolver.Status()
+
+	writeJSON(w, http.StatusOK, &Status{
+		Version:         s.info.Version,
+		Revision:        s.info.Revision,
+		AuthType:        s.info.AuthType,
+		TriremeNetworks: status.TriremeNetworks,
+		PolicyFile:      status.PolicyFile,
+		Policies:        status.Policies,
+		LastReload:      status.LastReload,
+		LastReloadError: status.LastReloadError,
+		PUReloadErrors:  status.PUReloadErrors,
+		Bindings:        len(status.Bindings),
+		Audit:           status.Audit,
+		PUs:             len(s.resolver.PUs()),
+	})
+}
+
+// handlePUs lists the PUs handled by the daemon
+func (s *Server) handlePUs(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.resolver.PUs())
+}
+
+// handlePUPolicy returns the policy enforced for the PU given by the id
+// parameter, as passed to the controller
+func (s *Server) handlePUPolicy(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	puID := r.URL.Query().Get("id")
+	if puID == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing id parameter"))
+		return
+	}
+
+	puPolicy, err := s.resolver.PUPolicy(puID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, puPolicy)
+}
+
+// handleExplain explains the policy decision for the traffic between the PUs
+// given by the src and dst parameters, on the optional port parameter in the
+// form "port/protocol"
+func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	src, dst := query.Get("src"), query.Get("dst")
+	if src == "" || dst == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing src or dst parameter"))
+		return
+	}
+
+	port, protocol := "", ""
+	if value := query.Get("port"); value != "" {
+		var err error
+		port, protocol, err = policyexample.ParsePortProtocol(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	explanation, err := s.resolver.Explain(src, dst, port, protocol)
+	if err != nil {
+		status := http.StatusNotFound
+		if _, ok := err.(*policyexample.AmbiguousPUError); ok {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, explanation)
+}
+
+// handleReload reloads the policy file. The status returned lists the PUs
+// whose policy could not be updated. The policies are updated with the context
+// of the server: a client going away must not leave the PUs half updated.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if _, err := s.resolver.Reload(s.ctx); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.resolver.Status())
+}
+
+// handleBindings lists the bindings of the policy file
+func (s *Server) handleBindings(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.resolver.Status().Bindings)
+}
+
+// handleTopFlows returns the top talkers and the top rejected flows over the
+// optional window parameter, 5m by default, up to the optional limit parameter,
+// 10 by default. A limit of 0 returns all the flows.
+func (s *Server) handleTopFlows(w http.ResponseWriter, r *http.Request) {
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	if s.flows == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("flow aggregation is disabled"))
+		return
+	}
+
+	query := r.URL.Query()
+
+	window := DefaultFlowWindow
+	if value := query.Get("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid window %s: %s", value, err))
+			return
+		}
+	}
+
+	limit := DefaultFlowLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %s", value))
+			return
+		}
+	}
+
+	top, err := s.flows.Top(window, limit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, top)
+}
+
+// allowMethod rejects requests that don't use the given method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+
+	return true
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		zap.L().Warn("Unable to write management API response", zap.Error(err))
+	}
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status int, err error) {
+
+	writeJSON(w, status, &Error{Error: err.Error()})
+}
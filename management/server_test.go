@@ -0,0 +1,190 @@
+package management
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+)
+
+// testContextKey tags the context the server runs with
+type testContextKey struct{}
+
+// fakeResolver is a Resolver serving fixed values
+type fakeResolver struct {
+	pus        []*policyexample.PUStatus
+	policies   map[string]*policyexample.EnforcedPolicy
+	reloadCtx  context.Context
+	failed     []*policyexample.PUReloadError
+	explainErr error
+}
+
+func (r *fakeResolver) PUs() []*policyexample.PUStatus { return r.pus }
+
+func (r *fakeResolver) PUPolicy(puID string) (*policyexample.EnforcedPolicy, error) {
+	puPolicy, ok := r.policies[puID]
+	if !ok {
+		return nil, fmt.Errorf("unknown PU %s", puID)
+	}
+	return puPolicy, nil
+}
+
+func (r *fakeResolver) Status() *policyexample.ResolverStatus {
+	return &policyexample.ResolverStatus{PUReloadErrors: r.failed}
+}
+
+func (r *fakeResolver) Explain(src, dst, port, protocol string) (*policyexample.Explanation, error) {
+	if r.explainErr != nil {
+		return nil, r.explainErr
+	}
+	return &policyexample.Explanation{Port: port, Protocol: protocol, Decision: policyexample.DecisionAllow}, nil
+}
+
+func (r *fakeResolver) Reload(ctx context.Context) ([]*policyexample.PUReloadError, error) {
+	r.reloadCtx = ctx
+	return r.failed, nil
+}
+
+// newTestServer creates a server for the resolver, running until the returned
+// function is called
+func newTestServer(t *testing.T, resolver Resolver) (*Server, func()) {
+
+	s, err := NewServer(resolver, nil, &DaemonInfo{}, "unused.sock", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the context is set: the requests are sent to the handlers directly
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey{}, "server"))
+	s.ctx = ctx
+
+	return s, cancel
+}
+
+// serve sends a request to the handlers of the server
+func serve(s *Server, method, target string) *httptest.ResponseRecorder {
+
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+
+	return w
+}
+
+func TestHandleReloadUsesServerContext(t *testing.T) {
+
+	resolver := &fakeResolver{
+		failed: []*policyexample.PUReloadError{{ID: "pu", PolicyIndex: "web", Error: "No policy found"}},
+	}
+
+	s, cancel := newTestServer(t, resolver)
+	defer cancel()
+
+	w := serve(s, http.MethodPost, "/policy/reload")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	if resolver.reloadCtx == nil || resolver.reloadCtx.Value(testContextKey{}) != "server" {
+		t.Errorf("reload did not use the context of the server")
+	}
+
+	status := &policyexample.ResolverStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.PUReloadErrors) != 1 || status.PUReloadErrors[0].ID != "pu" {
+		t.Errorf("reload errors = %v, want pu", status.PUReloadErrors)
+	}
+
+	if w = serve(s, http.MethodGet, "/policy/reload"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	health := &Health{}
+	if err := json.Unmarshal(serve(s, http.MethodGet, "/health").Body.Bytes(), health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "degraded" {
+		t.Errorf("health = %s, want degraded", health.Status)
+	}
+}
+
+func TestHandlePUPolicy(t *testing.T) {
+
+	resolver := &fakeResolver{
+		policies: map[string]*policyexample.EnforcedPolicy{
+			"pu": {ID: "pu", PolicyIndex: "default", Action: "police", Identity: []string{"app=web"}},
+		},
+	}
+
+	s, cancel := newTestServer(t, resolver)
+	defer cancel()
+
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{target: "/pus/policy?id=pu", code: http.StatusOK},
+		{target: "/pus/policy?id=other", code: http.StatusNotFound},
+		{target: "/pus/policy", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+
+			w := serve(s, http.MethodGet, tt.target)
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			puPolicy := &policyexample.EnforcedPolicy{}
+			if err := json.Unmarshal(w.Body.Bytes(), puPolicy); err != nil {
+				t.Fatal(err)
+			}
+			if puPolicy.ID != "pu" || puPolicy.Action != "police" || len(puPolicy.Identity) != 1 {
+				t.Errorf("policy = %+v, want the enforced policy of pu", puPolicy)
+			}
+		})
+	}
+}
+
+func TestHandleExplain(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		code   int
+	}{
+		{name: "explained", target: "/pus/explain?src=a&dst=b&port=53/udp", code: http.StatusOK},
+		{name: "missing dst", target: "/pus/explain?src=a", code: http.StatusBadRequest},
+		{name: "invalid port", target: "/pus/explain?src=a&dst=b&port=http", code: http.StatusBadRequest},
+		{name: "unknown PU", target: "/pus/explain?src=a&dst=b", err: fmt.Errorf("unknown PU a"), code: http.StatusNotFound},
+		{
+			name:   "ambiguous PU",
+			target: "/pus/explain?src=a&dst=b",
+			err:    &policyexample.AmbiguousPUError{IDOrName: "a", Matches: []string{"a1", "a2"}},
+			code:   http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s, cancel := newTestServer(t, &fakeResolver{explainErr: tt.err})
+			defer cancel()
+
+			w := serve(s, http.MethodGet, tt.target)
+			if w.Code != tt.code {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package management
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// ServerTLSConfig creates the mTLS configuration of the TCP API. Clients must
+// present a certificate signed by the CA.
+func ServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+
+	cert, pool, err := loadTLSFiles(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientTLSConfig creates the mTLS configuration used to connect to the TCP API
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+
+	cert, pool, err := loadTLSFiles(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadTLSFiles loads a key pair and a CA certificate
+func loadTLSFiles(certFile, keyFile, caFile string) (tls.Certificate, *x509.CertPool, error) {
+
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return tls.Certificate{}, nil, fmt.Errorf("certificate, key and CA are required for TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("unable to load key pair: %s", err)
+	}
+
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("unable to load CA: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+
+	return cert, pool, nil
+}
@@ -0,0 +1,52 @@
+package management
+
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/policyexample"
+)
+
+// DefaultSocket is the default unix socket of the management API
+const DefaultSocket = "/var/run/trireme-example.sock"
+
+// Defaults of the queries of the aggregated flows
+const (
+	DefaultFlowWindow = 5 * time.Minute
+	DefaultFlowLimit  = 10
+)
+
+// Health is the health of the daemon
+type Health struct {
+	Status          string
+	PolicyFile      string
+	LastReload      time.Time
+	LastReloadError string `json:",omitempty"`
+}
+
+// Status is the status of the daemon
+type Status struct {
+	Version         string
+	Revision        string
+	AuthType        string
+	TriremeNetworks []string
+	PolicyFile      string
+	Policies        []string
+	LastReload      time.Time
+	LastReloadError string                         `json:",omitempty"`
+	PUReloadErrors  []*policyexample.PUReloadError `json:",omitempty"`
+	Bindings        int
+	Audit           bool
+	PUs             int
+}
+
+// DaemonInfo is the static information about the daemon reported by the API
+type DaemonInfo struct {
+	Version  string
+	Revision string
+	AuthType string
+}
+
+// Error is returned by the API when a request fails
+type Error struct {
+	Error string
+}
@@ -0,0 +1,105 @@
+// Package metrics holds the Prometheus metrics of the daemon and serves them
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// namespace prefixes the names of all the metrics
+const namespace = "trireme_example"
+
+var (
+	// Flows counts the flows reported by Trireme by action, policy index of
+	// the PU reporting them and PolicyID of the matching rule
+	Flows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flows_total",
+		Help:      "Flows reported by Trireme by action, policy index and PolicyID.",
+	}, []string{"action", "policy_index", "policy_id"})
+
+	// EnforcedPUs is the number of PUs enforced by type
+	EnforcedPUs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "enforced_pus",
+		Help:      "PUs currently enforced by type.",
+	}, []string{"type"})
+
+	// PUEventDuration is the time taken to handle the events of the PUs
+	PUEventDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pu_event_duration_seconds",
+		Help:      "Time taken to handle a PU event by event.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"event"})
+
+	// EnforcementErrors counts the errors of the controller by operation:
+	// enforce, unenforce or update
+	EnforcementErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "enforcement_errors_total",
+		Help:      "Errors enforcing, unenforcing or updating the policy of a PU.",
+	}, []string{"operation"})
+
+	// ExtractorErrors counts the failures of the metadata extractors
+	ExtractorErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "extractor_errors_total",
+		Help:      "Failures of the metadata extractor by extractor.",
+	}, []string{"extractor"})
+
+	// DroppedFlows counts the flows not sent to a sink because its queue was
+	// full
+	DroppedFlows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_flows_total",
+		Help:      "Flows dropped because the queue of the sink was full, by sink.",
+	}, []string{"sink"})
+)
+
+func init() {
+	prometheus.MustRegister(Flows, EnforcedPUs, PUEventDuration, EnforcementErrors, ExtractorErrors, DroppedFlows)
+}
+
+// Serve serves the metrics on /metrics at the given address. It returns once
+// the listener is created, and the metrics are served until the context is
+// cancelled.
+func Serve(ctx context.Context, address string) error {
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %s", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		zap.L().Info("Serving metrics", zap.String("address", l.Addr().String()))
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("Metrics server stopped", zap.Error(err))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx) // nolint
+	}()
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package policyexample
+
+import (
+	"strings"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// auditPrefix marks the policy ID of the reject rules turned into accept+log
+// by the audit mode
+const auditPrefix = "audit:"
+
+// auditAction is the action of the reject rules in audit mode
+const auditAction = policy.Accept | policy.Log
+
+// auditDefaultPolicyID is the policy ID of the rules accepting the traffic no
+// other rule matches in audit mode, that the default deny would reject
+const auditDefaultPolicyID = "default"
+
+// AuditPolicyID returns the policy ID reported for the flows accepted by a
+// reject rule in audit mode
+func AuditPolicyID(policyID string) string {
+
+	return auditPrefix + policyID
+}
+
+// ParseAuditPolicyID returns the policy ID of the reject rule that would have
+// rejected a flow accepted in audit mode. It returns false for the policy IDs
+// of the other rules.
+func ParseAuditPolicyID(policyID string) (string, bool) {
+
+	if !strings.HasPrefix(policyID, auditPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(policyID, auditPrefix), true
+}
+
+// auditPolicy returns a copy of a policy where every reject rule accepts and
+// logs the traffic instead. The audited rules come first so that they are
+// reported for the flows they match. Catch-all rules come last, so that the
+// traffic no rule matches is accepted and logged instead of being rejected by
+// the default deny.
+func auditPolicy(cached *CachedPolicy) *CachedPolicy {
+
+	applicationACLs := append(*auditRules(*cached.ApplicationACLs), auditDefaultACLs()...)
+	networkACLs := append(*auditRules(*cached.NetworkACLs), auditDefaultACLs()...)
+
+	return &CachedPolicy{
+		Audit:           true,
+		ApplicationACLs: &applicationACLs,
+		NetworkACLs:     &networkACLs,
+		Dependencies:    append(auditSelectors(cached.Dependencies), auditDefaultSelector()),
+		ExposureRules:   append(auditSelectors(cached.ExposureRules), auditDefaultSelector()),
+	}
+}
+
+// auditDefaultFlowPolicy is the flow policy of the catch-all rules
+func auditDefaultFlowPolicy() *policy.FlowPolicy {
+
+	return &policy.FlowPolicy{
+		Action:   auditAction,
+		PolicyID: AuditPolicyID(auditDefaultPolicyID),
+	}
+}
+
+// auditDefaultACLs returns the ACLs accepting and logging the traffic to or
+// from any address
+func auditDefaultACLs() policy.IPRuleList {
+
+	return policy.IPRuleList{
+		{Address: "0.0.0.0/0", Port: "1:65535", Protocol: "TCP", Policy: auditDefaultFlowPolicy()},
+		{Address: "0.0.0.0/0", Port: "1:65535", Protocol: "UDP", Policy: auditDefaultFlowPolicy()},
+		{Address: "0.0.0.0/0", Protocol: "ICMP", Policy: auditDefaultFlowPolicy()},
+	}
+}
+
+// auditDefaultSelector returns a selector accepting and logging the traffic
+// with any PU
+func auditDefaultSelector() policy.TagSelector {
+
+	return policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{Key: matchAllKey, Operator: policy.KeyNotExists},
+		},
+		Policy: auditDefaultFlowPolicy(),
+	}
+}
+
+// auditRules turns the reject ACLs of a list into accept+log ACLs
+func auditRules(rules policy.IPRuleList) *policy.IPRuleList {
+
+	audited := policy.IPRuleList{}
+	others := policy.IPRuleList{}
+
+	for _, rule := range rules {
+		if rule.Policy == nil || !rule.Policy.Action.Rejected() {
+			others = append(others, rule)
+			continue
+		}
+		rule.Policy = auditFlowPolicy(rule.Policy)
+		audited = append(audited, rule)
+	}
+
+	audited = append(audited, others...)
+
+	return &audited
+}
+
+// auditSelectors turns the reject selectors of a list into accept+log selectors
+func auditSelectors(selectors policy.TagSelectorList) policy.TagSelectorList {
+
+	audited := policy.TagSelectorList{}
+	others := policy.TagSelectorList{}
+
+	for _, selector := range selectors {
+		if selector.Policy == nil || !selector.Policy.Action.Rejected() {
+			others = append(others, selector)
+			continue
+		}
+		selector.Policy = auditFlowPolicy(selector.Policy)
+		audited = append(audited, selector)
+	}
+
+	return append(audited, others...)
+}
+
+// auditFlowPolicy returns the accept+log version of a reject flow policy
+func auditFlowPolicy(flowPolicy *policy.FlowPolicy) *policy.FlowPolicy {
+
+	audited := *flowPolicy
+	audited.Action = auditAction
+	audited.PolicyID = AuditPolicyID(flowPolicy.PolicyID)
+
+	return &audited
+}
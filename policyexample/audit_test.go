@@ -0,0 +1,143 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestAuditPolicy(t *testing.T) {
+
+	cached := &CachedPolicy{
+		ApplicationACLs: &policy.IPRuleList{
+			{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "http"}},
+			{Address: "10.0.0.0/8", Port: "22", Protocol: "tcp", Policy: &policy.FlowPolicy{Action: policy.Reject | policy.Log, PolicyID: "ssh"}},
+		},
+		NetworkACLs: &policy.IPRuleList{},
+		ExposureRules: policy.TagSelectorList{
+			{Clause: []policy.KeyValueOperator{{Key: "app", Operator: policy.Equal, Value: []string{"web"}}}, Policy: &policy.FlowPolicy{Action: policy.Accept, PolicyID: "web"}},
+			{Clause: []policy.KeyValueOperator{{Key: "env", Operator: policy.Equal, Value: []string{"dev"}}}, Policy: &policy.FlowPolicy{Action: policy.Reject, PolicyID: "dev"}},
+		},
+	}
+
+	audited := auditPolicy(cached)
+
+	if !audited.Audit {
+		t.Errorf("audited policy is not marked as audited")
+	}
+
+	// The catch-all rules match every address and every PU
+	for _, rules := range []*policy.IPRuleList{audited.ApplicationACLs, audited.NetworkACLs} {
+		defaults := []string{}
+		for _, rule := range (*rules)[len(*rules)-3:] {
+			defaults = append(defaults, rule.Address+" "+rule.Protocol+" "+rule.Port)
+		}
+		if want := "[0.0.0.0/0 TCP 1:65535 0.0.0.0/0 UDP 1:65535 0.0.0.0/0 ICMP ]"; fmt.Sprint(defaults) != want {
+			t.Errorf("catch-all ACLs = %v, want %s", defaults, want)
+		}
+	}
+	for _, selectors := range []policy.TagSelectorList{audited.Dependencies, audited.ExposureRules} {
+		if rules := ruleStrings(selectors[len(selectors)-1:]); rules[0] != matchAllKey+"!* accept|log audit:default" {
+			t.Errorf("catch-all selector = %v, want a selector on every PU", rules)
+		}
+	}
+
+	acls := []string{}
+	for _, rule := range *audited.ApplicationACLs {
+		acls = append(acls, rule.Policy.PolicyID+" "+ActionName(rule.Policy.Action))
+	}
+	// The traffic no rule matches is accepted and logged by the catch-all
+	// rules that come last
+	if want := "[audit:ssh accept|log http accept audit:default accept|log audit:default accept|log audit:default accept|log]"; fmt.Sprint(acls) != want {
+		t.Errorf("application ACLs = %v, want %s", acls, want)
+	}
+
+	selectors := []string{}
+	for _, selector := range audited.ExposureRules {
+		selectors = append(selectors, selector.Policy.PolicyID+" "+ActionName(selector.Policy.Action))
+	}
+	if want := "[audit:dev accept|log web accept audit:default accept|log]"; fmt.Sprint(selectors) != want {
+		t.Errorf("exposure rules = %v, want %s", selectors, want)
+	}
+
+	// The cached policy is left as is
+	if rule := (*cached.ApplicationACLs)[1]; rule.Policy.Action != policy.Reject|policy.Log || rule.Policy.PolicyID != "ssh" {
+		t.Errorf("cached rule = %+v, want the original reject rule", rule.Policy)
+	}
+	if selector := cached.ExposureRules[1]; selector.Policy.Action != policy.Reject || selector.Policy.PolicyID != "dev" {
+		t.Errorf("cached selector = %+v, want the original reject selector", selector.Policy)
+	}
+}
+
+func TestParseAuditPolicyID(t *testing.T) {
+
+	tests := []struct {
+		policyID string
+		rejected string
+		ok       bool
+	}{
+		{policyID: AuditPolicyID("ssh"), rejected: "ssh", ok: true},
+		{policyID: AuditPolicyID(""), rejected: "", ok: true},
+		{policyID: "ssh"},
+		{policyID: "ssh:audit:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policyID, func(t *testing.T) {
+
+			rejected, ok := ParseAuditPolicyID(tt.policyID)
+			if rejected != tt.rejected || ok != tt.ok {
+				t.Errorf("ParseAuditPolicyID(%q) = %q, %t, want %q, %t", tt.policyID, rejected, ok, tt.rejected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAuditPerPolicy(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", `
+web:
+  NetworkACLs:
+    - {Address: 10.0.0.0/8, Port: "22", Protocol: tcp, Policy: {Action: reject, PolicyID: ssh}}
+db:
+  Audit: true
+  NetworkACLs:
+    - {Address: 10.0.0.0/8, Port: "22", Protocol: tcp, Policy: {Action: reject, PolicyID: ssh}}
+`)
+	defer cleanup()
+
+	ctrl := newFakeController()
+	p, err := NewCustomPolicyResolver(ctrl, nil, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policyIndex string
+		action      policy.ActionType
+		policyID    string
+		acls        int
+	}{
+		{policyIndex: "web", action: policy.Reject, policyID: "ssh", acls: 1},
+		// The audited policy also has the catch-all rules
+		{policyIndex: "db", action: policy.Accept | policy.Log, policyID: "audit:ssh", acls: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policyIndex, func(t *testing.T) {
+
+			runtime := newTestRuntime(tt.policyIndex, map[string]string{"@usr:PolicyIndex": tt.policyIndex})
+			if err = p.HandlePUEvent(context.Background(), tt.policyIndex, common.EventStart, runtime); err != nil {
+				t.Fatal(err)
+			}
+
+			acls := ctrl.policies[tt.policyIndex].NetworkACLs()
+			if len(acls) != tt.acls || acls[0].Policy.Action != tt.action || acls[0].Policy.PolicyID != tt.policyID {
+				t.Errorf("network ACLs = %+v, want %s %s", acls, ActionName(tt.action), tt.policyID)
+			}
+		})
+	}
+}
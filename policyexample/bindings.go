@@ -0,0 +1,274 @@
+package policyexample
+
+import (
+	"fmt"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/policy"
+	"go.uber.org/zap"
+)
+
+// BindingsKey is the top-level key of a policy file holding the bindings. It
+// cannot be used as the name of a policy.
+const BindingsKey = "bindings"
+
+// Operators of the binding clauses
+const (
+	// BindingEqual matches if the tag has the value
+	BindingEqual = "="
+	// BindingNotEqual matches if the tag does not have the value or is missing
+	BindingNotEqual = "!="
+	// BindingIn matches if the tag has one of the values
+	BindingIn = "in"
+	// BindingExists matches if the tag is present
+	BindingExists = "exists"
+)
+
+// Sources of the policy index of a PU
+const (
+	// SourceLabel is a policy index given by a PolicyIndex or user tag
+	SourceLabel = "label"
+	// SourceBinding is a policy index given by a binding
+	SourceBinding = "binding"
+	// SourceDefault is the default policy used when nothing else matches
+	SourceDefault = "default"
+)
+
+// Binding selects the policy of the PUs matching all its clauses. When several
+// bindings match a PU, the one with the highest priority is used.
+type Binding struct {
+	// Name identifies the binding in logs. It is "bindings[<index>]" if empty.
+	Name     string
+	Policy   string
+	Priority int
+	Match    []*BindingClause
+}
+
+// BindingClause matches the tags of a PU
+type BindingClause struct {
+	Key      string
+	Operator string
+	Value    []string
+}
+
+// PolicySelection describes how the policy index of a PU was selected
+type PolicySelection struct {
+	Source   string
+	Binding  string `json:",omitempty"`
+	Priority int    `json:",omitempty"`
+	// Ties are the bindings with the same priority as the selected one that
+	// select a different policy
+	Ties []string `json:",omitempty"`
+}
+
+// String returns a short description of the selection
+func (s *PolicySelection) String() string {
+
+	if s == nil {
+		return ""
+	}
+
+	if s.Source != SourceBinding {
+		return s.Source
+	}
+
+	description := fmt.Sprintf("%s %s", s.Source, s.Binding)
+	if len(s.Ties) > 0 {
+		description += fmt.Sprintf(" (tie: %s)", strings.Join(s.Ties, ", "))
+	}
+
+	return description
+}
+
+// matches returns true if all the clauses of the binding match the tags
+func (b *Binding) matches(tags map[string][]string) bool {
+
+	for _, clause := range b.Match {
+		if !clause.matches(tags) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matches returns true if the clause matches the tags
+func (c *BindingClause) matches(tags map[string][]string) bool {
+
+	values := tagLookup(tags, c.Key)
+
+	switch c.Operator {
+	case BindingExists:
+		return len(values) > 0
+	case BindingEqual, BindingIn:
+		return containsAny(values, c.Value)
+	case BindingNotEqual:
+		return !containsAny(values, c.Value)
+	default:
+		return false
+	}
+}
+
+// selectPolicy returns the policy index of a PU and how it was selected. A
+// PolicyIndex or user tag comes first, then the bindings, then the default
+// policy. The caller must hold the lock.
+func (p *CustomPolicyResolver) selectPolicy(runtimeInfo policy.RuntimeReader) (string, *PolicySelection) {
+
+	if policyIndex, err := GetPolicyIndex(runtimeInfo); err == nil {
+		return policyIndex, &PolicySelection{Source: SourceLabel}
+	}
+
+	tags := tagValues(runtimeInfo.Tags())
+
+	var selected *Binding
+	ties := []string{}
+	for _, binding := range p.bindings {
+		if !binding.matches(tags) {
+			continue
+		}
+		switch {
+		case selected == nil || binding.Priority > selected.Priority:
+			selected = binding
+			ties = []string{}
+		case binding.Priority == selected.Priority && binding.Policy != selected.Policy:
+			ties = append(ties, binding.Name)
+		}
+	}
+
+	if selected == nil {
+		zap.L().Warn("No binding matches - Associating default policy",
+			zap.String("name", runtimeInfo.Name()),
+			zap.Int("bindings", len(p.bindings)),
+		)
+		return "default", &PolicySelection{Source: SourceDefault}
+	}
+
+	selection := &PolicySelection{
+		Source:   SourceBinding,
+		Binding:  selected.Name,
+		Priority: selected.Priority,
+	}
+
+	if len(ties) > 0 {
+		selection.Ties = ties
+		zap.L().Warn("Several bindings match with the same priority - using the first one",
+			zap.String("name", runtimeInfo.Name()),
+			zap.String("binding", selected.Name),
+			zap.String("policy", selected.Policy),
+			zap.Int("priority", selected.Priority),
+			zap.Strings("ties", ties),
+		)
+	} else {
+		zap.L().Info("Using policy from binding",
+			zap.String("name", runtimeInfo.Name()),
+			zap.String("binding", selected.Name),
+			zap.String("policy", selected.Policy),
+		)
+	}
+
+	return selected.Policy, selection
+}
+
+// tagValues returns the values of the tags keyed by tag key
+func tagValues(tags *policy.TagStore) map[string][]string {
+
+	values := map[string][]string{}
+	for _, tag := range tags.GetSlice() {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[parts[0]] = append(values[parts[0]], parts[1])
+	}
+
+	return values
+}
+
+// tagLookup returns the values of a tag key, with or without the @usr: and
+// @sys: prefixes
+func tagLookup(tags map[string][]string, key string) []string {
+
+	values := []string{}
+	for tagKey, tagValues := range tags {
+		if tagKeyMatches(tagKey, key) {
+			values = append(values, tagValues...)
+		}
+	}
+
+	return values
+}
+
+// tagKeyMatches returns true if a tag key is the key, with or without the
+// @usr: and @sys: prefixes
+func tagKeyMatches(tagKey, key string) bool {
+
+	return tagKey == key || tagKey == "@usr:"+key || tagKey == "@sys:"+key
+}
+
+// containsAny returns true if one of the values is one of the wanted values
+func containsAny(values, wanted []string) bool {
+
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkBindings validates the bindings of a policy file and names the
+// anonymous ones
+func (v *validator) checkBindings(bindings []*Binding, policies map[string]*CachedPolicy) {
+
+	for i, binding := range bindings {
+		bindingPath := fmt.Sprintf("%s[%d]", BindingsKey, i)
+		if binding == nil {
+			v.errorf(bindingPath, "binding is empty")
+			continue
+		}
+
+		if binding.Name == "" {
+			binding.Name = bindingPath
+		}
+
+		if _, ok := policies[binding.Policy]; !ok && binding.Policy != "default" {
+			v.errorf(bindingPath+".Policy", "unknown policy %q", binding.Policy)
+		}
+
+		if len(binding.Match) == 0 {
+			v.errorf(bindingPath+".Match", "a binding needs at least one clause")
+		}
+
+		for j, clause := range binding.Match {
+			clausePath := fmt.Sprintf("%s.Match[%d]", bindingPath, j)
+			if clause == nil {
+				v.errorf(clausePath, "clause is empty")
+				continue
+			}
+
+			if clause.Key == "" {
+				v.errorf(clausePath+".Key", "key is empty")
+			}
+
+			switch clause.Operator {
+			case BindingEqual, BindingNotEqual:
+				if len(clause.Value) != 1 {
+					v.errorf(clausePath+".Value", "operator %q needs exactly one value", clause.Operator)
+				}
+			case BindingIn:
+				if len(clause.Value) == 0 {
+					v.errorf(clausePath+".Value", "operator %q needs at least one value", clause.Operator)
+				}
+			case BindingExists:
+				if len(clause.Value) != 0 {
+					v.errorf(clausePath+".Value", "operator %q takes no value", clause.Operator)
+				}
+			default:
+				v.errorf(clausePath+".Operator", "invalid operator %q", clause.Operator)
+			}
+		}
+	}
+}
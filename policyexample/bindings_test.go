@@ -0,0 +1,172 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/common"
+)
+
+const testBindingsFile = `{
+	"web": {},
+	"db": {},
+	"batch": {},
+	"bindings": [
+		{"Name": "nginx", "Policy": "web", "Match": [{"Key": "image", "Operator": "=", "Value": ["nginx"]}]},
+		{"Name": "shop", "Policy": "web", "Priority": 1, "Match": [
+			{"Key": "service", "Operator": "in", "Value": ["front", "api"]},
+			{"Key": "env", "Operator": "!=", "Value": ["dev"]}
+		]},
+		{"Name": "postgres", "Policy": "db", "Priority": 1, "Match": [{"Key": "image", "Operator": "=", "Value": ["postgres"]}]},
+		{"Name": "jobs", "Policy": "batch", "Priority": 1, "Match": [{"Key": "job", "Operator": "exists"}]}
+	]
+}`
+
+func TestSelectPolicy(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.json", testBindingsFile)
+	defer cleanup()
+
+	p, err := NewCustomPolicyResolver(newFakeController(), nil, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tags      map[string]string
+		policy    string
+		selection string
+	}{
+		{
+			name:      "policy index label first",
+			tags:      map[string]string{"@usr:PolicyIndex": "db", "image": "nginx"},
+			policy:    "db",
+			selection: "label",
+		},
+		{
+			name:      "equal",
+			tags:      map[string]string{"image": "nginx"},
+			policy:    "web",
+			selection: "binding nginx",
+		},
+		{
+			name:      "highest priority",
+			tags:      map[string]string{"image": "nginx", "@usr:service": "api", "env": "prod"},
+			policy:    "web",
+			selection: "binding shop",
+		},
+		{
+			name:      "not equal matches a missing tag",
+			tags:      map[string]string{"@usr:service": "front"},
+			policy:    "web",
+			selection: "binding shop",
+		},
+		{
+			name:      "not equal",
+			tags:      map[string]string{"image": "nginx", "@usr:service": "front", "env": "dev"},
+			policy:    "web",
+			selection: "binding nginx",
+		},
+		{
+			name:      "exists",
+			tags:      map[string]string{"@sys:job": ""},
+			policy:    "batch",
+			selection: "binding jobs",
+		},
+		{
+			name:      "tie",
+			tags:      map[string]string{"image": "postgres", "job": "backup"},
+			policy:    "db",
+			selection: "binding postgres (tie: jobs)",
+		},
+		{
+			name:      "no match",
+			tags:      map[string]string{"image": "redis"},
+			policy:    "default",
+			selection: "default",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			policyIndex, selection := p.selectPolicy(newTestRuntime("pu", tt.tags))
+			if policyIndex != tt.policy || selection.String() != tt.selection {
+				t.Errorf("policy = %s (%s), want %s (%s)", policyIndex, selection, tt.policy, tt.selection)
+			}
+		})
+	}
+}
+
+func TestCheckBindings(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.json", `{
+		"web": {},
+		"bindings": [
+			{"Policy": "web", "Match": [{"Key": "app", "Operator": "=", "Value": ["web"]}]},
+			{"Policy": "db", "Match": []},
+			{"Policy": "default", "Match": [
+				{"Key": "", "Operator": "exists", "Value": ["x"]},
+				{"Key": "app", "Operator": "=", "Value": ["a", "b"]},
+				{"Key": "app", "Operator": "in"},
+				{"Key": "app", "Operator": "~", "Value": ["a"]},
+				null
+			]},
+			null
+		]
+	}`)
+	defer cleanup()
+
+	_, err := LoadPolicyFile(file)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("error = %v, want ValidationErrors", err)
+	}
+
+	want := []string{
+		`bindings[1].Policy: unknown policy "db"`,
+		"bindings[1].Match: a binding needs at least one clause",
+		"bindings[2].Match[0].Key: key is empty",
+		`bindings[2].Match[0].Value: operator "exists" takes no value`,
+		`bindings[2].Match[1].Value: operator "=" needs exactly one value`,
+		`bindings[2].Match[2].Value: operator "in" needs at least one value`,
+		`bindings[2].Match[3].Operator: invalid operator "~"`,
+		"bindings[2].Match[4]: clause is empty",
+		"bindings[3]: binding is empty",
+	}
+
+	if len(errs) != len(want) {
+		t.Errorf("errors =\n%s\nwant %d errors", errs, len(want))
+	}
+	for _, message := range want {
+		if !strings.Contains(errs.Error(), message) {
+			t.Errorf("errors =\n%s\nwant %q", errs, message)
+		}
+	}
+}
+
+func TestPUsReportTheSelection(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.json", testBindingsFile)
+	defer cleanup()
+
+	p, err := NewCustomPolicyResolver(newFakeController(), nil, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.HandlePUEvent(context.Background(), "pu", common.EventStart, newTestRuntime("pu", map[string]string{"image": "postgres", "job": "x"})); err != nil {
+		t.Fatal(err)
+	}
+
+	pu, err := p.PU("pu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pu.Selection == nil || fmt.Sprint(pu.Selection.Ties) != "[jobs]" {
+		t.Errorf("selection = %+v, want a tie with jobs", pu.Selection)
+	}
+}
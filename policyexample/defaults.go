@@ -0,0 +1,147 @@
+package policyexample
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// DefaultPolicyKey is the top-level key of a policy file configuring the default
+// policy. It cannot be used as the name of a policy.
+const DefaultPolicyKey = "defaultPolicy"
+
+// Stances of the default policy for the traffic that no selector matches
+const (
+	// StanceDeny rejects the traffic that no selector matches
+	StanceDeny = "deny"
+	// StanceAllow accepts the traffic that no selector matches
+	StanceAllow = "allow"
+)
+
+// matchAllKey is a tag key no PU has. A KeyNotExists clause on it matches every PU.
+const matchAllKey = "@trireme-example:none"
+
+// DefaultPolicy configures the policy of the PUs without a policy index
+type DefaultPolicy struct {
+	// SameLabels are the label keys two PUs must share to talk to each other.
+	// All the labels are used if empty.
+	SameLabels []string
+	// Stance is StanceDeny or StanceAllow for the traffic no selector matches.
+	// It is StanceDeny if empty.
+	Stance string
+	// Deny lists the selectors of the PUs that are always rejected. It is
+	// "namespace=bad" if missing.
+	Deny []*DenySelector
+}
+
+// DenySelector is a selector of the PUs rejected by the default policy
+type DenySelector struct {
+	Clause []policy.KeyValueOperator
+}
+
+// fallbackDefaultPolicy is the default policy used when the policy file does
+// not configure one
+var fallbackDefaultPolicy = &DefaultPolicy{}
+
+// fallbackDeny is used when the default policy has no deny list
+var fallbackDeny = []*DenySelector{
+	{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      "namespace",
+				Value:    []string{"bad"},
+				Operator: policy.Equal,
+			},
+		},
+	},
+}
+
+// sameLabel returns true if the label key counts for same label matching
+func (d *DefaultPolicy) sameLabel(key string) bool {
+
+	if len(d.SameLabels) == 0 {
+		return true
+	}
+
+	for _, label := range d.SameLabels {
+		if tagKeyMatches(key, label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// denySelectors returns the deny selectors as reject rules, starting at the
+// given policy ID
+func (d *DefaultPolicy) denySelectors(id int) policy.TagSelectorList {
+
+	deny := d.Deny
+	if deny == nil {
+		deny = fallbackDeny
+	}
+
+	selectors := policy.TagSelectorList{}
+	for _, selector := range deny {
+		selectors = append(selectors, policy.TagSelector{
+			Clause: selector.Clause,
+			Policy: &policy.FlowPolicy{
+				Action:   policy.Reject,
+				PolicyID: strconv.Itoa(id),
+			},
+		})
+		id++
+	}
+
+	return selectors
+}
+
+// allowSelector returns the rule accepting the traffic no other selector
+// matches, or nil with the deny stance
+func (d *DefaultPolicy) allowSelector(id int) *policy.TagSelector {
+
+	if d.Stance != StanceAllow {
+		return nil
+	}
+
+	return &policy.TagSelector{
+		Clause: []policy.KeyValueOperator{
+			{
+				Key:      matchAllKey,
+				Operator: policy.KeyNotExists,
+			},
+		},
+		Policy: &policy.FlowPolicy{
+			Action:   policy.Accept,
+			PolicyID: strconv.Itoa(id),
+		},
+	}
+}
+
+// checkDefaultPolicy validates the default policy of a policy file
+func (v *validator) checkDefaultPolicy(defaults *DefaultPolicy) {
+
+	for i, label := range defaults.SameLabels {
+		if label == "" {
+			v.errorf(fmt.Sprintf("%s.SameLabels[%d]", DefaultPolicyKey, i), "label is empty")
+		}
+	}
+
+	switch strings.ToLower(defaults.Stance) {
+	case "", StanceDeny, StanceAllow:
+		defaults.Stance = strings.ToLower(defaults.Stance)
+	default:
+		v.errorf(DefaultPolicyKey+".Stance", "invalid stance %q: must be %q or %q", defaults.Stance, StanceDeny, StanceAllow)
+	}
+
+	for i, selector := range defaults.Deny {
+		selectorPath := fmt.Sprintf("%s.Deny[%d]", DefaultPolicyKey, i)
+		if selector == nil {
+			v.errorf(selectorPath, "selector is empty")
+			continue
+		}
+		v.checkClauses(selectorPath, selector.Clause)
+	}
+}
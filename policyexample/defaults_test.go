@@ -0,0 +1,109 @@
+package policyexample
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// ruleStrings returns the selectors as "<clauses> <action> <policy ID>" strings
+func ruleStrings(selectors policy.TagSelectorList) []string {
+
+	rules := []string{}
+	for _, selector := range selectors {
+		clauses := []string{}
+		for _, kv := range selector.Clause {
+			clauses = append(clauses, kv.Key+string(kv.Operator)+strings.Join(kv.Value, ","))
+		}
+		rules = append(rules, fmt.Sprintf("%s %s %s", strings.Join(clauses, "&"), ActionName(selector.Policy.Action), selector.Policy.PolicyID))
+	}
+
+	return rules
+}
+
+func TestCreateDefaultRules(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		file   string
+		tags   map[string]string
+		rules  []string
+		errors string
+	}{
+		{
+			name:  "fallback",
+			tags:  map[string]string{"app": "web"},
+			rules: []string{"app=web accept 0", "namespace=bad reject 1"},
+		},
+		{
+			name:  "same labels",
+			file:  `{"defaultPolicy": {"SameLabels": ["env"]}}`,
+			tags:  map[string]string{"app": "web", "@usr:env": "prod"},
+			rules: []string{"@usr:env=prod accept 0", "namespace=bad reject 1"},
+		},
+		{
+			name: "allow stance and deny selectors",
+			file: `{"defaultPolicy": {
+				"SameLabels": ["app"],
+				"Stance": "Allow",
+				"Deny": [
+					{"Clause": [{"Key": "env", "Operator": "=", "Value": ["dev", "test"]}]},
+					{"Clause": [{"Key": "quarantine", "Operator": "*"}]}
+				]
+			}}`,
+			tags: map[string]string{"app": "web", "env": "prod"},
+			rules: []string{
+				"app=web accept 0",
+				"env=dev,test reject 1",
+				"quarantine* reject 2",
+				matchAllKey + "!* accept 3",
+			},
+		},
+		{
+			name:  "deny stance without deny selectors",
+			file:  `{"defaultPolicy": {"Stance": "deny", "Deny": []}}`,
+			tags:  map[string]string{"app": "web"},
+			rules: []string{"app=web accept 0"},
+		},
+		{
+			name:   "invalid",
+			file:   `{"defaultPolicy": {"SameLabels": [""], "Stance": "maybe", "Deny": [null]}}`,
+			errors: "defaultPolicy.SameLabels[0]: label is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file := ""
+			if tt.file != "" {
+				var cleanup func()
+				file, cleanup = writeTestFile(t, "policy.json", tt.file)
+				defer cleanup()
+			}
+
+			p, err := NewCustomPolicyResolver(newFakeController(), nil, file, false)
+			if tt.errors != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errors) {
+					t.Fatalf("error = %v, want %q", err, tt.errors)
+				}
+				for _, message := range []string{`invalid stance "maybe"`, "defaultPolicy.Deny[0]: selector is empty"} {
+					if !strings.Contains(err.Error(), message) {
+						t.Errorf("error = %v, want %q", err, message)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rules := ruleStrings(p.createDefaultRules(newTestRuntime("web", tt.tags)))
+			if fmt.Sprint(rules) != fmt.Sprint(tt.rules) {
+				t.Errorf("rules =\n%s\nwant\n%s", strings.Join(rules, "\n"), strings.Join(tt.rules, "\n"))
+			}
+		})
+	}
+}
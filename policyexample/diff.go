@@ -0,0 +1,321 @@
+package policyexample
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// Kinds of changes between two policy files
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// RuleChange is an ACL or a selector added, removed or changed in a policy
+type RuleChange struct {
+	// Rules is the rule list of the rule, such as "Dependencies"
+	Rules  string
+	Change string
+	// Rule describes the traffic or the PUs the rule applies to
+	Rule string
+	// Old and New describe the action and the policy ID of the rule
+	Old string `json:",omitempty"`
+	New string `json:",omitempty"`
+}
+
+// PolicyDiff is the set of changes of a policy between two policy files
+type PolicyDiff struct {
+	Index  string
+	Change string
+	Rules  []*RuleChange `json:",omitempty"`
+}
+
+// PolicyFileDiff is the set of changes between two policy files
+type PolicyFileDiff struct {
+	Policies []*PolicyDiff
+	// DefaultPolicy is true if the configuration of the default policy changed
+	DefaultPolicy bool
+	// Bindings is true if the bindings changed
+	Bindings bool
+
+	prev *PolicyFile
+	next *PolicyFile
+}
+
+// PUImpact describes how a change of the policy file affects a PU
+type PUImpact struct {
+	ID             string
+	Name           string
+	PolicyIndex    string
+	NewPolicyIndex string
+	Selection      *PolicySelection
+	// Rules are the changes of the effective rules of the PU
+	Rules []*RuleChange `json:",omitempty"`
+	// Error is set if the new policy of the PU cannot be resolved. The daemon
+	// then keeps the current policy of the PU.
+	Error string `json:",omitempty"`
+}
+
+// DiffPolicyFiles compares two policy files. Both files must be valid.
+func DiffPolicyFiles(oldFile, newFile string) (*PolicyFileDiff, error) {
+
+	prev, err := LoadPolicyFile(oldFile)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := LoadPolicyFile(newFile)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &PolicyFileDiff{
+		Policies:      []*PolicyDiff{},
+		DefaultPolicy: !reflect.DeepEqual(prev.Default, next.Default),
+		Bindings:      !reflect.DeepEqual(prev.Bindings, next.Bindings),
+		prev:          prev,
+		next:          next,
+	}
+
+	indexes := []string{}
+	for index := range prev.Policies {
+		indexes = append(indexes, index)
+	}
+	for index := range next.Policies {
+		if _, ok := prev.Policies[index]; !ok {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Strings(indexes)
+
+	for _, index := range indexes {
+		prevPolicy, inPrev := prev.Policies[index]
+		nextPolicy, inNext := next.Policies[index]
+
+		switch {
+		case !inPrev:
+			diff.Policies = append(diff.Policies, &PolicyDiff{
+				Index:  index,
+				Change: ChangeAdded,
+				Rules:  diffPolicies(emptyPolicy(), nextPolicy),
+			})
+		case !inNext:
+			diff.Policies = append(diff.Policies, &PolicyDiff{
+				Index:  index,
+				Change: ChangeRemoved,
+				Rules:  diffPolicies(prevPolicy, emptyPolicy()),
+			})
+		default:
+			if rules := diffPolicies(prevPolicy, nextPolicy); len(rules) > 0 {
+				diff.Policies = append(diff.Policies, &PolicyDiff{
+					Index:  index,
+					Change: ChangeChanged,
+					Rules:  rules,
+				})
+			}
+		}
+	}
+
+	return diff, nil
+}
+
+// Empty returns true if the two policy files have the same policies,
+// default policy and bindings
+func (d *PolicyFileDiff) Empty() bool {
+
+	return len(d.Policies) == 0 && !d.DefaultPolicy && !d.Bindings
+}
+
+// Impact returns the enforced PUs whose effective policy would change with the
+// new policy file. The current policy of every PU is the policy the daemon
+// enforces for it, keyed by PU ID in enforced: the PUs without one are
+// skipped. The new policy is selected as the daemon would after a reload.
+// Audit is the audit mode of the daemon.
+func (d *PolicyFileDiff) Impact(pus []*PUStatus, enforced map[string]*EnforcedPolicy, audit bool) []*PUImpact {
+
+	next := &CustomPolicyResolver{policies: d.next.Policies, defaults: d.next.Default, bindings: d.next.Bindings, audit: audit}
+
+	impacts := []*PUImpact{}
+	for _, pu := range pus {
+		current, ok := enforced[pu.ID]
+		if !pu.Enforced || !ok {
+			continue
+		}
+
+		runtime := policy.NewPURuntime(pu.Name, 0, "", policy.NewTagStoreFromSlice(pu.Tags), pu.IPs, common.ContainerPU, nil)
+
+		newIndex, selection := next.selectPolicy(runtime)
+		impact := &PUImpact{
+			ID:             pu.ID,
+			Name:           pu.Name,
+			PolicyIndex:    pu.PolicyIndex,
+			NewPolicyIndex: newIndex,
+			Selection:      selection,
+		}
+
+		newPolicy, err := next.policyFor(newIndex, runtime)
+		if err != nil {
+			impact.Error = fmt.Sprintf("policy %s not found", newIndex)
+			impacts = append(impacts, impact)
+			continue
+		}
+
+		impact.Rules = diffPolicies(current.cachedPolicy(), newPolicy)
+		if newIndex == pu.PolicyIndex && len(impact.Rules) == 0 {
+			continue
+		}
+
+		impacts = append(impacts, impact)
+	}
+
+	return impacts
+}
+
+// diffPolicies returns the rules added, removed or changed between two
+// policies. ACLs are identified by their address, protocol and ports, and
+// selectors by their clauses.
+func diffPolicies(prev, next *CachedPolicy) []*RuleChange {
+
+	changes := []*RuleChange{}
+
+	if prev.Audit != next.Audit {
+		changes = append(changes, &RuleChange{
+			Rules:  "Audit",
+			Change: ChangeChanged,
+			Old:    fmt.Sprintf("%t", prev.Audit),
+			New:    fmt.Sprintf("%t", next.Audit),
+		})
+	}
+
+	changes = append(changes, diffRules("ApplicationACLs", *prev.ApplicationACLs, *next.ApplicationACLs)...)
+	changes = append(changes, diffRules("NetworkACLs", *prev.NetworkACLs, *next.NetworkACLs)...)
+	changes = append(changes, diffSelectors("Dependencies", prev.Dependencies, next.Dependencies)...)
+	changes = append(changes, diffSelectors("ExposureRules", prev.ExposureRules, next.ExposureRules)...)
+
+	return changes
+}
+
+// diffRules compares two lists of ACLs
+func diffRules(rules string, prev, next policy.IPRuleList) []*RuleChange {
+
+	prevActions := map[string]string{}
+	nextActions := map[string]string{}
+	descriptions := map[string]string{}
+	keys := []string{}
+
+	for _, rule := range prev {
+		key := ruleKey(rule)
+		if _, ok := descriptions[key]; !ok {
+			keys = append(keys, key)
+		}
+		descriptions[key] = fmt.Sprintf("%s %s/%s", rule.Address, rule.Port, strings.ToLower(rule.Protocol))
+		prevActions[key] = describeFlowPolicy(rule.Policy)
+	}
+
+	for _, rule := range next {
+		key := ruleKey(rule)
+		if _, ok := descriptions[key]; !ok {
+			keys = append(keys, key)
+		}
+		descriptions[key] = fmt.Sprintf("%s %s/%s", rule.Address, rule.Port, strings.ToLower(rule.Protocol))
+		nextActions[key] = describeFlowPolicy(rule.Policy)
+	}
+
+	return diffActions(rules, keys, descriptions, prevActions, nextActions)
+}
+
+// diffSelectors compares two lists of selectors
+func diffSelectors(rules string, prev, next policy.TagSelectorList) []*RuleChange {
+
+	prevActions := map[string]string{}
+	nextActions := map[string]string{}
+	descriptions := map[string]string{}
+	keys := []string{}
+
+	for _, selector := range prev {
+		key := selectorKey(selector)
+		if _, ok := descriptions[key]; !ok {
+			keys = append(keys, key)
+		}
+		descriptions[key] = describeClauses(selector.Clause)
+		prevActions[key] = describeFlowPolicy(selector.Policy)
+	}
+
+	for _, selector := range next {
+		key := selectorKey(selector)
+		if _, ok := descriptions[key]; !ok {
+			keys = append(keys, key)
+		}
+		descriptions[key] = describeClauses(selector.Clause)
+		nextActions[key] = describeFlowPolicy(selector.Policy)
+	}
+
+	return diffActions(rules, keys, descriptions, prevActions, nextActions)
+}
+
+// diffActions compares the actions of the rules of two lists, in the order of
+// their keys
+func diffActions(rules string, keys []string, descriptions, prev, next map[string]string) []*RuleChange {
+
+	changes := []*RuleChange{}
+
+	for _, key := range keys {
+		prevAction, inPrev := prev[key]
+		nextAction, inNext := next[key]
+
+		change := &RuleChange{
+			Rules: rules,
+			Rule:  descriptions[key],
+			Old:   prevAction,
+			New:   nextAction,
+		}
+
+		switch {
+		case !inPrev:
+			change.Change = ChangeAdded
+		case !inNext:
+			change.Change = ChangeRemoved
+		case prevAction != nextAction:
+			change.Change = ChangeChanged
+		default:
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// describeFlowPolicy returns a short description of the action and the policy
+// ID of a rule
+func describeFlowPolicy(flowPolicy *policy.FlowPolicy) string {
+
+	if flowPolicy == nil {
+		return "none"
+	}
+
+	description := ActionName(flowPolicy.Action)
+	if flowPolicy.PolicyID != "" {
+		description += " (" + flowPolicy.PolicyID + ")"
+	}
+
+	return description
+}
+
+// emptyPolicy returns a policy without any rule
+func emptyPolicy() *CachedPolicy {
+
+	return &CachedPolicy{
+		ApplicationACLs: &policy.IPRuleList{},
+		NetworkACLs:     &policy.IPRuleList{},
+		Dependencies:    policy.TagSelectorList{},
+		ExposureRules:   policy.TagSelectorList{},
+	}
+}
@@ -0,0 +1,188 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+const testDiffOldFile = `
+web:
+  ApplicationACLs:
+    - {Address: 0.0.0.0/0, Port: "443", Protocol: tcp, Policy: {Action: accept, PolicyID: https}}
+    - {Address: 0.0.0.0/0, Port: "80", Protocol: tcp, Policy: {Action: accept, PolicyID: http}}
+db:
+  ExposureRules:
+    - {Clause: [{Key: "@usr:app", Operator: "=", Value: [web]}], Policy: {Action: accept, PolicyID: db-from-web}}
+cache: {}
+`
+
+const testDiffNewFile = `
+web:
+  ApplicationACLs:
+    - {Address: 0.0.0.0/0, Port: "443", Protocol: tcp, Policy: {Action: accept, PolicyID: https}}
+    - {Address: 0.0.0.0/0, Port: "80", Protocol: tcp, Policy: {Action: reject, PolicyID: no-http}}
+    - {Address: 10.0.0.0/8, Port: "53", Protocol: udp, Policy: {Action: accept, PolicyID: dns}}
+db:
+  ExposureRules:
+    - {Clause: [{Key: "@usr:app", Operator: "=", Value: [web]}], Policy: {Action: accept, PolicyID: db-from-web}}
+queue: {}
+bindings:
+  - {Policy: queue, Match: [{Key: app, Operator: "=", Value: [queue]}]}
+`
+
+// describeRuleChanges returns the changes as "<rules> <change> <rule>" strings
+func describeRuleChanges(changes []*RuleChange) []string {
+
+	descriptions := []string{}
+	for _, change := range changes {
+		descriptions = append(descriptions, fmt.Sprintf("%s %s %s", change.Rules, change.Change, change.Rule))
+	}
+
+	return descriptions
+}
+
+func TestDiffPolicyFiles(t *testing.T) {
+
+	oldFile, cleanupOld := writeTestFile(t, "old.yaml", testDiffOldFile)
+	defer cleanupOld()
+	newFile, cleanupNew := writeTestFile(t, "new.yaml", testDiffNewFile)
+	defer cleanupNew()
+
+	diff, err := DiffPolicyFiles(oldFile, newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.Empty() || diff.DefaultPolicy || !diff.Bindings {
+		t.Errorf("diff = %+v, want changed bindings only", diff)
+	}
+
+	policies := []string{}
+	for _, policyDiff := range diff.Policies {
+		policies = append(policies, policyDiff.Index+" "+policyDiff.Change)
+	}
+	if want := "[cache removed queue added web changed]"; fmt.Sprint(policies) != want {
+		t.Fatalf("policies = %v, want %s", policies, want)
+	}
+
+	web := diff.Policies[2]
+	want := []string{
+		"ApplicationACLs changed 0.0.0.0/0 80/tcp",
+		"ApplicationACLs added 10.0.0.0/8 53/udp",
+	}
+	if got := describeRuleChanges(web.Rules); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("web changes = %q, want %q", got, want)
+	}
+	if change := web.Rules[0]; change.Old != "accept (http)" || change.New != "reject (no-http)" {
+		t.Errorf("change = %+v, want accept (http) -> reject (no-http)", change)
+	}
+
+	same, err := DiffPolicyFiles(oldFile, oldFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.Empty() {
+		t.Errorf("diff of a file with itself = %+v, want empty", same)
+	}
+}
+
+func TestImpact(t *testing.T) {
+
+	oldFile, cleanupOld := writeTestFile(t, "old.yaml", testDiffOldFile)
+	defer cleanupOld()
+	newFile, cleanupNew := writeTestFile(t, "new.yaml", testDiffNewFile)
+	defer cleanupNew()
+
+	// The daemon runs with a file that is neither the old nor the new one:
+	// web has no ACL yet
+	liveFile, cleanupLive := writeTestFile(t, "live.yaml", `{"web": {}, "db": {}, "cache": {}}`)
+	defer cleanupLive()
+
+	p, err := NewCustomPolicyResolver(newFakeController(), nil, liveFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pus := []struct {
+		id    string
+		tags  map[string]string
+		event common.Event
+	}{
+		{id: "web", tags: map[string]string{"@usr:PolicyIndex": "web"}, event: common.EventStart},
+		{id: "cache", tags: map[string]string{"@usr:PolicyIndex": "cache"}, event: common.EventStart},
+		{id: "queue", tags: map[string]string{"@usr:app": "queue"}, event: common.EventStart},
+		{id: "other", tags: map[string]string{"@usr:app": "other"}, event: common.EventStart},
+		{id: "paused", tags: map[string]string{"@usr:PolicyIndex": "web"}, event: common.EventPause},
+	}
+	for _, pu := range pus {
+		runtime := policy.NewPURuntime(pu.id, 1, "", policy.NewTagStoreFromMap(pu.tags), policy.ExtendedMap{"bridge": "172.17.0.2"}, common.ContainerPU, nil)
+		if err = p.HandlePUEvent(context.Background(), pu.id, common.EventStart, runtime); err != nil {
+			t.Fatal(err)
+		}
+		if pu.event != common.EventStart {
+			if err = p.HandlePUEvent(context.Background(), pu.id, pu.event, runtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// What the management API reports
+	statuses := p.PUs()
+	enforced := map[string]*EnforcedPolicy{}
+	for _, pu := range statuses {
+		if pu.IPs["bridge"] != "172.17.0.2" {
+			t.Errorf("IPs of %s = %v, want the bridge address", pu.ID, pu.IPs)
+		}
+		if enforced[pu.ID], err = p.PUPolicy(pu.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := DiffPolicyFiles(oldFile, newFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	impacts := map[string]*PUImpact{}
+	for _, impact := range diff.Impact(statuses, enforced, false) {
+		impacts[impact.ID] = impact
+	}
+
+	if len(impacts) != 3 {
+		t.Errorf("impacts = %v, want cache, queue and web", impacts)
+	}
+
+	// The rules are compared with the enforced policy, not the old file
+	web, ok := impacts["web"]
+	if !ok {
+		t.Fatal("web is not affected")
+	}
+	want := []string{
+		"ApplicationACLs added 0.0.0.0/0 443/tcp",
+		"ApplicationACLs added 0.0.0.0/0 80/tcp",
+		"ApplicationACLs added 10.0.0.0/8 53/udp",
+	}
+	if got := describeRuleChanges(web.Rules); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("web changes = %q, want %q", got, want)
+	}
+
+	if cache, ok := impacts["cache"]; !ok || cache.Error != "policy cache not found" {
+		t.Errorf("cache impact = %+v, want a missing policy", cache)
+	}
+
+	if queue, ok := impacts["queue"]; !ok || queue.PolicyIndex != "default" || queue.NewPolicyIndex != "queue" || queue.Selection.Source != SourceBinding {
+		t.Errorf("queue impact = %+v, want the queue policy from a binding", queue)
+	}
+
+	// Without a current policy, a PU is skipped
+	delete(enforced, "web")
+	for _, impact := range diff.Impact(statuses, enforced, false) {
+		if impact.ID == "web" {
+			t.Errorf("web is affected without a current policy")
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package policyexample
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/common"
+)
+
+// ExplainedPU is a PU handled by the resolver at one end of an explained flow
+type ExplainedPU struct {
+	ID   string
+	Name string
+	SimulatedPU
+	IP       string `json:",omitempty"`
+	Event    common.Event
+	Enforced bool
+	Audit    bool
+}
+
+// Explanation describes how the policies of two PUs apply to the traffic
+// from the source to the destination
+type Explanation struct {
+	Source      *ExplainedPU
+	Destination *ExplainedPU
+	Port        string `json:",omitempty"`
+	Protocol    string
+	Decision    string
+	// Dependency is the Dependencies rule of the source matching the destination
+	Dependency *SimulationStep
+	// Exposure is the ExposureRules rule of the destination matching the source
+	Exposure *SimulationStep
+	// ApplicationACL is the ApplicationACLs rule of the source matching the IP
+	// address of the destination, if it is known
+	ApplicationACL *SimulationStep `json:",omitempty"`
+	// NetworkACL is the NetworkACLs rule of the destination matching the IP
+	// address of the source, if it is known
+	NetworkACL *SimulationStep `json:",omitempty"`
+}
+
+// Explain describes how the current policies of two PUs, given by their ID or
+// name, apply to the traffic from src to dst. The port is optional and the
+// protocol defaults to tcp. The decision only depends on the Dependencies of
+// the source and the ExposureRules of the destination: the ACLs matching the
+// addresses of the PUs are reported for information.
+func (p *CustomPolicyResolver) Explain(src, dst, port, protocol string) (*Explanation, error) {
+
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	srcID, srcState, err := p.findPU(src)
+	if err != nil {
+		return nil, err
+	}
+
+	dstID, dstState, err := p.findPU(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{
+		Source:      explainPU(srcID, srcState),
+		Destination: explainPU(dstID, dstState),
+		Port:        port,
+		Protocol:    protocol,
+		Decision:    DecisionAllow,
+	}
+
+	explanation.Dependency = matchSelectors("source", "Dependencies", srcState.cached.Dependencies, explanation.Destination.Tags)
+	explanation.Exposure = matchSelectors("destination", "ExposureRules", dstState.cached.ExposureRules, explanation.Source.Tags)
+	if explanation.Dependency.Decision == DecisionDeny || explanation.Exposure.Decision == DecisionDeny {
+		explanation.Decision = DecisionDeny
+	}
+
+	if explanation.Destination.IP != "" {
+		explanation.ApplicationACL = matchACLs("source", "ApplicationACLs", *srcState.cached.ApplicationACLs, explanation.Destination.IP, port, protocol)
+	}
+	if explanation.Source.IP != "" {
+		explanation.NetworkACL = matchACLs("destination", "NetworkACLs", *dstState.cached.NetworkACLs, explanation.Source.IP, port, protocol)
+	}
+
+	return explanation, nil
+}
+
+// AmbiguousPUError is returned when a PU is given by a name or an ID prefix
+// that matches several PUs
+type AmbiguousPUError struct {
+	IDOrName string
+	// Matches are the sorted IDs of the PUs matching
+	Matches []string
+}
+
+// Error implements the error interface
+func (e *AmbiguousPUError) Error() string {
+
+	return fmt.Sprintf("ambiguous PU %s: matches %s", e.IDOrName, strings.Join(e.Matches, ", "))
+}
+
+// findPU returns the state of a PU given by its ID, a prefix of its ID or
+// its name. An AmbiguousPUError is returned if more than one PU matches,
+// rather than picking one of them. The caller must hold the lock.
+func (p *CustomPolicyResolver) findPU(idOrName string) (string, *puState, error) {
+
+	if state, ok := p.pus[idOrName]; ok {
+		return idOrName, state, nil
+	}
+
+	matches := []string{}
+	for puID, state := range p.pus {
+		name := state.runtime.Name()
+		if name == idOrName || strings.TrimPrefix(name, "/") == idOrName || strings.HasPrefix(puID, idOrName) {
+			matches = append(matches, puID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", nil, fmt.Errorf("unknown PU %s", idOrName)
+	case 1:
+		return matches[0], p.pus[matches[0]], nil
+	default:
+		sort.Strings(matches)
+		return "", nil, &AmbiguousPUError{IDOrName: idOrName, Matches: matches}
+	}
+}
+
+// explainPU describes a PU at one end of an explained flow
+func explainPU(puID string, state *puState) *ExplainedPU {
+
+	return &ExplainedPU{
+		ID:   puID,
+		Name: state.runtime.Name(),
+		SimulatedPU: SimulatedPU{
+			Tags:        state.runtime.Tags().GetSlice(),
+			PolicyIndex: state.policyIndex,
+			Selection:   state.selection,
+		},
+		IP:       puIP(state),
+		Event:    state.event,
+		Enforced: state.enforced(),
+		Audit:    state.cached.Audit,
+	}
+}
+
+// puIP returns the IP address of a PU, preferring the Docker bridge address,
+// or an empty string if it is unknown
+func puIP(state *puState) string {
+
+	ips := state.runtime.IPAddresses()
+
+	if ip := net.ParseIP(ips["bridge"]); ip != nil {
+		return ip.String()
+	}
+
+	names := make([]string, 0, len(ips))
+	for name := range ips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ip := net.ParseIP(ips[name]); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,160 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// newTestExplainResolver returns a resolver with the PUs of the simulation
+// policy file
+func newTestExplainResolver(t *testing.T) (*CustomPolicyResolver, func()) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", testSimulationFile)
+
+	p, err := NewCustomPolicyResolver(newFakeController(), nil, file, false)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	pus := []struct {
+		id   string
+		name string
+		tags map[string]string
+		ip   string
+	}{
+		{id: "3f2a91", name: "/web-1", tags: map[string]string{"@usr:app": "web"}, ip: "10.1.0.5"},
+		{id: "3f2b07", name: "/web-2", tags: map[string]string{"@usr:app": "web", "@usr:env": "dev"}},
+		{id: "8c11d4", name: "/db", tags: map[string]string{"@usr:app": "db"}, ip: "10.2.0.7"},
+		{id: "9e0a00", name: "/db", tags: map[string]string{"@usr:app": "db"}},
+	}
+
+	for _, pu := range pus {
+		ips := policy.ExtendedMap{}
+		if pu.ip != "" {
+			ips["bridge"] = pu.ip
+		}
+		runtime := policy.NewPURuntime(pu.name, 1, "", policy.NewTagStoreFromMap(pu.tags), ips, common.ContainerPU, nil)
+		if err = p.HandlePUEvent(context.Background(), pu.id, common.EventStart, runtime); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+
+	return p, cleanup
+}
+
+func TestFindPU(t *testing.T) {
+
+	p, cleanup := newTestExplainResolver(t)
+	defer cleanup()
+
+	tests := []struct {
+		idOrName string
+		id       string
+		err      string
+	}{
+		{idOrName: "3f2a91", id: "3f2a91"},
+		{idOrName: "3f2a", id: "3f2a91"},
+		{idOrName: "web-2", id: "3f2b07"},
+		{idOrName: "/web-1", id: "3f2a91"},
+		{idOrName: "3f2", err: "ambiguous PU 3f2: matches 3f2a91, 3f2b07"},
+		{idOrName: "db", err: "ambiguous PU db: matches 8c11d4, 9e0a00"},
+		{idOrName: "redis", err: "unknown PU redis"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.idOrName, func(t *testing.T) {
+
+			id, _, err := p.findPU(tt.idOrName)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				if strings.HasPrefix(tt.err, "ambiguous") {
+					if _, ok := err.(*AmbiguousPUError); !ok {
+						t.Errorf("error = %T, want *AmbiguousPUError", err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.id {
+				t.Errorf("PU = %s, want %s", id, tt.id)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+
+	p, cleanup := newTestExplainResolver(t)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		src      string
+		dst      string
+		port     string
+		decision string
+		steps    []string
+	}{
+		{
+			name:     "allowed",
+			src:      "web-1",
+			dst:      "8c11d4",
+			port:     "443",
+			decision: DecisionAllow,
+			steps:    []string{"Dependencies allow web-to-db", "ExposureRules allow db-from-web", "ApplicationACLs allow https", "NetworkACLs deny "},
+		},
+		{
+			name:     "without IP addresses",
+			src:      "web-2",
+			dst:      "9e0a00",
+			decision: DecisionAllow,
+			steps:    []string{"Dependencies allow web-to-db", "ExposureRules allow db-from-web"},
+		},
+		{
+			name:     "denied",
+			src:      "8c11d4",
+			dst:      "web-2",
+			decision: DecisionDeny,
+			steps:    []string{"Dependencies deny ", "ExposureRules deny ", "NetworkACLs deny "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			explanation, err := p.Explain(tt.src, tt.dst, tt.port, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			steps := []*SimulationStep{explanation.Dependency, explanation.Exposure}
+			for _, step := range []*SimulationStep{explanation.ApplicationACL, explanation.NetworkACL} {
+				if step != nil {
+					steps = append(steps, step)
+				}
+			}
+
+			if explanation.Decision != tt.decision {
+				t.Errorf("decision = %s, want %s", explanation.Decision, tt.decision)
+			}
+			if got := describeSteps(steps); fmt.Sprint(got) != fmt.Sprint(tt.steps) {
+				t.Errorf("steps = %q, want %q", got, tt.steps)
+			}
+		})
+	}
+
+	if _, err := p.Explain("db", "web-1", "", ""); err == nil {
+		t.Errorf("expected an error for an ambiguous source")
+	}
+}
@@ -0,0 +1,156 @@
+package policyexample
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"go.aporeto.io/trireme-lib/policy"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// actionSeparator separates the names of a symbolic action, such as "accept|log"
+const actionSeparator = "|"
+
+// actionNames are the symbolic names that can be used for the Action of a rule
+var actionNames = map[string]policy.ActionType{
+	"accept":  policy.Accept,
+	"reject":  policy.Reject,
+	"encrypt": policy.Encrypt,
+	"log":     policy.Log,
+}
+
+// ActionName returns the symbolic names of an action separated by "|", such
+// as "accept|log". ParseAction parses it back.
+func ActionName(action policy.ActionType) string {
+
+	names := []string{}
+	for _, name := range []string{"accept", "reject", "encrypt", "log"} {
+		if action&actionNames[name] != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, actionSeparator)
+}
+
+// decodeDocument decodes the content of a policy file into a generic document.
+// The decoder is chosen based on the extension of the file: .yaml and .yml
+// are YAML, .toml is TOML and everything else is JSON.
+func decodeDocument(file string, data []byte) (map[string]interface{}, error) {
+
+	doc := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, parserError(file, err, yamlErrorLine)
+		}
+		if raw == nil {
+			return doc, nil
+		}
+		converted, ok := convertYAML(raw).(map[string]interface{})
+		if !ok {
+			return nil, ValidationErrors{&PolicyError{File: file, Message: "policy file must be a map of policies"}}
+		}
+		return converted, nil
+
+	case ".toml":
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return nil, parserError(file, err, tomlErrorLine)
+		}
+		return tree.ToMap(), nil
+
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, decodeError(file, data, err)
+		}
+		return doc, nil
+	}
+}
+
+// convertYAML converts the maps decoded by the YAML parser into maps with
+// string keys that can be encoded in JSON.
+func convertYAML(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = convertYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertYAML(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// normalizeActions replaces the symbolic actions of all the rules in a document
+// with their numeric value. Actions are either a number or a list of names
+// separated by "|", such as "accept|log".
+func normalizeActions(path string, value interface{}, v *validator) {
+
+	switch node := value.(type) {
+	case map[string]interface{}:
+		for key, item := range node {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+
+			if flowPolicy, ok := item.(map[string]interface{}); ok && strings.EqualFold(key, "Policy") {
+				for field, action := range flowPolicy {
+					name, ok := action.(string)
+					if !ok || !strings.EqualFold(field, "Action") {
+						continue
+					}
+					parsed, err := ParseAction(name)
+					if err != nil {
+						v.errorf(itemPath+"."+field, "%s", err)
+						continue
+					}
+					flowPolicy[field] = parsed
+				}
+			}
+
+			normalizeActions(itemPath, item, v)
+		}
+	case []interface{}:
+		for i, item := range node {
+			normalizeActions(fmt.Sprintf("%s[%d]", path, i), item, v)
+		}
+	}
+}
+
+// ParseAction converts a symbolic action such as "accept|log" into an action.
+// Numeric actions are accepted as well.
+func ParseAction(action string) (policy.ActionType, error) {
+
+	if n, err := strconv.ParseUint(strings.TrimSpace(action), 10, 8); err == nil {
+		return policy.ActionType(n), nil
+	}
+
+	var parsed policy.ActionType
+	for _, name := range strings.Split(action, actionSeparator) {
+		flag, ok := actionNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("invalid action %q", action)
+		}
+		parsed |= flag
+	}
+
+	return parsed, nil
+}
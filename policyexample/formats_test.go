@@ -0,0 +1,181 @@
+package policyexample
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+func TestActionNameParseAction(t *testing.T) {
+
+	tests := []struct {
+		action policy.ActionType
+		name   string
+	}{
+		{action: policy.Accept, name: "accept"},
+		{action: policy.Reject, name: "reject"},
+		{action: policy.Accept | policy.Log, name: "accept|log"},
+		{action: policy.Reject | policy.Log, name: "reject|log"},
+		{action: policy.Accept | policy.Encrypt | policy.Log, name: "accept|encrypt|log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if name := ActionName(tt.action); name != tt.name {
+				t.Errorf("ActionName(%d) = %q, want %q", tt.action, name, tt.name)
+			}
+
+			parsed, err := ParseAction(ActionName(tt.action))
+			if err != nil {
+				t.Fatalf("ParseAction(%q) failed: %s", tt.name, err)
+			}
+			if parsed != tt.action {
+				t.Errorf("ParseAction(%q) = %d, want %d", tt.name, parsed, tt.action)
+			}
+		})
+	}
+}
+
+func TestParseAction(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		action policy.ActionType
+		err    bool
+	}{
+		{name: "9", action: policy.Accept | policy.Log},
+		{name: " Accept | LOG ", action: policy.Accept | policy.Log},
+		{name: "accept+log", err: true},
+		{name: "allow", err: true},
+		{name: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			action, err := ParseAction(tt.name)
+			if tt.err != (err != nil) {
+				t.Fatalf("ParseAction(%q) error = %v, want error %t", tt.name, err, tt.err)
+			}
+			if action != tt.action {
+				t.Errorf("ParseAction(%q) = %d, want %d", tt.name, action, tt.action)
+			}
+		})
+	}
+}
+
+func TestDecodeDocument(t *testing.T) {
+
+	want := map[string]interface{}{
+		"web": map[string]interface{}{
+			"NetworkACLs": []interface{}{
+				map[string]interface{}{
+					"Address":  "10.0.0.0/8",
+					"Port":     "80",
+					"Protocol": "tcp",
+					"Policy":   map[string]interface{}{"Action": "accept|log", "PolicyID": "1"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		file string
+		data string
+		want map[string]interface{}
+		err  string
+	}{
+		{
+			file: "policy.json",
+			data: `{"web": {"NetworkACLs": [{"Address": "10.0.0.0/8", "Port": "80", "Protocol": "tcp", "Policy": {"Action": "accept|log", "PolicyID": "1"}}]}}`,
+			want: want,
+		},
+		{
+			file: "policy.yaml",
+			data: `
+web:
+  NetworkACLs:
+    - Address: 10.0.0.0/8
+      Port: "80"
+      Protocol: tcp
+      Policy: {Action: accept|log, PolicyID: "1"}
+`,
+			want: want,
+		},
+		{
+			file: "policy.YML",
+			data: "",
+			want: map[string]interface{}{},
+		},
+		{
+			file: "policy.toml",
+			data: `
+[[web.NetworkACLs]]
+Address = "10.0.0.0/8"
+Port = "80"
+Protocol = "tcp"
+Policy = {Action = "accept|log", PolicyID = "1"}
+`,
+			want: want,
+		},
+		{
+			file: "policy.yaml",
+			data: "- web\n- db\n",
+			err:  "policy file must be a map of policies",
+		},
+		{
+			file: "policy",
+			data: `{"web": `,
+			err:  "unexpected EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+
+			doc, err := decodeDocument(tt.file, []byte(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The TOML decoder returns other slice types: compare the JSON
+			// documents the policies are read from
+			got, _ := json.Marshal(doc)          // nolint
+			expected, _ := json.Marshal(tt.want) // nolint
+			if string(got) != string(expected) {
+				t.Errorf("document = %s, want %s", got, expected)
+			}
+		})
+	}
+}
+
+func TestConvertYAML(t *testing.T) {
+
+	value := map[interface{}]interface{}{
+		"web": []interface{}{
+			map[interface{}]interface{}{1: "one", true: "yes"},
+		},
+		"n": 3,
+	}
+
+	want := map[string]interface{}{
+		"web": []interface{}{
+			map[string]interface{}{"1": "one", "true": "yes"},
+		},
+		"n": 3,
+	}
+
+	if got := convertYAML(value); !reflect.DeepEqual(got, want) {
+		t.Errorf("convertYAML = %#v, want %#v", got, want)
+	}
+}
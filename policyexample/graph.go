@@ -0,0 +1,333 @@
+package policyexample
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// Kinds of the nodes of a policy graph
+const (
+	NodePolicy = "policy"
+	NodeCIDR   = "cidr"
+	NodePU     = "pu"
+)
+
+// Kinds of the edges of a policy graph
+const (
+	// EdgePolicy links two policies whose PUs can talk to each other
+	EdgePolicy = "policy"
+	// EdgeACL links a policy and an external network
+	EdgeACL = "acl"
+	// EdgeUses links a PU and the policy it uses
+	EdgeUses = "uses"
+	// EdgeFlow links two PUs that can talk to each other
+	EdgeFlow = "flow"
+)
+
+// GraphNode is a policy, an external network or a PU of a policy graph
+type GraphNode struct {
+	ID    string
+	Kind  string
+	Label string
+}
+
+// GraphEdge is a link of a policy graph. Traffic goes from From to To.
+type GraphEdge struct {
+	From string
+	To   string
+	Kind string
+	// Decision is DecisionAllow or DecisionDeny
+	Decision string `json:",omitempty"`
+	// Audit is true if the traffic is only allowed in audit mode
+	Audit bool   `json:",omitempty"`
+	Label string `json:",omitempty"`
+}
+
+// Graph describes who can talk to whom according to a policy file
+type Graph struct {
+	Nodes []*GraphNode
+	Edges []*GraphEdge
+}
+
+// graphProfile is a set of tags known to be carried by the PUs of a policy
+type graphProfile struct {
+	policyIndex string
+	tags        []string
+	cached      *CachedPolicy
+}
+
+// PolicyGraph builds the graph of a policy file. Two policies are linked when
+// the Dependencies of the source and the ExposureRules of the destination both
+// accept the traffic. The tags of the PUs of a policy are only known from the
+// way the policy is selected: the PolicyIndex or user tag, and the clauses of
+// its bindings. The ACLs link the policies to the external networks. The
+// enforced PUs given, if any, are added with the policy they use and the flows
+// allowed between them based on their actual tags.
+func PolicyGraph(file string, pus []*PUStatus) (*Graph, error) {
+
+	p, err := NewCustomPolicyResolver(nil, nil, file, false)
+	if err != nil {
+		return nil, err
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+
+	g := &Graph{Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+
+	indexes := make([]string, 0, len(p.policies))
+	for index := range p.policies {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+
+	profiles := []*graphProfile{}
+	for _, index := range indexes {
+		g.addNode(policyNodeID(index), NodePolicy, index)
+		for _, tags := range p.profiles(index) {
+			cached, perr := p.policyFor(index, graphRuntime(index, tags))
+			if perr != nil {
+				return nil, perr
+			}
+			profiles = append(profiles, &graphProfile{policyIndex: index, tags: tags, cached: cached})
+		}
+	}
+
+	// Policies talking to each other
+	linked := map[string]bool{}
+	for _, src := range profiles {
+		for _, dst := range profiles {
+			key := src.policyIndex + "\x00" + dst.policyIndex
+			if linked[key] {
+				continue
+			}
+			if edge := graphFlow(src.cached, dst.cached, src.tags, dst.tags); edge != nil {
+				edge.From = policyNodeID(src.policyIndex)
+				edge.To = policyNodeID(dst.policyIndex)
+				edge.Kind = EdgePolicy
+				g.Edges = append(g.Edges, edge)
+				linked[key] = true
+			}
+		}
+	}
+
+	// External networks
+	for _, index := range indexes {
+		cached, perr := p.policyFor(index, graphRuntime(index, nil))
+		if perr != nil {
+			return nil, perr
+		}
+		g.addACLEdges(policyNodeID(index), *cached.ApplicationACLs, false)
+		g.addACLEdges(policyNodeID(index), *cached.NetworkACLs, true)
+	}
+
+	// Enforced PUs
+	enforced := []*graphProfile{}
+	for _, pu := range pus {
+		if !pu.Enforced {
+			continue
+		}
+		cached, perr := p.policyFor(pu.PolicyIndex, graphRuntime(pu.Name, pu.Tags))
+		if perr != nil {
+			return nil, fmt.Errorf("PU %s: %s", pu.ID, perr)
+		}
+		g.addNode(puNodeID(pu.ID), NodePU, pu.Name)
+		g.Edges = append(g.Edges, &GraphEdge{From: puNodeID(pu.ID), To: policyNodeID(pu.PolicyIndex), Kind: EdgeUses})
+		enforced = append(enforced, &graphProfile{policyIndex: pu.ID, tags: pu.Tags, cached: cached})
+	}
+
+	for _, src := range enforced {
+		for _, dst := range enforced {
+			if src == dst {
+				continue
+			}
+			if edge := graphFlow(src.cached, dst.cached, src.tags, dst.tags); edge != nil {
+				edge.From = puNodeID(src.policyIndex)
+				edge.To = puNodeID(dst.policyIndex)
+				edge.Kind = EdgeFlow
+				g.Edges = append(g.Edges, edge)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// profiles returns the sets of tags known to be carried by the PUs of a
+// policy. The caller must hold the lock.
+func (p *CustomPolicyResolver) profiles(policyIndex string) [][]string {
+
+	profiles := [][]string{}
+	if policyIndex != "default" {
+		profiles = append(profiles,
+			[]string{"@usr:PolicyIndex=" + policyIndex},
+			[]string{"@usr:user=" + policyIndex},
+		)
+	} else {
+		profiles = append(profiles, []string{})
+	}
+
+	for _, binding := range p.bindings {
+		if binding.Policy != policyIndex {
+			continue
+		}
+		tags := []string{}
+		for _, clause := range binding.Match {
+			key := clause.Key
+			if !strings.HasPrefix(key, "@") {
+				key = "@usr:" + key
+			}
+			switch clause.Operator {
+			case BindingEqual, BindingIn:
+				tags = append(tags, key+"="+clause.Value[0])
+			case BindingExists:
+				tags = append(tags, key+"=")
+			}
+		}
+		profiles = append(profiles, tags)
+	}
+
+	return profiles
+}
+
+// graphFlow returns an edge if the traffic from src to dst is accepted by
+// both policies, or nil
+func graphFlow(src, dst *CachedPolicy, srcTags, dstTags []string) *GraphEdge {
+
+	dependency := matchSelectors("source", "Dependencies", src.Dependencies, dstTags)
+	exposure := matchSelectors("destination", "ExposureRules", dst.ExposureRules, srcTags)
+	if dependency.Decision == DecisionDeny || exposure.Decision == DecisionDeny {
+		return nil
+	}
+
+	return &GraphEdge{
+		Decision: DecisionAllow,
+		Audit:    dependency.Audit || exposure.Audit,
+		Label:    dependency.PolicyID + " / " + exposure.PolicyID,
+	}
+}
+
+// addACLEdges adds the edges between a policy and the networks of its ACLs.
+// The ports of the ACLs with the same network and action share an edge.
+func (g *Graph) addACLEdges(policyNode string, acls policy.IPRuleList, incoming bool) {
+
+	type aclEdge struct {
+		edge  *GraphEdge
+		ports []string
+	}
+
+	edges := map[string]*aclEdge{}
+	order := []string{}
+
+	for _, acl := range acls {
+		if acl.Policy == nil {
+			continue
+		}
+		decision := DecisionAllow
+		if acl.Policy.Action.Rejected() {
+			decision = DecisionDeny
+		}
+		_, audit := ParseAuditPolicyID(acl.Policy.PolicyID)
+
+		key := acl.Address + "\x00" + decision
+		e, ok := edges[key]
+		if !ok {
+			g.addNode(cidrNodeID(acl.Address), NodeCIDR, acl.Address)
+			e = &aclEdge{edge: &GraphEdge{From: policyNode, To: cidrNodeID(acl.Address), Kind: EdgeACL, Decision: decision, Audit: audit}}
+			if incoming {
+				e.edge.From, e.edge.To = e.edge.To, e.edge.From
+			}
+			edges[key] = e
+			order = append(order, key)
+		}
+
+		port := strings.ToLower(acl.Protocol)
+		if acl.Port != "" {
+			port = acl.Port + "/" + port
+		}
+		e.ports = append(e.ports, port)
+	}
+
+	for _, key := range order {
+		e := edges[key]
+		e.edge.Label = strings.Join(e.ports, ", ")
+		g.Edges = append(g.Edges, e.edge)
+	}
+}
+
+// addNode adds a node once
+func (g *Graph) addNode(id, kind, label string) {
+
+	for _, node := range g.Nodes {
+		if node.ID == id {
+			return
+		}
+	}
+
+	g.Nodes = append(g.Nodes, &GraphNode{ID: id, Kind: kind, Label: label})
+}
+
+// DOT returns the graph in the Graphviz DOT language
+func (g *Graph) DOT() string {
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("digraph policies {\n")
+	buf.WriteString("  rankdir=LR;\n")
+
+	for _, node := range g.Nodes {
+		style := "shape=box"
+		switch node.Kind {
+		case NodeCIDR:
+			style = "shape=ellipse"
+		case NodePU:
+			style = "shape=oval, style=filled, fillcolor=lightgrey"
+		}
+		fmt.Fprintf(buf, "  %q [label=%q, %s];\n", node.ID, node.Label, style)
+	}
+
+	for _, edge := range g.Edges {
+		attributes := []string{}
+		if edge.Label != "" {
+			attributes = append(attributes, fmt.Sprintf("label=%q", edge.Label))
+		}
+		switch {
+		case edge.Kind == EdgeUses:
+			attributes = append(attributes, "style=dotted", "arrowhead=none")
+		case edge.Decision == DecisionDeny:
+			attributes = append(attributes, "color=red", "style=dashed")
+		case edge.Audit:
+			attributes = append(attributes, "color=orange")
+		}
+		fmt.Fprintf(buf, "  %q -> %q [%s];\n", edge.From, edge.To, strings.Join(attributes, ", "))
+	}
+
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
+
+// graphRuntime returns a runtime carrying the given tags
+func graphRuntime(name string, tags []string) *policy.PURuntime {
+
+	return policy.NewPURuntime(name, 0, "", policy.NewTagStoreFromSlice(tags), policy.ExtendedMap{}, common.ContainerPU, nil)
+}
+
+// policyNodeID returns the ID of the node of a policy
+func policyNodeID(policyIndex string) string {
+	return NodePolicy + ":" + policyIndex
+}
+
+// cidrNodeID returns the ID of the node of a network
+func cidrNodeID(address string) string {
+	return NodeCIDR + ":" + address
+}
+
+// puNodeID returns the ID of the node of a PU
+func puNodeID(puID string) string {
+	return NodePU + ":" + puID
+}
@@ -0,0 +1,95 @@
+package policyexample
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPolicyGraph(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", testSimulationFile)
+	defer cleanup()
+
+	pus := []*PUStatus{
+		{ID: "a1", Name: "/web-1", Tags: []string{"@usr:app=web"}, PolicyIndex: "web", Enforced: true},
+		{ID: "b1", Name: "/db-1", Tags: []string{"@usr:app=db"}, PolicyIndex: "db", Enforced: true},
+		{ID: "c1", Name: "/db-2", Tags: []string{"@usr:app=db"}, PolicyIndex: "db"},
+	}
+
+	g, err := PolicyGraph(file, pus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := []string{}
+	for _, node := range g.Nodes {
+		nodes = append(nodes, node.ID)
+	}
+	want := "[policy:db policy:default policy:web cidr:10.0.0.0/8 cidr:0.0.0.0/0 cidr:10.1.0.0/16 pu:a1 pu:b1]"
+	if fmt.Sprint(nodes) != want {
+		t.Errorf("nodes = %v, want %s", nodes, want)
+	}
+
+	edges := []string{}
+	for _, edge := range g.Edges {
+		edges = append(edges, fmt.Sprintf("%s -> %s %s %s %s", edge.From, edge.To, edge.Kind, edge.Decision, edge.Label))
+	}
+	wantEdges := []string{
+		"policy:web -> policy:db policy allow web-to-db / db-from-web",
+		"cidr:10.0.0.0/8 -> policy:db acl allow 5000:6000/tcp",
+		"policy:web -> cidr:0.0.0.0/0 acl allow 443/tcp",
+		"policy:web -> cidr:10.1.0.0/16 acl deny 443/tcp",
+		"pu:a1 -> policy:web uses  ",
+		"pu:b1 -> policy:db uses  ",
+		"pu:a1 -> pu:b1 flow allow web-to-db / db-from-web",
+	}
+	if fmt.Sprint(edges) != fmt.Sprint(wantEdges) {
+		t.Errorf("edges =\n%s\nwant\n%s", strings.Join(edges, "\n"), strings.Join(wantEdges, "\n"))
+	}
+
+	dot := g.DOT()
+	for _, line := range []string{
+		`"policy:web" [label="web", shape=box];`,
+		`"cidr:10.1.0.0/16" [label="10.1.0.0/16", shape=ellipse];`,
+		`"pu:a1" [label="/web-1", shape=oval, style=filled, fillcolor=lightgrey];`,
+		`"policy:web" -> "cidr:10.1.0.0/16" [label="443/tcp", color=red, style=dashed];`,
+		`"pu:a1" -> "policy:web" [style=dotted, arrowhead=none];`,
+	} {
+		if !strings.Contains(dot, "  "+line+"\n") {
+			t.Errorf("DOT output misses %s:\n%s", line, dot)
+		}
+	}
+}
+
+func TestPolicyGraphAudit(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", `
+web:
+  Audit: true
+  Dependencies:
+    - {Clause: [{Key: "@usr:PolicyIndex", Operator: "=", Value: [db]}], Policy: {Action: reject, PolicyID: no-db}}
+db:
+  ExposureRules:
+    - {Clause: [{Key: "@usr:PolicyIndex", Operator: "=", Value: [web]}], Policy: {Action: accept, PolicyID: from-web}}
+`)
+	defer cleanup()
+
+	g, err := PolicyGraph(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var edge *GraphEdge
+	for _, e := range g.Edges {
+		if e.From == "policy:web" && e.To == "policy:db" {
+			edge = e
+		}
+	}
+	if edge == nil || !edge.Audit || edge.Label != "no-db / from-web" {
+		t.Fatalf("edge = %+v, want an audited edge from web to db", edge)
+	}
+	if !strings.Contains(g.DOT(), `"policy:web" -> "policy:db" [label="no-db / from-web", color=orange];`) {
+		t.Errorf("DOT output misses the audited edge:\n%s", g.DOT())
+	}
+}
@@ -0,0 +1,274 @@
+package policyexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// GroupsKey is the top-level key of a policy file holding the rule groups. It
+// cannot be used as the name of a policy.
+const GroupsKey = "groups"
+
+// RuleGroup is a named set of rules that policies can include with Groups
+type RuleGroup struct {
+	ApplicationACLs policy.IPRuleList
+	NetworkACLs     policy.IPRuleList
+	Dependencies    policy.TagSelectorList
+	ExposureRules   policy.TagSelectorList
+}
+
+// sourcedRule is an inherited ACL and the policy or group it comes from
+type sourcedRule struct {
+	rule   policy.IPRule
+	source string
+}
+
+// sourcedSelector is an inherited selector and the policy or group it comes from
+type sourcedSelector struct {
+	selector policy.TagSelector
+	source   string
+}
+
+// flattener resolves the Extends and Groups of the policies of a file into
+// concrete rule lists
+type flattener struct {
+	policies map[string]*CachedPolicy
+	groups   map[string]*RuleGroup
+	flat     map[string]*CachedPolicy
+	visiting map[string]bool
+	v        *validator
+}
+
+// flattenPolicies replaces every policy with its flattened version. Cycles,
+// unknown references and conflicting inherited rules are reported to the
+// validator.
+func flattenPolicies(policies map[string]*CachedPolicy, groups map[string]*RuleGroup, v *validator) {
+
+	f := &flattener{
+		policies: policies,
+		groups:   groups,
+		flat:     map[string]*CachedPolicy{},
+		visiting: map[string]bool{},
+		v:        v,
+	}
+
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f.flatten(name, []string{name})
+	}
+
+	for name, flat := range f.flat {
+		policies[name] = flat
+	}
+}
+
+// flatten returns the flattened version of a policy. The chain holds the
+// policies being flattened to detect cycles.
+func (f *flattener) flatten(name string, chain []string) *CachedPolicy {
+
+	if flat, ok := f.flat[name]; ok {
+		return flat
+	}
+
+	cached := f.policies[name]
+	f.visiting[name] = true
+	defer delete(f.visiting, name)
+
+	appACLs := []*sourcedRule{}
+	netACLs := []*sourcedRule{}
+	dependencies := []*sourcedSelector{}
+	exposures := []*sourcedSelector{}
+
+	for i, parent := range cached.Extends {
+		path := fmt.Sprintf("%s.Extends[%d]", name, i)
+
+		if _, ok := f.policies[parent]; !ok {
+			f.v.errorf(path, "unknown policy %q", parent)
+			continue
+		}
+		if f.visiting[parent] {
+			f.v.errorf(path, "inheritance cycle %s -> %s", strings.Join(cycle(chain, parent), " -> "), parent)
+			continue
+		}
+
+		flatParent := f.flatten(parent, append(chain, parent))
+		appACLs = append(appACLs, sourceRules(*flatParent.ApplicationACLs, parent)...)
+		netACLs = append(netACLs, sourceRules(*flatParent.NetworkACLs, parent)...)
+		dependencies = append(dependencies, sourceSelectors(flatParent.Dependencies, parent)...)
+		exposures = append(exposures, sourceSelectors(flatParent.ExposureRules, parent)...)
+	}
+
+	for i, groupName := range cached.Groups {
+		group, ok := f.groups[groupName]
+		if !ok {
+			f.v.errorf(fmt.Sprintf("%s.Groups[%d]", name, i), "unknown group %q", groupName)
+			continue
+		}
+
+		source := GroupsKey + "." + groupName
+		appACLs = append(appACLs, sourceRules(group.ApplicationACLs, source)...)
+		netACLs = append(netACLs, sourceRules(group.NetworkACLs, source)...)
+		dependencies = append(dependencies, sourceSelectors(group.Dependencies, source)...)
+		exposures = append(exposures, sourceSelectors(group.ExposureRules, source)...)
+	}
+
+	flat := &CachedPolicy{
+		Audit:           cached.Audit,
+		ApplicationACLs: f.mergeRules(name+".ApplicationACLs", appACLs, cached.ApplicationACLs),
+		NetworkACLs:     f.mergeRules(name+".NetworkACLs", netACLs, cached.NetworkACLs),
+		Dependencies:    f.mergeSelectors(name+".Dependencies", dependencies, cached.Dependencies),
+		ExposureRules:   f.mergeSelectors(name+".ExposureRules", exposures, cached.ExposureRules),
+	}
+	f.flat[name] = flat
+
+	return flat
+}
+
+// mergeRules merges the inherited ACLs with the own ACLs of a policy. An own
+// ACL overrides the inherited ones for the same address, port and protocol.
+// Inherited ACLs for the same traffic with different actions are a conflict.
+func (f *flattener) mergeRules(path string, inherited []*sourcedRule, own *policy.IPRuleList) *policy.IPRuleList {
+
+	overridden := map[string]bool{}
+	if own != nil {
+		for _, rule := range *own {
+			overridden[ruleKey(rule)] = true
+		}
+	}
+
+	merged := policy.IPRuleList{}
+	seen := map[string]*sourcedRule{}
+	for _, r := range inherited {
+		key := ruleKey(r.rule)
+		if overridden[key] {
+			continue
+		}
+		if previous, ok := seen[key]; ok {
+			if previous.rule.Policy.Action != r.rule.Policy.Action {
+				f.v.errorf(path, "conflicting rules for %s inherited from %s and %s", key, previous.source, r.source)
+			}
+			continue
+		}
+		seen[key] = r
+		merged = append(merged, r.rule)
+	}
+
+	if own != nil {
+		merged = append(merged, *own...)
+	}
+
+	return &merged
+}
+
+// mergeSelectors merges the inherited selectors with the own selectors of a
+// policy. An own selector overrides the inherited ones with the same clauses.
+// Inherited selectors with the same clauses and different actions are a conflict.
+func (f *flattener) mergeSelectors(path string, inherited []*sourcedSelector, own policy.TagSelectorList) policy.TagSelectorList {
+
+	overridden := map[string]bool{}
+	for _, selector := range own {
+		overridden[selectorKey(selector)] = true
+	}
+
+	merged := policy.TagSelectorList{}
+	seen := map[string]*sourcedSelector{}
+	for _, s := range inherited {
+		key := selectorKey(s.selector)
+		if overridden[key] {
+			continue
+		}
+		if previous, ok := seen[key]; ok {
+			if previous.selector.Policy.Action != s.selector.Policy.Action {
+				f.v.errorf(path, "conflicting selectors for %s inherited from %s and %s", key, previous.source, s.source)
+			}
+			continue
+		}
+		seen[key] = s
+		merged = append(merged, s.selector)
+	}
+
+	return append(merged, own...)
+}
+
+// cycle returns the part of the chain that starts with the given policy
+func cycle(chain []string, name string) []string {
+
+	for i, link := range chain {
+		if link == name {
+			return chain[i:]
+		}
+	}
+
+	return chain
+}
+
+// sourceRules tags a list of ACLs with their source
+func sourceRules(rules policy.IPRuleList, source string) []*sourcedRule {
+
+	sourced := make([]*sourcedRule, len(rules))
+	for i, rule := range rules {
+		sourced[i] = &sourcedRule{rule: rule, source: source}
+	}
+
+	return sourced
+}
+
+// sourceSelectors tags a list of selectors with their source
+func sourceSelectors(selectors policy.TagSelectorList, source string) []*sourcedSelector {
+
+	sourced := make([]*sourcedSelector, len(selectors))
+	for i, selector := range selectors {
+		sourced[i] = &sourcedSelector{selector: selector, source: source}
+	}
+
+	return sourced
+}
+
+// ruleKey identifies the traffic an ACL applies to
+func ruleKey(rule policy.IPRule) string {
+
+	return fmt.Sprintf("%s/%s/%s", rule.Address, strings.ToLower(rule.Protocol), rule.Port)
+}
+
+// selectorKey identifies the PUs a selector applies to
+func selectorKey(selector policy.TagSelector) string {
+
+	data, err := json.Marshal(selector.Clause)
+	if err != nil {
+		return fmt.Sprintf("%v", selector.Clause)
+	}
+
+	return string(data)
+}
+
+// checkGroups validates the rule groups of a policy file
+func (v *validator) checkGroups(groups map[string]*RuleGroup) {
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := GroupsKey + "." + name
+		group := groups[name]
+		if group == nil {
+			v.errorf(path, "group is empty")
+			continue
+		}
+		v.checkRules(path+".ApplicationACLs", group.ApplicationACLs)
+		v.checkRules(path+".NetworkACLs", group.NetworkACLs)
+		v.checkSelectors(path+".Dependencies", group.Dependencies)
+		v.checkSelectors(path+".ExposureRules", group.ExposureRules)
+	}
+}
@@ -0,0 +1,191 @@
+package policyexample
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// rulePolicyIDs returns the policy IDs of a list of ACLs
+func rulePolicyIDs(rules *policy.IPRuleList) []string {
+
+	ids := []string{}
+	for _, rule := range *rules {
+		ids = append(ids, rule.Policy.PolicyID)
+	}
+
+	return ids
+}
+
+func TestFlattenPolicies(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", `
+groups:
+  dns:
+    ApplicationACLs:
+      - {Address: 0.0.0.0/0, Port: "53", Protocol: udp, Policy: {Action: accept, PolicyID: dns}}
+  ssh:
+    NetworkACLs:
+      - {Address: 10.0.0.0/8, Port: "22", Protocol: tcp, Policy: {Action: accept, PolicyID: ssh}}
+    ExposureRules:
+      - {Clause: [{Key: role, Operator: "=", Value: [admin]}], Policy: {Action: accept, PolicyID: admin}}
+base:
+  Groups: [dns]
+  ApplicationACLs:
+    - {Address: 10.0.0.0/8, Port: "443", Protocol: tcp, Policy: {Action: accept, PolicyID: base-https}}
+    - {Address: 10.0.0.0/8, Port: "80", Protocol: tcp, Policy: {Action: accept, PolicyID: base-http}}
+web:
+  Extends: [base]
+  Groups: [ssh]
+  ApplicationACLs:
+    - {Address: 10.0.0.0/8, Port: "443", Protocol: TCP, Policy: {Action: reject, PolicyID: web-https}}
+  ExposureRules:
+    - {Clause: [{Key: role, Operator: "=", Value: [admin]}], Policy: {Action: reject, PolicyID: no-admin}}
+shop:
+  Extends: [web]
+`)
+	defer cleanup()
+
+	policies, err := LoadPolicies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		app      []string
+		net      []string
+		exposure []string
+	}{
+		{name: "base", app: []string{"dns", "base-https", "base-http"}, net: []string{}, exposure: []string{}},
+		{name: "web", app: []string{"dns", "base-http", "web-https"}, net: []string{"ssh"}, exposure: []string{"no-admin"}},
+		{name: "shop", app: []string{"dns", "base-http", "web-https"}, net: []string{"ssh"}, exposure: []string{"no-admin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			flat, ok := policies[tt.name]
+			if !ok {
+				t.Fatalf("policy %s is missing", tt.name)
+			}
+			if len(flat.Extends) != 0 || len(flat.Groups) != 0 {
+				t.Errorf("policy %s still extends %v with groups %v", tt.name, flat.Extends, flat.Groups)
+			}
+			if ids := rulePolicyIDs(flat.ApplicationACLs); fmt.Sprint(ids) != fmt.Sprint(tt.app) {
+				t.Errorf("application ACLs = %v, want %v", ids, tt.app)
+			}
+			if ids := rulePolicyIDs(flat.NetworkACLs); fmt.Sprint(ids) != fmt.Sprint(tt.net) {
+				t.Errorf("network ACLs = %v, want %v", ids, tt.net)
+			}
+			exposure := []string{}
+			for _, selector := range flat.ExposureRules {
+				exposure = append(exposure, selector.Policy.PolicyID)
+			}
+			if fmt.Sprint(exposure) != fmt.Sprint(tt.exposure) {
+				t.Errorf("exposure rules = %v, want %v", exposure, tt.exposure)
+			}
+		})
+	}
+}
+
+func TestFlattenPoliciesErrors(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		errors  []string
+	}{
+		{
+			name: "cycle",
+			content: `
+a: {Extends: [b]}
+b: {Extends: [c]}
+c: {Extends: [a]}
+`,
+			errors: []string{"c.Extends[0]: inheritance cycle a -> b -> c -> a"},
+		},
+		{
+			name: "self",
+			content: `
+a: {Extends: [a]}
+`,
+			errors: []string{"a.Extends[0]: inheritance cycle a -> a"},
+		},
+		{
+			name: "unknown policy",
+			content: `
+a: {Extends: [missing]}
+`,
+			errors: []string{`a.Extends[0]: unknown policy "missing"`},
+		},
+		{
+			name: "conflicting groups",
+			content: `
+groups:
+  open:
+    NetworkACLs:
+      - {Address: 0.0.0.0/0, Port: "53", Protocol: udp, Policy: {Action: accept}}
+  closed:
+    NetworkACLs:
+      - {Address: 0.0.0.0/0, Port: "53", Protocol: udp, Policy: {Action: reject}}
+a: {Groups: [open, closed]}
+`,
+			errors: []string{"a.NetworkACLs: conflicting rules for 0.0.0.0/0/udp/53 inherited from groups.open and groups.closed"},
+		},
+		{
+			name: "conflicting parents",
+			content: `
+a:
+  Dependencies:
+    - {Clause: [{Key: app, Operator: "=", Value: [db]}], Policy: {Action: accept}}
+b:
+  Dependencies:
+    - {Clause: [{Key: app, Operator: "=", Value: [db]}], Policy: {Action: reject}}
+c: {Extends: [a, b]}
+`,
+			errors: []string{"c.Dependencies: conflicting selectors for"},
+		},
+		{
+			name: "same rule from two parents",
+			content: `
+a:
+  NetworkACLs:
+    - {Address: 0.0.0.0/0, Port: "53", Protocol: udp, Policy: {Action: accept}}
+b: {Extends: [a]}
+c: {Extends: [a, b]}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file, cleanup := writeTestFile(t, "policy.yaml", tt.content)
+			defer cleanup()
+
+			_, err := LoadPolicies(file)
+			if len(tt.errors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			errs, ok := err.(ValidationErrors)
+			if !ok {
+				t.Fatalf("error = %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.errors) {
+				t.Errorf("errors =\n%s\nwant %d errors", errs, len(tt.errors))
+			}
+			for _, message := range tt.errors {
+				if !strings.Contains(errs.Error(), message) {
+					t.Errorf("errors =\n%s\nwant %q", errs, message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,505 @@
+package policyexample
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"go.aporeto.io/trireme-lib/policy"
+	yaml "gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Tags set by the Kubernetes extractor on the PUs of the pods
+const (
+	kubernetesNamespaceTag = "@usr:namespace"
+	kubernetesLabelTag     = "@usr:"
+)
+
+// kubernetesNamespaceNameLabel is the label holding the name of a namespace.
+// It is the only namespace label a namespaceSelector can use, since the
+// extractors do not know the labels of the namespaces.
+const kubernetesNamespaceNameLabel = "kubernetes.io/metadata.name"
+
+// kubernetesDocumentSeparator separates the documents of a YAML manifest
+var kubernetesDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// KubernetesImport is the result of the conversion of Kubernetes NetworkPolicy
+// manifests. Every NetworkPolicy becomes a policy named "<namespace>/<name>"
+// and a binding selecting the pods of its podSelector.
+type KubernetesImport struct {
+	Policies map[string]*CachedPolicy
+	Bindings []*Binding
+	// Unsupported lists the constructs of the manifests that could not be
+	// converted exactly
+	Unsupported []string
+}
+
+// ImportNetworkPolicies converts the Kubernetes NetworkPolicy objects of YAML
+// or JSON manifests into policies and bindings. Pods and namespaces are
+// matched on the tags set by the Kubernetes extractor. Ingress rules become
+// ExposureRules and NetworkACLs, egress rules Dependencies and
+// ApplicationACLs. The constructs that cannot be converted are reported in
+// Unsupported rather than dropped silently.
+func ImportNetworkPolicies(files []string) (*KubernetesImport, error) {
+
+	c := &kubernetesConverter{
+		result: &KubernetesImport{
+			Policies:    map[string]*CachedPolicy{},
+			Bindings:    []*Binding{},
+			Unsupported: []string{},
+		},
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		objects, err := decodeManifests(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+
+		for i, object := range objects {
+			if err = c.convertObject(fmt.Sprintf("%s[%d]", file, i), object); err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		}
+	}
+
+	return c.result, nil
+}
+
+// Document returns the imported policies and bindings as a policy file
+// document
+func (i *KubernetesImport) Document() map[string]interface{} {
+
+	doc := map[string]interface{}{}
+	for name, cached := range i.Policies {
+		doc[name] = cached
+	}
+
+	if len(i.Bindings) > 0 {
+		doc[BindingsKey] = i.Bindings
+	}
+
+	return doc
+}
+
+// decodeManifests returns the objects of a YAML or JSON manifest, which may
+// hold several documents
+func decodeManifests(data []byte) ([]map[string]interface{}, error) {
+
+	objects := []map[string]interface{}{}
+
+	for _, document := range kubernetesDocumentSeparator.Split(string(data), -1) {
+		var raw interface{}
+		if err := yaml.Unmarshal([]byte(document), &raw); err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+		object, ok := convertYAML(raw).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("a manifest must hold Kubernetes objects")
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// kubernetesConverter converts NetworkPolicy objects
+type kubernetesConverter struct {
+	result *KubernetesImport
+}
+
+// unsupported reports a construct that cannot be converted exactly
+func (c *kubernetesConverter) unsupported(path string, format string, args ...interface{}) {
+
+	c.result.Unsupported = append(c.result.Unsupported, path+": "+fmt.Sprintf(format, args...))
+}
+
+// convertObject converts a NetworkPolicy or the NetworkPolicy items of a list
+func (c *kubernetesConverter) convertObject(path string, object map[string]interface{}) error {
+
+	switch kind, _ := object["kind"].(string); kind {
+	case "NetworkPolicy":
+		np := &networkingv1.NetworkPolicy{}
+		if err := decodeReserved(object, np); err != nil {
+			return err
+		}
+		c.convert(np)
+
+	case "List", "NetworkPolicyList":
+		items, _ := object["items"].([]interface{})
+		for i, item := range items {
+			itemObject, ok := item.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s.items[%d] is not a Kubernetes object", path, i)
+			}
+			if err := c.convertObject(fmt.Sprintf("%s.items[%d]", path, i), itemObject); err != nil {
+				return err
+			}
+		}
+
+	default:
+		c.unsupported(path, "kind %q ignored", kind)
+	}
+
+	return nil
+}
+
+// convert converts a NetworkPolicy into a policy and a binding
+func (c *kubernetesConverter) convert(np *networkingv1.NetworkPolicy) {
+
+	namespace := np.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	name := namespace + "/" + np.Name
+
+	if _, ok := c.result.Policies[name]; ok {
+		c.unsupported(name, "duplicate NetworkPolicy ignored")
+		return
+	}
+
+	binding := &Binding{
+		Name:   name,
+		Policy: name,
+		Match:  []*BindingClause{{Key: "namespace", Operator: BindingEqual, Value: []string{namespace}}},
+	}
+	binding.Match = append(binding.Match, c.bindingClauses(name+": spec.podSelector", np.Spec.PodSelector)...)
+	// The most specific selectors win when several policies select a pod
+	binding.Priority = len(binding.Match)
+
+	for _, other := range c.result.Bindings {
+		if reflect.DeepEqual(other.Match, binding.Match) {
+			c.unsupported(name, "selects the same pods as %s: only one policy applies to a PU", other.Name)
+		}
+	}
+
+	ingress := false
+	egress := len(np.Spec.Egress) > 0
+	if len(np.Spec.PolicyTypes) == 0 {
+		ingress = true
+	} else {
+		egress = false
+		for _, policyType := range np.Spec.PolicyTypes {
+			switch policyType {
+			case networkingv1.PolicyTypeIngress:
+				ingress = true
+			case networkingv1.PolicyTypeEgress:
+				egress = true
+			default:
+				c.unsupported(name+": spec.policyTypes", "unknown policy type %q ignored", policyType)
+			}
+		}
+	}
+
+	cached := emptyPolicy()
+
+	if ingress {
+		for i, rule := range np.Spec.Ingress {
+			path := fmt.Sprintf("%s: spec.ingress[%d]", name, i)
+			policyID := fmt.Sprintf("%s:ingress[%d]", name, i)
+			selectors, acls := c.convertRule(path, "from", policyID, namespace, rule.From, rule.Ports)
+			cached.ExposureRules = append(cached.ExposureRules, selectors...)
+			*cached.NetworkACLs = append(*cached.NetworkACLs, acls...)
+		}
+	} else {
+		cached.ExposureRules, *cached.NetworkACLs = allowAll(name + ":ingress")
+	}
+
+	if egress {
+		for i, rule := range np.Spec.Egress {
+			path := fmt.Sprintf("%s: spec.egress[%d]", name, i)
+			policyID := fmt.Sprintf("%s:egress[%d]", name, i)
+			selectors, acls := c.convertRule(path, "to", policyID, namespace, rule.To, rule.Ports)
+			cached.Dependencies = append(cached.Dependencies, selectors...)
+			*cached.ApplicationACLs = append(*cached.ApplicationACLs, acls...)
+		}
+	} else {
+		cached.Dependencies, *cached.ApplicationACLs = allowAll(name + ":egress")
+	}
+
+	c.result.Policies[name] = cached
+	c.result.Bindings = append(c.result.Bindings, binding)
+}
+
+// convertRule converts the peers and the ports of an ingress or egress rule
+// into selectors for the PUs and ACLs for the IP blocks. The peers that cannot
+// be converted exactly are ignored rather than allowing more traffic.
+func (c *kubernetesConverter) convertRule(path, peersField, policyID, namespace string, peers []networkingv1.NetworkPolicyPeer, ports []networkingv1.NetworkPolicyPort) (policy.TagSelectorList, policy.IPRuleList) {
+
+	accept := &policy.FlowPolicy{Action: policy.Accept, PolicyID: policyID}
+	reject := &policy.FlowPolicy{Action: policy.Reject, PolicyID: policyID}
+
+	portRules := c.convertPorts(path, ports)
+
+	// No peer means any peer
+	if len(peers) == 0 {
+		if len(ports) > 0 {
+			c.unsupported(path, "ports only apply to IP addresses: all the ports of the PUs are allowed")
+		}
+		return policy.TagSelectorList{allowAllSelector(accept)}, aclsFor("0.0.0.0/0", portRules, accept)
+	}
+
+	selectors := policy.TagSelectorList{}
+	acls := policy.IPRuleList{}
+	portsReported := false
+
+	for i, peer := range peers {
+		peerPath := fmt.Sprintf("%s.%s[%d]", path, peersField, i)
+
+		if peer.IPBlock != nil {
+			if peer.PodSelector != nil || peer.NamespaceSelector != nil {
+				c.unsupported(peerPath, "ipBlock cannot be combined with selectors: selectors ignored")
+			}
+			acls = append(acls, aclsFor(peer.IPBlock.CIDR, portRules, accept)...)
+			for _, except := range peer.IPBlock.Except {
+				acls = append(acls, aclsFor(except, portRules, reject)...)
+			}
+			continue
+		}
+
+		if len(ports) > 0 && !portsReported {
+			c.unsupported(path, "ports only apply to IP addresses: all the ports of the PUs are allowed")
+			portsReported = true
+		}
+
+		clauses := []policy.KeyValueOperator{{
+			Key:      kubernetesNamespaceTag,
+			Value:    []string{namespace},
+			Operator: policy.Equal,
+		}}
+		if peer.NamespaceSelector != nil {
+			namespaceClauses, ok := c.namespaceClauses(peerPath+".namespaceSelector", *peer.NamespaceSelector)
+			if !ok {
+				continue
+			}
+			clauses = namespaceClauses
+		}
+		if peer.PodSelector != nil {
+			podClauses, ok := c.selectorClauses(peerPath+".podSelector", *peer.PodSelector)
+			if !ok {
+				continue
+			}
+			clauses = append(clauses, podClauses...)
+		}
+
+		selectors = append(selectors, policy.TagSelector{Clause: clauses, Policy: accept})
+	}
+
+	return selectors, acls
+}
+
+// convertPorts returns the ports and protocols of the ACLs of a rule. No
+// ports means all ports and protocols.
+func (c *kubernetesConverter) convertPorts(path string, ports []networkingv1.NetworkPolicyPort) []policy.IPRule {
+
+	if len(ports) == 0 {
+		return allPorts()
+	}
+
+	rules := []policy.IPRule{}
+	for i, port := range ports {
+		portPath := fmt.Sprintf("%s.ports[%d]", path, i)
+
+		protocol := corev1.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = *port.Protocol
+		}
+		if protocol != corev1.ProtocolTCP && protocol != corev1.ProtocolUDP {
+			c.unsupported(portPath, "protocol %s is not supported: port ignored", protocol)
+			continue
+		}
+
+		value := "1:65535"
+		if port.Port != nil {
+			if port.Port.Type != intstr.Int {
+				c.unsupported(portPath, "named port %q is not supported: port ignored", port.Port.StrVal)
+				continue
+			}
+			value = fmt.Sprintf("%d", port.Port.IntVal)
+		}
+
+		rules = append(rules, policy.IPRule{Port: value, Protocol: string(protocol)})
+	}
+
+	return rules
+}
+
+// selectorClauses converts a pod selector into the clauses of a tag selector.
+// It returns false if the selector cannot be converted.
+func (c *kubernetesConverter) selectorClauses(path string, selector metav1.LabelSelector) ([]policy.KeyValueOperator, bool) {
+
+	clauses := []policy.KeyValueOperator{}
+
+	for _, key := range sortedKeys(selector.MatchLabels) {
+		clauses = append(clauses, policy.KeyValueOperator{
+			Key:      kubernetesLabelTag + key,
+			Value:    []string{selector.MatchLabels[key]},
+			Operator: policy.Equal,
+		})
+	}
+
+	for i, expression := range selector.MatchExpressions {
+		clause := policy.KeyValueOperator{Key: kubernetesLabelTag + expression.Key, Value: expression.Values}
+		switch expression.Operator {
+		case metav1.LabelSelectorOpIn:
+			clause.Operator = policy.Equal
+		case metav1.LabelSelectorOpNotIn:
+			clause.Operator = policy.NotEqual
+		case metav1.LabelSelectorOpExists:
+			clause.Operator = policy.KeyExists
+			clause.Value = nil
+		case metav1.LabelSelectorOpDoesNotExist:
+			clause.Operator = policy.KeyNotExists
+			clause.Value = nil
+		default:
+			c.unsupported(fmt.Sprintf("%s.matchExpressions[%d]", path, i), "operator %q is not supported: peer ignored", expression.Operator)
+			return nil, false
+		}
+		clauses = append(clauses, clause)
+	}
+
+	return clauses, true
+}
+
+// namespaceClauses converts a namespace selector into the clauses of a tag
+// selector. Only the name of the namespace is known to the extractors. It
+// returns false if the selector cannot be converted.
+func (c *kubernetesConverter) namespaceClauses(path string, selector metav1.LabelSelector) ([]policy.KeyValueOperator, bool) {
+
+	clauses := []policy.KeyValueOperator{}
+
+	for _, key := range sortedKeys(selector.MatchLabels) {
+		if key != kubernetesNamespaceNameLabel {
+			c.unsupported(path+".matchLabels", "namespace label %q is not known to the extractors: peer ignored", key)
+			return nil, false
+		}
+		clauses = append(clauses, policy.KeyValueOperator{
+			Key:      kubernetesNamespaceTag,
+			Value:    []string{selector.MatchLabels[key]},
+			Operator: policy.Equal,
+		})
+	}
+
+	for i, expression := range selector.MatchExpressions {
+		expressionPath := fmt.Sprintf("%s.matchExpressions[%d]", path, i)
+		if expression.Key != kubernetesNamespaceNameLabel {
+			c.unsupported(expressionPath, "namespace label %q is not known to the extractors: peer ignored", expression.Key)
+			return nil, false
+		}
+		clause := policy.KeyValueOperator{Key: kubernetesNamespaceTag, Value: expression.Values}
+		switch expression.Operator {
+		case metav1.LabelSelectorOpIn:
+			clause.Operator = policy.Equal
+		case metav1.LabelSelectorOpNotIn:
+			clause.Operator = policy.NotEqual
+		default:
+			c.unsupported(expressionPath, "operator %q is not supported: peer ignored", expression.Operator)
+			return nil, false
+		}
+		clauses = append(clauses, clause)
+	}
+
+	// An empty selector matches the pods of all the namespaces
+	if len(clauses) == 0 {
+		clauses = append(clauses, policy.KeyValueOperator{Key: kubernetesNamespaceTag, Operator: policy.KeyExists})
+	}
+
+	return clauses, true
+}
+
+// bindingClauses converts a pod selector into the clauses of a binding
+func (c *kubernetesConverter) bindingClauses(path string, selector metav1.LabelSelector) []*BindingClause {
+
+	clauses := []*BindingClause{}
+
+	for _, key := range sortedKeys(selector.MatchLabels) {
+		clauses = append(clauses, &BindingClause{Key: key, Operator: BindingEqual, Value: []string{selector.MatchLabels[key]}})
+	}
+
+	for i, expression := range selector.MatchExpressions {
+		switch expression.Operator {
+		case metav1.LabelSelectorOpIn:
+			clauses = append(clauses, &BindingClause{Key: expression.Key, Operator: BindingIn, Value: expression.Values})
+		case metav1.LabelSelectorOpNotIn:
+			for _, value := range expression.Values {
+				clauses = append(clauses, &BindingClause{Key: expression.Key, Operator: BindingNotEqual, Value: []string{value}})
+			}
+		case metav1.LabelSelectorOpExists:
+			clauses = append(clauses, &BindingClause{Key: expression.Key, Operator: BindingExists})
+		default:
+			c.unsupported(fmt.Sprintf("%s.matchExpressions[%d]", path, i), "operator %q is not supported by bindings: ignored", expression.Operator)
+		}
+	}
+
+	return clauses
+}
+
+// allowAll returns the selectors and the ACLs accepting all the traffic of a
+// direction not restricted by a NetworkPolicy
+func allowAll(policyID string) (policy.TagSelectorList, policy.IPRuleList) {
+
+	accept := &policy.FlowPolicy{Action: policy.Accept, PolicyID: policyID}
+
+	return policy.TagSelectorList{allowAllSelector(accept)}, aclsFor("0.0.0.0/0", allPorts(), accept)
+}
+
+// allowAllSelector returns a selector matching every PU
+func allowAllSelector(flowPolicy *policy.FlowPolicy) policy.TagSelector {
+
+	return policy.TagSelector{
+		Clause: []policy.KeyValueOperator{{Key: matchAllKey, Operator: policy.KeyNotExists}},
+		Policy: flowPolicy,
+	}
+}
+
+// allPorts returns the ports and protocols of ACLs matching all the traffic
+func allPorts() []policy.IPRule {
+
+	return []policy.IPRule{
+		{Port: "1:65535", Protocol: "TCP"},
+		{Port: "1:65535", Protocol: "UDP"},
+		{Protocol: "ICMP"},
+	}
+}
+
+// aclsFor returns the ACLs of an address for the given ports and protocols
+func aclsFor(address string, ports []policy.IPRule, flowPolicy *policy.FlowPolicy) policy.IPRuleList {
+
+	acls := make(policy.IPRuleList, len(ports))
+	for i, port := range ports {
+		acls[i] = policy.IPRule{
+			Address:  address,
+			Port:     port.Port,
+			Protocol: port.Protocol,
+			Policy:   flowPolicy,
+		}
+	}
+
+	return acls
+}
+
+// sortedKeys returns the keys of a map of labels in order
+func sortedKeys(labels map[string]string) []string {
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,175 @@
+package policyexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testNetworkPolicies = `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: db
+  namespace: shop
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  policyTypes: [Ingress, Egress]
+  ingress:
+    - from:
+        - podSelector:
+            matchLabels:
+              app: web
+        - namespaceSelector:
+            matchLabels:
+              kubernetes.io/metadata.name: monitoring
+        - ipBlock:
+            cidr: 10.0.0.0/8
+            except: [10.1.0.0/16]
+      ports:
+        - port: 5432
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: web
+spec:
+  podSelector:
+    matchExpressions:
+      - {key: tier, operator: In, values: [front]}
+  ingress:
+    - from:
+        - namespaceSelector:
+            matchLabels:
+              team: ops
+    - ports:
+        - port: http
+        - port: 53
+          protocol: SCTP
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: db
+`
+
+func TestImportNetworkPolicies(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policies.yaml", testNetworkPolicies)
+	defer cleanup()
+
+	imported, err := ImportNetworkPolicies([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(imported.Policies) != 2 || len(imported.Bindings) != 2 {
+		t.Fatalf("imported %d policies and %d bindings, want 2 and 2", len(imported.Policies), len(imported.Bindings))
+	}
+
+	// Bindings
+	bindings := []string{}
+	for _, binding := range imported.Bindings {
+		clauses := []string{}
+		for _, clause := range binding.Match {
+			clauses = append(clauses, fmt.Sprintf("%s %s %v", clause.Key, clause.Operator, clause.Value))
+		}
+		bindings = append(bindings, fmt.Sprintf("%s %d: %s", binding.Policy, binding.Priority, strings.Join(clauses, ", ")))
+	}
+	want := []string{
+		"shop/db 2: namespace = [shop], app = [db]",
+		"default/web 2: namespace = [default], tier in [front]",
+	}
+	if fmt.Sprint(bindings) != fmt.Sprint(want) {
+		t.Errorf("bindings =\n%s\nwant\n%s", strings.Join(bindings, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Ingress of shop/db: pods and namespaces, and the IP block on the port
+	db := imported.Policies["shop/db"]
+	want = []string{
+		"@usr:namespace=shop&@usr:app=web accept shop/db:ingress[0]",
+		"@usr:namespace=monitoring accept shop/db:ingress[0]",
+	}
+	if got := ruleStrings(db.ExposureRules); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("shop/db exposure rules =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	acls := []string{}
+	for _, acl := range *db.NetworkACLs {
+		acls = append(acls, fmt.Sprintf("%s %s/%s %s", acl.Address, acl.Port, acl.Protocol, ActionName(acl.Policy.Action)))
+	}
+	if want = []string{"10.0.0.0/8 5432/TCP accept", "10.1.0.0/16 5432/TCP reject"}; fmt.Sprint(acls) != fmt.Sprint(want) {
+		t.Errorf("shop/db network ACLs = %v, want %v", acls, want)
+	}
+
+	// Egress of shop/db is restricted without rules: nothing is allowed
+	if len(db.Dependencies) != 0 || len(*db.ApplicationACLs) != 0 {
+		t.Errorf("shop/db egress = %v %v, want nothing", db.Dependencies, *db.ApplicationACLs)
+	}
+
+	// Egress of default/web is not restricted
+	web := imported.Policies["default/web"]
+	if got := ruleStrings(web.Dependencies); len(got) != 1 || !strings.HasPrefix(got[0], matchAllKey+"!*") {
+		t.Errorf("default/web dependencies = %v, want all the PUs", got)
+	}
+	if len(*web.ApplicationACLs) != 3 {
+		t.Errorf("default/web application ACLs = %v, want all the traffic", *web.ApplicationACLs)
+	}
+
+	// Unsupported constructs are reported, not dropped silently
+	unsupported := strings.Join(imported.Unsupported, "\n")
+	for _, message := range []string{
+		`shop/db: spec.ingress[0]: ports only apply to IP addresses`,
+		`default/web: spec.ingress[0].from[0].namespaceSelector.matchLabels: namespace label "team" is not known to the extractors: peer ignored`,
+		`default/web: spec.ingress[1].ports[0]: named port "http" is not supported: port ignored`,
+		`default/web: spec.ingress[1].ports[1]: protocol SCTP is not supported: port ignored`,
+		`[2]: kind "Service" ignored`,
+	} {
+		if !strings.Contains(unsupported, message) {
+			t.Errorf("unsupported =\n%s\nwant %q", unsupported, message)
+		}
+	}
+
+	// The document is a valid policy file
+	data, err := json.Marshal(imported.Document())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyFile, cleanupPolicy := writeTestFile(t, "policy.json", string(data))
+	defer cleanupPolicy()
+
+	loaded, err := LoadPolicyFile(policyFile)
+	if err != nil {
+		t.Fatalf("imported policy file is invalid: %s", err)
+	}
+	if len(loaded.Bindings) != 2 {
+		t.Errorf("bindings = %d, want 2", len(loaded.Bindings))
+	}
+}
+
+func TestImportNetworkPoliciesErrors(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{name: "not an object", manifest: "- a\n- b\n", err: "a manifest must hold Kubernetes objects"},
+		{name: "bad list item", manifest: "kind: List\nitems: [1]\n", err: "items[0] is not a Kubernetes object"},
+		{name: "invalid YAML", manifest: "kind: [", err: "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file, cleanup := writeTestFile(t, "manifest.yaml", tt.manifest)
+			defer cleanup()
+
+			if _, err := ImportNetworkPolicies([]string{file}); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
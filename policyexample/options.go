@@ -0,0 +1,28 @@
+package policyexample
+
+// Option is an option of the CustomPolicyResolver
+type Option func(*CustomPolicyResolver)
+
+// OptionStore persists the PUs handled by the resolver in the given store, so
+// that they can be restored after a restart.
+func OptionStore(store Store) Option {
+	return func(p *CustomPolicyResolver) {
+		p.store = store
+	}
+}
+
+// OptionDefaultScope limits the default policy to PUs that share the value of
+// the given tag, such as the project of docker-compose containers.
+func OptionDefaultScope(tag string) Option {
+	return func(p *CustomPolicyResolver) {
+		p.defaultScope = tag
+	}
+}
+
+// OptionAudit enables the audit mode for all the policies: reject rules accept
+// and log the traffic instead.
+func OptionAudit() Option {
+	return func(p *CustomPolicyResolver) {
+		p.audit = true
+	}
+}
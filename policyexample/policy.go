@@ -4,10 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/aporeto-inc/trireme-example/metrics"
 	"go.aporeto.io/trireme-lib/common"
 	"go.aporeto.io/trireme-lib/controller"
 	"go.aporeto.io/trireme-lib/policy"
@@ -16,52 +21,194 @@ import (
 
 // CustomPolicyResolver is a simple policy engine
 type CustomPolicyResolver struct {
-	triremeNets []string
-	policies    map[string]*CachedPolicy
-	controller  controller.TriremeController
+	triremeNets    []string
+	policyFile     string
+	policies       map[string]*CachedPolicy
+	defaults       *DefaultPolicy
+	bindings       []*Binding
+	pus            map[string]*puState
+	defaultScope   string
+	audit          bool
+	lastReload     time.Time
+	reloadErr      error
+	reloadPUErrors []*PUReloadError
+	store          Store
+	controller     controller.TriremeController
+	sync.RWMutex
 }
 
-// CachedPolicy is a policy for a single container as read by a file
+// CachedPolicy is a policy for a single container as read by a file. The
+// rules of the policies in Extends and of the rule groups in Groups are
+// merged into the policy when the file is loaded. In audit mode, the reject
+// rules of the policy accept and log the traffic instead.
 type CachedPolicy struct {
+	Extends         []string `json:",omitempty"`
+	Groups          []string `json:",omitempty"`
+	Audit           bool     `json:",omitempty"`
 	ApplicationACLs *policy.IPRuleList
 	NetworkACLs     *policy.IPRuleList
 	Dependencies    policy.TagSelectorList
 	ExposureRules   policy.TagSelectorList
 }
 
-// LoadPolicies loads a set of policies defined in a JSON file
-func LoadPolicies(file string) map[string]*CachedPolicy {
-	var config map[string]*CachedPolicy
+// puState is the state kept by the resolver for every PU it handles. The
+// puPolicy is the policy last passed to the controller for the PU.
+type puState struct {
+	runtime     *policy.PURuntime
+	policyIndex string
+	cached      *CachedPolicy
+	puPolicy    *policy.PUPolicy
+	selection   *PolicySelection
+	event       common.Event
+}
 
-	defaultConfig := &CachedPolicy{
-		ApplicationACLs: &policy.IPRuleList{},
-		NetworkACLs:     &policy.IPRuleList{},
-		Dependencies:    policy.TagSelectorList{},
-		ExposureRules:   policy.TagSelectorList{},
-	}
+// enforced returns true if the last event of the PU enforced its policy
+func (s *puState) enforced() bool {
+	return s.event == common.EventStart || s.event == common.EventUnpause
+}
+
+// PUReloadError is a PU whose policy could not be updated by a reload. The PU
+// keeps the policy it had before the reload.
+type PUReloadError struct {
+	ID          string
+	PolicyIndex string
+	Error       string
+}
+
+// PolicyFile is the content of a policy file
+type PolicyFile struct {
+	// Policies are the policies keyed by policy index
+	Policies map[string]*CachedPolicy
+	// Default configures the default policy. It is nil if the file does not.
+	Default *DefaultPolicy
+	// Bindings select the policy of the PUs without a policy index tag
+	Bindings []*Binding
+}
+
+// LoadPolicies loads a set of policies defined in a JSON, YAML or TOML file.
+// See LoadPolicyFile.
+func LoadPolicies(file string) (map[string]*CachedPolicy, error) {
 
-	configFile, err := os.Open(file)
+	policyFile, err := LoadPolicyFile(file)
 	if err != nil {
-		configFile.Close() //nolint
-		zap.L().Warn("No policy file found - using defaults")
-		return map[string]*CachedPolicy{
-			"default": defaultConfig,
+		return nil, err
+	}
+
+	return policyFile.Policies, nil
+}
+
+// LoadPolicyFile loads a policy file in JSON, YAML or TOML.
+// The format is chosen based on the extension of the file. The file is
+// validated and all the errors found are returned as ValidationErrors. The
+// default policy is always part of the returned set. An empty file name
+// returns only the default policy.
+func LoadPolicyFile(file string) (*PolicyFile, error) {
+	config := map[string]*CachedPolicy{}
+	var defaults *DefaultPolicy
+	bindings := []*Binding{}
+	groups := map[string]*RuleGroup{}
+
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, ValidationErrors{&PolicyError{File: file, Message: err.Error()}}
+		}
+
+		doc, err := decodeDocument(file, data)
+		if err != nil {
+			return nil, err
+		}
+
+		v := newValidator(file, data)
+		normalizeActions("", doc, v)
+		if err = v.err(); err != nil {
+			return nil, err
+		}
+
+		// Reserved keys are not policies
+		reserved := map[string]interface{}{}
+		for _, key := range []string{DefaultPolicyKey, BindingsKey, GroupsKey} {
+			if value, ok := doc[key]; ok {
+				reserved[key] = value
+				delete(doc, key)
+			}
+		}
+
+		normalized, err := json.Marshal(doc)
+		if err != nil {
+			return nil, ValidationErrors{&PolicyError{File: file, Message: err.Error()}}
+		}
+
+		if err = json.Unmarshal(normalized, &config); err != nil {
+			return nil, ValidationErrors{&PolicyError{File: file, Message: err.Error()}}
+		}
+
+		if value, ok := reserved[DefaultPolicyKey]; ok {
+			if err = decodeReserved(value, &defaults); err != nil {
+				v.errorf(DefaultPolicyKey, "%s", err)
+			} else if defaults != nil {
+				v.checkDefaultPolicy(defaults)
+			}
+		}
+
+		if value, ok := reserved[BindingsKey]; ok {
+			if err = decodeReserved(value, &bindings); err != nil {
+				v.errorf(BindingsKey, "%s", err)
+			} else {
+				v.checkBindings(bindings, config)
+			}
+		}
+
+		if value, ok := reserved[GroupsKey]; ok {
+			if err = decodeReserved(value, &groups); err != nil {
+				v.errorf(GroupsKey, "%s", err)
+			} else {
+				v.checkGroups(groups)
+			}
+		}
+
+		if err = v.validate(config); err != nil {
+			return nil, err
+		}
+
+		flattenPolicies(config, groups, v)
+		if err = v.err(); err != nil {
+			return nil, err
 		}
 	}
 
-	jsonParser := json.NewDecoder(configFile)
-	err = jsonParser.Decode(&config)
-	if err != nil {
-		zap.L().Error("Invalid policies - using default")
+	for _, cached := range config {
+		if cached.ApplicationACLs == nil {
+			cached.ApplicationACLs = &policy.IPRuleList{}
+		}
+		if cached.NetworkACLs == nil {
+			cached.NetworkACLs = &policy.IPRuleList{}
+		}
 	}
 
-	config["default"] = defaultConfig
+	config["default"] = &CachedPolicy{
+		ApplicationACLs: &policy.IPRuleList{},
+		NetworkACLs:     &policy.IPRuleList{},
+		Dependencies:    policy.TagSelectorList{},
+		ExposureRules:   policy.TagSelectorList{},
+	}
+
+	return &PolicyFile{
+		Policies: config,
+		Default:  defaults,
+		Bindings: bindings,
+	}, nil
+}
 
-	configFile.Close() //nolint
+// decodeReserved decodes the value of a reserved key of a policy file
+func decodeReserved(value interface{}, target interface{}) error {
 
-	zap.L().Info("Using policy from file", zap.String("Policy File", file))
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
 
-	return config
+	return json.Unmarshal(data, target)
 }
 
 // GetPolicyIndex assumes that one of the labels of the PU is
@@ -81,54 +228,358 @@ func GetPolicyIndex(runtimeInfo policy.RuntimeReader) (string, error) {
 	return "", fmt.Errorf("PolicyIndex Not Found")
 }
 
-// NewCustomPolicyResolver creates a new example policy engine for the Trireme package
-func NewCustomPolicyResolver(controller controller.TriremeController, networks []string, policyFile string) *CustomPolicyResolver {
+// NewCustomPolicyResolver creates a new example policy engine for the Trireme package.
+// An invalid policy file is an error, unless fallback is set: the default policy
+// is then used until the file is fixed and reloaded.
+func NewCustomPolicyResolver(controller controller.TriremeController, networks []string, policyFile string, fallback bool, opts ...Option) (*CustomPolicyResolver, error) {
 
-	policies := LoadPolicies(policyFile)
+	loaded, err := LoadPolicyFile(policyFile)
+	if err != nil {
+		if !fallback {
+			return nil, err
+		}
+		zap.L().Error("Invalid policies - using default", zap.Error(err))
+		loaded, _ = LoadPolicyFile("")
+	} else if policyFile != "" {
+		zap.L().Info("Using policy from file", zap.String("Policy File", policyFile))
+	}
 
-	return &CustomPolicyResolver{
+	p := &CustomPolicyResolver{
 		triremeNets: networks,
-		policies:    policies,
+		policyFile:  policyFile,
+		policies:    loaded.Policies,
+		defaults:    loaded.Default,
+		bindings:    loaded.Bindings,
+		pus:         map[string]*puState{},
+		lastReload:  time.Now(),
+		reloadErr:   err,
 		controller:  controller,
 	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
 }
 
 // HandlePUEvent implements the Trireme Policy interface. Once policy is resolved
-// the resolver must call the controller to enforce the policy.
+// the resolver must call the controller to enforce the policy. PUs that are
+// stopped, paused or destroyed are unenforced with the policy they were
+// enforced with, so they are released even if the policy file changed.
 func (p *CustomPolicyResolver) HandlePUEvent(ctx context.Context, puID string, event common.Event, runtimeInfo policy.RuntimeReader) error {
 
+	start := time.Now()
+	defer func() {
+		metrics.PUEventDuration.WithLabelValues(string(event)).Observe(time.Since(start).Seconds())
+	}()
+
 	zap.L().Info("Resolving policy for container",
 		zap.String("containerID", puID),
 		zap.String("name", runtimeInfo.Name()),
 	)
 
-	policyIndex, err := GetPolicyIndex(runtimeInfo)
+	switch event {
+	case common.EventStart, common.EventUnpause:
+		return p.enforce(ctx, puID, event, runtimeInfo)
+	case common.EventPause, common.EventStop, common.EventDestroy:
+		return p.release(ctx, puID, event, runtimeInfo)
+	default:
+		return nil
+	}
+}
+
+// enforce resolves the policy of a PU and enforces it
+func (p *CustomPolicyResolver) enforce(ctx context.Context, puID string, event common.Event, runtimeInfo policy.RuntimeReader) error {
+
+	p.RLock()
+	policyIndex, cached, selection, err := p.resolve(runtimeInfo)
+	p.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	runtime := runtimeInfo.(*policy.PURuntime)
+	containerPolicyInfo := p.newPUPolicy(puID, cached, runtime)
+
+	if err = p.controller.Enforce(ctx, puID, containerPolicyInfo, runtime); err != nil {
+		metrics.EnforcementErrors.WithLabelValues("enforce").Inc()
+		return err
+	}
+
+	p.track(puID, &puState{
+		runtime:     runtime,
+		policyIndex: policyIndex,
+		cached:      cached,
+		puPolicy:    containerPolicyInfo,
+		selection:   selection,
+		event:       event,
+	})
+
+	return nil
+}
+
+// release unenforces a paused, stopped or destroyed PU. The tracked state of
+// the PU is used when there is one, since the policy file may have changed
+// since the PU was enforced. A stopped or destroyed PU is always untracked,
+// even if it cannot be unenforced, and a paused PU is kept as paused. A PU
+// destroyed without being tracked was already stopped and is left alone.
+func (p *CustomPolicyResolver) release(ctx context.Context, puID string, event common.Event, runtimeInfo policy.RuntimeReader) error {
+
+	p.RLock()
+	state, tracked := p.pus[puID]
+	p.RUnlock()
+
+	if !tracked {
+		if event == common.EventDestroy {
+			return nil
+		}
+		state = p.untrackedState(puID, runtimeInfo)
+	}
+
+	if event == common.EventPause {
+		p.track(puID, &puState{
+			runtime:     state.runtime,
+			policyIndex: state.policyIndex,
+			cached:      state.cached,
+			puPolicy:    state.puPolicy,
+			selection:   state.selection,
+			event:       event,
+		})
+	} else {
+		p.untrack(puID)
+	}
+
+	return p.unenforce(ctx, puID, state.puPolicy, state.runtime)
+}
+
+// untrackedState returns the state of a PU released before being tracked. If
+// its policy cannot be resolved, an empty policy is used to unenforce it.
+func (p *CustomPolicyResolver) untrackedState(puID string, runtimeInfo policy.RuntimeReader) *puState {
+
+	p.RLock()
+	policyIndex, cached, selection, err := p.resolve(runtimeInfo)
+	p.RUnlock()
+	if err != nil {
+		zap.L().Warn("Unable to resolve policy of released PU - using an empty policy",
+			zap.String("puID", puID),
+			zap.Error(err),
+		)
+		cached = &CachedPolicy{
+			ApplicationACLs: &policy.IPRuleList{},
+			NetworkACLs:     &policy.IPRuleList{},
+			Dependencies:    policy.TagSelectorList{},
+			ExposureRules:   policy.TagSelectorList{},
+		}
+	}
+
+	runtime := runtimeInfo.(*policy.PURuntime)
+
+	return &puState{
+		runtime:     runtime,
+		policyIndex: policyIndex,
+		cached:      cached,
+		puPolicy:    p.newPUPolicy(puID, cached, runtime),
+		selection:   selection,
+	}
+}
+
+// unenforce calls the controller to unenforce the policy of a PU
+func (p *CustomPolicyResolver) unenforce(ctx context.Context, puID string, containerPolicyInfo *policy.PUPolicy, runtime *policy.PURuntime) error {
+
+	if err := p.controller.UnEnforce(ctx, puID, containerPolicyInfo, runtime); err != nil {
+		metrics.EnforcementErrors.WithLabelValues("unenforce").Inc()
+		return err
+	}
+
+	return nil
+}
+
+// track records the state of a PU
+func (p *CustomPolicyResolver) track(puID string, state *puState) {
+
+	p.Lock()
+	p.pus[puID] = state
+	p.updateEnforcedPUs()
+	p.Unlock()
+
+	p.persist(&PURecord{ID: puID, PolicyIndex: state.policyIndex, Event: state.event, Runtime: state.runtime})
+}
+
+// untrack removes the state of a PU
+func (p *CustomPolicyResolver) untrack(puID string) {
+
+	p.Lock()
+	delete(p.pus, puID)
+	p.updateEnforcedPUs()
+	p.Unlock()
+
+	p.forget(puID)
+}
+
+// updateEnforcedPUs sets the gauge of the enforced PUs by type. The caller
+// must hold the lock.
+func (p *CustomPolicyResolver) updateEnforcedPUs() {
+
+	counts := map[string]float64{}
+	for _, puType := range []common.PUType{common.ContainerPU, common.LinuxProcessPU, common.UIDLoginPU} {
+		counts[PUTypeName(puType)] = 0
+	}
+
+	for _, pu := range p.pus {
+		if pu.enforced() {
+			counts[PUTypeName(pu.runtime.PUType())]++
+		}
+	}
+
+	for puType, count := range counts {
+		metrics.EnforcedPUs.WithLabelValues(puType).Set(count)
+	}
+}
+
+// Reload re-reads the policy file and swaps the active policies. The policy of
+// every enforced PU whose policy changed is updated in the controller. The PUs
+// whose new policy cannot be resolved or updated keep their current policy and
+// are returned. If the file cannot be loaded, the current policies are kept and
+// an error is returned.
+func (p *CustomPolicyResolver) Reload(ctx context.Context) ([]*PUReloadError, error) {
+
+	loaded, err := LoadPolicyFile(p.policyFile)
+
+	p.Lock()
+	p.lastReload = time.Now()
+	p.reloadErr = err
+	if err != nil {
+		p.Unlock()
+		return nil, err
+	}
+
+	p.policies = loaded.Policies
+	p.defaults = loaded.Default
+	p.bindings = loaded.Bindings
+	failed := []*PUReloadError{}
+	updates := map[string]*puState{}
+	for puID, pu := range p.pus {
+		if !pu.enforced() {
+			continue
+		}
+		policyIndex, cached, selection, rerr := p.resolve(pu.runtime)
+		if rerr != nil {
+			failed = append(failed, &PUReloadError{ID: puID, PolicyIndex: policyIndex, Error: rerr.Error()})
+			continue
+		}
+		if policyIndex == pu.policyIndex && reflect.DeepEqual(cached, pu.cached) {
+			pu.selection = selection
+			continue
+		}
+		updates[puID] = &puState{
+			runtime:     pu.runtime,
+			policyIndex: policyIndex,
+			cached:      cached,
+			puPolicy:    p.newPUPolicy(puID, cached, pu.runtime),
+			selection:   selection,
+			event:       pu.event,
+		}
+	}
+	p.Unlock()
+
+	zap.L().Info("Reloaded policy file",
+		zap.String("Policy File", p.policyFile),
+		zap.Int("updates", len(updates)),
+	)
+
+	for puID, pu := range updates {
+		zap.L().Info("Updating policy for PU",
+			zap.String("puID", puID),
+			zap.String("policyIndex", pu.policyIndex),
+		)
+		if err = p.controller.UpdatePolicy(ctx, puID, pu.puPolicy, pu.runtime); err != nil {
+			metrics.EnforcementErrors.WithLabelValues("update").Inc()
+			failed = append(failed, &PUReloadError{ID: puID, PolicyIndex: pu.policyIndex, Error: err.Error()})
+			continue
+		}
+		p.RLock()
+		current, ok := p.pus[puID]
+		p.RUnlock()
+		if ok && current.enforced() {
+			p.track(puID, pu)
+		}
+	}
+
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].ID < failed[j].ID
+	})
+
+	for _, pu := range failed {
+		zap.L().Error("Unable to update policy after reload - keeping current policy",
+			zap.String("puID", pu.ID),
+			zap.String("policyIndex", pu.PolicyIndex),
+			zap.String("error", pu.Error),
+		)
+	}
+
+	p.Lock()
+	p.reloadPUErrors = failed
+	p.Unlock()
+
+	return failed, nil
+}
+
+// resolve returns the policy index, the cached policy that apply to a PU and
+// how the policy was selected. The policy index selected is returned even if
+// there is no such policy. The caller must hold the lock.
+func (p *CustomPolicyResolver) resolve(runtimeInfo policy.RuntimeReader) (string, *CachedPolicy, *PolicySelection, error) {
+
+	policyIndex, selection := p.selectPolicy(runtimeInfo)
+
+	cached, err := p.policyFor(policyIndex, runtimeInfo)
 	if err != nil {
-		zap.L().Warn("Cannot find requested policy index - Associating default policy")
-		policyIndex = "default"
+		return policyIndex, nil, nil, err
 	}
 
-	puPolicy, ok := p.policies[policyIndex]
+	return policyIndex, cached, selection, nil
+}
+
+// policyFor returns the cached policy with the given index as it applies to a
+// PU. The caller must hold the lock.
+func (p *CustomPolicyResolver) policyFor(policyIndex string, runtimeInfo policy.RuntimeReader) (*CachedPolicy, error) {
+
+	cached, ok := p.policies[policyIndex]
 	if !ok {
-		return fmt.Errorf("No policy found")
+		return nil, fmt.Errorf("No policy found")
 	}
 
 	// For the default policy we accept traffic with the same labels
 	if policyIndex == "default" {
-		puPolicy.Dependencies = p.createDefaultRules(runtimeInfo)
-		puPolicy.ExposureRules = puPolicy.Dependencies
+		rules := p.createDefaultRules(runtimeInfo)
+		cached = &CachedPolicy{
+			ApplicationACLs: cached.ApplicationACLs,
+			NetworkACLs:     cached.NetworkACLs,
+			Dependencies:    rules,
+			ExposureRules:   rules,
+		}
+	}
+
+	if p.audit || cached.Audit {
+		cached = auditPolicy(cached)
 	}
 
+	return cached, nil
+}
+
+// newPUPolicy creates the Trireme policy of a PU out of a cached policy
+func (p *CustomPolicyResolver) newPUPolicy(puID string, cached *CachedPolicy, runtimeInfo policy.RuntimeReader) *policy.PUPolicy {
+
 	// Use the bridge IP from Docker.
 	ipl := policy.ExtendedMap{}
 
-	containerPolicyInfo := policy.NewPUPolicy(
+	return policy.NewPUPolicy(
 		puID,
 		policy.Police,
-		*puPolicy.ApplicationACLs,
-		*puPolicy.NetworkACLs,
-		puPolicy.Dependencies,
-		puPolicy.ExposureRules,
+		*cached.ApplicationACLs,
+		*cached.NetworkACLs,
+		cached.Dependencies,
+		cached.ExposureRules,
 		runtimeInfo.Tags(),
 		runtimeInfo.Tags(),
 		ipl,
@@ -139,41 +590,54 @@ func (p *CustomPolicyResolver) HandlePUEvent(ctx context.Context, puID string, e
 		nil,
 		[]string{},
 	)
-
-	switch event {
-	case common.EventStart:
-		return p.controller.Enforce(ctx, puID, containerPolicyInfo, runtimeInfo.(*policy.PURuntime))
-	case common.EventPause:
-		return p.controller.UnEnforce(ctx, puID, containerPolicyInfo, runtimeInfo.(*policy.PURuntime))
-	case common.EventUnpause:
-		return p.controller.Enforce(ctx, puID, containerPolicyInfo, runtimeInfo.(*policy.PURuntime))
-	case common.EventStop:
-		return p.controller.UnEnforce(ctx, puID, containerPolicyInfo, runtimeInfo.(*policy.PURuntime))
-	default:
-		return nil
-	}
 }
 
 // CreateRuleDB creates a simple Rule DB that accepts packets from
 // containers with the same labels as the instantiated container.
-// If any of the labels matches, the packet is accepted.
+// If any of the labels matches, the packet is accepted. If the resolver
+// has a scope tag, only containers with the same value of this tag match.
+// The labels used, the deny selectors and the stance for the rest of the
+// traffic come from the default policy of the policy file, if any.
 func (p *CustomPolicyResolver) createDefaultRules(runtimeInfo policy.RuntimeReader) policy.TagSelectorList {
 
 	selectorList := policy.TagSelectorList{}
 
+	defaults := p.defaults
+	if defaults == nil {
+		defaults = fallbackDefaultPolicy
+	}
+
 	tags := runtimeInfo.Tags()
 
+	scope := []policy.KeyValueOperator{}
+	if p.defaultScope != "" {
+		for _, tag := range tags.GetSlice() {
+			parts := strings.SplitN(tag, "=", 2)
+			if len(parts) == 2 && parts[0] == p.defaultScope {
+				scope = append(scope, policy.KeyValueOperator{
+					Key:      parts[0],
+					Value:    []string{parts[1]},
+					Operator: policy.Equal,
+				})
+				break
+			}
+		}
+	}
+
 	i := 0
 
 	for _, tag := range tags.GetSlice() {
 		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || !defaults.sameLabel(parts[0]) {
+			continue
+		}
 		kv := policy.KeyValueOperator{
 			Key:      parts[0],
 			Value:    []string{parts[1]},
 			Operator: policy.Equal,
 		}
 		tagSelector := policy.TagSelector{
-			Clause: []policy.KeyValueOperator{kv},
+			Clause: append([]policy.KeyValueOperator{kv}, scope...),
 			Policy: &policy.FlowPolicy{
 				Action:   policy.Accept,
 				PolicyID: strconv.Itoa(i),
@@ -183,21 +647,16 @@ func (p *CustomPolicyResolver) createDefaultRules(runtimeInfo policy.RuntimeRead
 		i++
 	}
 
-	// Add a default deny policy that rejects always from "namespace=bad"
-	kv := policy.KeyValueOperator{
-		Key:      "namespace",
-		Value:    []string{"bad"},
-		Operator: policy.Equal,
-	}
-	tagSelector := policy.TagSelector{
-		Clause: []policy.KeyValueOperator{kv},
-		Policy: &policy.FlowPolicy{
-			Action:   policy.Reject,
-			PolicyID: strconv.Itoa(i),
-		},
+	// Add the deny policies, "namespace=bad" unless configured
+	deny := defaults.denySelectors(i)
+	selectorList = append(selectorList, deny...)
+	i += len(deny)
+
+	// With a default-allow stance, accept everything else
+	if allow := defaults.allowSelector(i); allow != nil {
+		selectorList = append(selectorList, *allow)
 	}
 
-	selectorList = append(selectorList, tagSelector)
 	for i, selector := range selectorList {
 		for j, clause := range selector.Clause {
 			zap.L().Info("Trireme policy for container",
@@ -0,0 +1,412 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme-example/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/controller"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// fakeController records the calls of the resolver to the controller. The
+// embedded interface is nil: the other methods must not be called.
+type fakeController struct {
+	controller.TriremeController
+	calls     []string
+	policies  map[string]*policy.PUPolicy
+	updateErr error
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{policies: map[string]*policy.PUPolicy{}}
+}
+
+func (c *fakeController) Enforce(ctx context.Context, puID string, p *policy.PUPolicy, runtime *policy.PURuntime) error {
+	c.calls = append(c.calls, "enforce "+puID)
+	c.policies[puID] = p
+	return nil
+}
+
+func (c *fakeController) UnEnforce(ctx context.Context, puID string, p *policy.PUPolicy, runtime *policy.PURuntime) error {
+	c.calls = append(c.calls, "unenforce "+puID)
+	delete(c.policies, puID)
+	return nil
+}
+
+func (c *fakeController) UpdatePolicy(ctx context.Context, puID string, p *policy.PUPolicy, runtime *policy.PURuntime) error {
+	c.calls = append(c.calls, "update "+puID)
+	if c.updateErr != nil {
+		return c.updateErr
+	}
+	c.policies[puID] = p
+	return nil
+}
+
+// writeTestFile writes a file in a temporary directory and returns its path
+// and a function removing the directory
+func writeTestFile(t *testing.T, name, content string) (string, func()) {
+
+	dir, err := ioutil.TempDir("", "policyexample")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		os.RemoveAll(dir) // nolint
+		t.Fatal(err)
+	}
+
+	return file, func() { os.RemoveAll(dir) } // nolint
+}
+
+// newTestRuntime returns the runtime of a process PU with the given tags
+func newTestRuntime(name string, tags map[string]string) *policy.PURuntime {
+	return policy.NewPURuntime(name, 1, "", policy.NewTagStoreFromMap(tags), policy.ExtendedMap{}, common.LinuxProcessPU, nil)
+}
+
+const testPolicyFile = `{
+	"web": {
+		"NetworkACLs": [
+			{"Address": "10.0.0.0/8", "Port": "80", "Protocol": "tcp", "Policy": {"Action": 1, "PolicyID": "1"}}
+		]
+	},
+	"db": {
+		"NetworkACLs": [
+			{"Address": "10.0.0.0/8", "Port": "5432", "Protocol": "tcp", "Policy": {"Action": 1, "PolicyID": "2"}}
+		]
+	}
+}`
+
+func TestHandlePUEventReleasesWithTrackedPolicy(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		event    common.Event
+		tracked  bool
+		calls    []string
+		enforced bool
+	}{
+		{name: "stop", event: common.EventStop, calls: []string{"enforce pu", "unenforce pu"}},
+		{name: "destroy", event: common.EventDestroy, calls: []string{"enforce pu", "unenforce pu"}},
+		{name: "pause", event: common.EventPause, tracked: true, calls: []string{"enforce pu", "unenforce pu"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file, cleanup := writeTestFile(t, "policy.json", testPolicyFile)
+			defer cleanup()
+
+			ctrl := newFakeController()
+			p, err := NewCustomPolicyResolver(ctrl, nil, file, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runtime := newTestRuntime("web-1", map[string]string{"@usr:PolicyIndex": "web"})
+			if err = p.HandlePUEvent(context.Background(), "pu", common.EventStart, runtime); err != nil {
+				t.Fatal(err)
+			}
+
+			// The policy of the PU disappears from the file
+			if err = ioutil.WriteFile(file, []byte(`{"db": {}}`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = p.Reload(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = p.HandlePUEvent(context.Background(), "pu", tt.event, runtime); err != nil {
+				t.Fatalf("%s failed: %s", tt.event, err)
+			}
+
+			if fmt.Sprint(ctrl.calls) != fmt.Sprint(tt.calls) {
+				t.Errorf("calls = %v, want %v", ctrl.calls, tt.calls)
+			}
+
+			pu, err := p.PU("pu")
+			if tt.tracked != (err == nil) {
+				t.Fatalf("tracked = %t, want %t", err == nil, tt.tracked)
+			}
+			if pu != nil && (pu.Enforced || pu.PolicyIndex != "web") {
+				t.Errorf("PU = %+v, want paused with policy web", pu)
+			}
+		})
+	}
+}
+
+func TestHandlePUEventUntracked(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		event common.Event
+		calls []string
+	}{
+		{name: "stop without policy", event: common.EventStop, calls: []string{"unenforce pu"}},
+		{name: "pause without policy", event: common.EventPause, calls: []string{"unenforce pu"}},
+		{name: "destroy after stop", event: common.EventDestroy, calls: nil},
+		{name: "create", event: common.EventCreate, calls: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file, cleanup := writeTestFile(t, "policy.json", testPolicyFile)
+			defer cleanup()
+
+			ctrl := newFakeController()
+			p, err := NewCustomPolicyResolver(ctrl, nil, file, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runtime := newTestRuntime("gone", map[string]string{"@usr:PolicyIndex": "missing"})
+			if err = p.HandlePUEvent(context.Background(), "pu", tt.event, runtime); err != nil {
+				t.Fatalf("%s failed: %s", tt.event, err)
+			}
+
+			if fmt.Sprint(ctrl.calls) != fmt.Sprint(tt.calls) {
+				t.Errorf("calls = %v, want %v", ctrl.calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestReloadReportsPUErrors(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.json", testPolicyFile)
+	defer cleanup()
+
+	ctrl := newFakeController()
+	p, err := NewCustomPolicyResolver(ctrl, nil, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for puID, index := range map[string]string{"web-pu": "web", "db-pu": "db"} {
+		runtime := newTestRuntime(puID, map[string]string{"@usr:PolicyIndex": index})
+		if err = p.HandlePUEvent(context.Background(), puID, common.EventStart, runtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// web is removed and db is changed
+	if err = ioutil.WriteFile(file, []byte(`{"db": {"NetworkACLs": []}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := p.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].ID != "web-pu" || failed[0].PolicyIndex != "web" {
+		t.Fatalf("failed = %v, want web-pu", failed)
+	}
+	if len(ctrl.calls) != 3 || ctrl.calls[2] != "update db-pu" {
+		t.Errorf("calls = %v, want an update of db-pu", ctrl.calls)
+	}
+	if status := p.Status(); len(status.PUReloadErrors) != 1 {
+		t.Errorf("status reload errors = %v, want web-pu", status.PUReloadErrors)
+	}
+
+	// Updates refused by the controller are reported too
+	ctrl.updateErr = fmt.Errorf("refused")
+	if err = ioutil.WriteFile(file, []byte(testPolicyFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err = p.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].ID != "db-pu" || failed[0].Error != "refused" {
+		t.Errorf("failed = %v, want db-pu refused", failed)
+	}
+}
+
+func TestPUPolicyIsTheEnforcedPolicy(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", `
+web:
+  NetworkACLs:
+    - {Address: 10.0.0.0/8, Port: "22", Protocol: tcp, Policy: {Action: reject, PolicyID: "ssh"}}
+`)
+	defer cleanup()
+
+	ctrl := newFakeController()
+	p, err := NewCustomPolicyResolver(ctrl, []string{"10.0.0.0/8"}, file, false, OptionAudit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtimes := map[string]*policy.PURuntime{
+		"web": newTestRuntime("web", map[string]string{"@usr:PolicyIndex": "web"}),
+		"app": newTestRuntime("app", map[string]string{"app": "shop"}),
+	}
+	for puID, runtime := range runtimes {
+		if err = p.HandlePUEvent(context.Background(), puID, common.EventStart, runtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	web, err := p.PUPolicy("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(web.NetworkACLs) != 4 || web.NetworkACLs[0].Policy.Action != policy.Accept|policy.Log {
+		t.Errorf("network ACLs = %+v, want the reject rule in audit mode", web.NetworkACLs)
+	}
+	if web.Action != "police" || fmt.Sprint(web.TriremeNetworks) != "[10.0.0.0/8]" {
+		t.Errorf("policy = %+v, want the Trireme networks", web)
+	}
+
+	// The default policy of app has the default rules, with the audited deny
+	// rule first and the catch-all rule last
+	app, err := p.PUPolicy("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.PolicyIndex != "default" || len(app.TransmitterRules) != 3 || len(app.ReceiverRules) != 3 {
+		t.Fatalf("policy = %+v, want the default rules", app)
+	}
+	if rule := app.ReceiverRules[0]; rule.Clause[0].Key != "namespace" || rule.Policy.Action != policy.Accept|policy.Log {
+		t.Errorf("first default rule = %+v, want the audited deny rule", rule)
+	}
+	if rule := app.ReceiverRules[1]; rule.Clause[0].Key != "app" || rule.Policy.Action != policy.Accept {
+		t.Errorf("second default rule = %+v, want a rule on the app label", rule)
+	}
+
+	if _, err = p.PUPolicy("unknown"); err == nil {
+		t.Errorf("expected an error for an unknown PU")
+	}
+}
+
+func TestCreateDefaultRulesScope(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		options []Option
+		tags    map[string]string
+		clauses []string
+	}{
+		{
+			name:    "without scope",
+			tags:    map[string]string{"app": "web", "project": "shop"},
+			clauses: []string{"app=web", "project=shop", "namespace=bad"},
+		},
+		{
+			name:    "scoped to the project",
+			options: []Option{OptionDefaultScope("project")},
+			tags:    map[string]string{"app": "web", "project": "shop"},
+			clauses: []string{"app=web,project=shop", "project=shop,project=shop", "namespace=bad"},
+		},
+		{
+			name:    "PU without the scope tag",
+			options: []Option{OptionDefaultScope("project")},
+			tags:    map[string]string{"app": "web"},
+			clauses: []string{"app=web", "namespace=bad"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			p, err := NewCustomPolicyResolver(newFakeController(), nil, "", false, tt.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clauses := []string{}
+			for _, selector := range p.createDefaultRules(newTestRuntime("web", tt.tags)) {
+				kvs := []string{}
+				for _, kv := range selector.Clause {
+					kvs = append(kvs, kv.Key+"="+strings.Join(kv.Value, ","))
+				}
+				clauses = append(clauses, strings.Join(kvs, ","))
+			}
+
+			sort.Strings(clauses)
+			sort.Strings(tt.clauses)
+			if fmt.Sprint(clauses) != fmt.Sprint(tt.clauses) {
+				t.Errorf("clauses = %v, want %v", clauses, tt.clauses)
+			}
+		})
+	}
+}
+
+// metricValue returns the value of a counter or a gauge
+func metricValue(t *testing.T, metric prometheus.Metric) float64 {
+
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Counter != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestResolverMetrics(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.json", testPolicyFile)
+	defer cleanup()
+
+	ctrl := newFakeController()
+	p, err := NewCustomPolicyResolver(ctrl, nil, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enforced := func() float64 { return metricValue(t, metrics.EnforcedPUs.WithLabelValues("process")) }
+
+	events := []struct {
+		puID  string
+		event common.Event
+		want  float64
+	}{
+		{puID: "web-pu", event: common.EventStart, want: 1},
+		{puID: "db-pu", event: common.EventStart, want: 2},
+		{puID: "web-pu", event: common.EventPause, want: 1},
+		{puID: "web-pu", event: common.EventUnpause, want: 2},
+		{puID: "db-pu", event: common.EventStop, want: 1},
+	}
+
+	for _, e := range events {
+		runtime := newTestRuntime(e.puID, map[string]string{"@usr:PolicyIndex": strings.TrimSuffix(e.puID, "-pu")})
+		if err = p.HandlePUEvent(context.Background(), e.puID, e.event, runtime); err != nil {
+			t.Fatal(err)
+		}
+		if count := enforced(); count != e.want {
+			t.Errorf("after %s %s: enforced PUs = %v, want %v", e.event, e.puID, count, e.want)
+		}
+	}
+
+	// The updates refused by the controller are counted
+	updateErrors := metrics.EnforcementErrors.WithLabelValues("update")
+	before := metricValue(t, updateErrors)
+
+	ctrl.updateErr = fmt.Errorf("refused")
+	if err = ioutil.WriteFile(file, []byte(`{"web": {"NetworkACLs": []}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := metricValue(t, updateErrors) - before; count != 1 {
+		t.Errorf("update errors counted = %v, want 1", count)
+	}
+}
@@ -0,0 +1,114 @@
+package policyexample
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.uber.org/zap"
+)
+
+// netclsBasePath is where Trireme creates the net_cls cgroups of the PUs
+const netclsBasePath = "/sys/fs/cgroup/net_cls/trireme"
+
+// Restore reconciles the records of the store with the running system. PUs that
+// are still alive are enforced again and the records of all the others are
+// removed. Containers are skipped, since the Docker monitor restores them.
+func (p *CustomPolicyResolver) Restore(ctx context.Context) error {
+
+	if p.store == nil {
+		return nil
+	}
+
+	records, err := p.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Runtime == nil || record.Runtime.PUType() == common.ContainerPU {
+			p.forget(record.ID)
+			continue
+		}
+
+		if !puAlive(record.ID, record.Runtime.Pid()) {
+			zap.L().Info("Removing state of stopped PU", zap.String("puID", record.ID))
+			p.forget(record.ID)
+			removeCgroup(record.ID)
+			continue
+		}
+
+		if record.Event == common.EventPause {
+			continue
+		}
+
+		zap.L().Info("Restoring policy for PU",
+			zap.String("puID", record.ID),
+			zap.String("name", record.Runtime.Name()),
+		)
+		if err = p.HandlePUEvent(ctx, record.ID, common.EventStart, record.Runtime); err != nil {
+			zap.L().Error("Unable to restore policy for PU", zap.String("puID", record.ID), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// persist stores the record of a PU if the resolver has a store
+func (p *CustomPolicyResolver) persist(record *PURecord) {
+
+	if p.store == nil {
+		return
+	}
+
+	if err := p.store.Put(record); err != nil {
+		zap.L().Warn("Unable to persist state of PU", zap.String("puID", record.ID), zap.Error(err))
+	}
+}
+
+// forget removes the record of a PU if the resolver has a store
+func (p *CustomPolicyResolver) forget(puID string) {
+
+	if p.store == nil {
+		return
+	}
+
+	if err := p.store.Delete(puID); err != nil {
+		zap.L().Warn("Unable to remove state of PU", zap.String("puID", puID), zap.Error(err))
+	}
+}
+
+// puAlive checks if the net_cls cgroup of a PU still holds processes. If the
+// PU has no cgroup, the process itself is checked.
+func puAlive(puID string, pid int) bool {
+
+	procs, err := ioutil.ReadFile(filepath.Join(netclsBasePath, filepath.Base(puID), "cgroup.procs"))
+	if err == nil {
+		return len(strings.TrimSpace(string(procs))) > 0
+	}
+
+	if pid <= 0 {
+		return false
+	}
+
+	_, err = os.Stat(filepath.Join("/proc", strconv.Itoa(pid)))
+
+	return err == nil
+}
+
+// removeCgroup deletes the empty net_cls cgroup left behind by a PU
+func removeCgroup(puID string) {
+
+	path := filepath.Join(netclsBasePath, filepath.Base(puID))
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		zap.L().Warn("Unable to remove cgroup of PU", zap.String("puID", puID), zap.Error(err))
+	}
+}
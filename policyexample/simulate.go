@@ -0,0 +1,417 @@
+package policyexample
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// Decisions of a simulated flow
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// SimulationEndpoint is an end of a simulated flow: either a PU given by its
+// tags or an external IP address
+type SimulationEndpoint struct {
+	Tags []string `json:",omitempty"`
+	IP   string   `json:",omitempty"`
+}
+
+// SimulationStep is the decision of one of the rule lists a flow goes through
+type SimulationStep struct {
+	// PU is "source" or "destination"
+	PU string
+	// Rules is the rule list used, such as "Dependencies" or "ApplicationACLs"
+	Rules    string
+	Decision string
+	// Rule is the matching rule, empty if none matched
+	Rule     string `json:",omitempty"`
+	Index    int
+	PolicyID string `json:",omitempty"`
+	// Audit is true if the rule would reject the flow outside of audit mode
+	Audit bool `json:",omitempty"`
+}
+
+// SimulatedPU is the policy resolved for a PU end of a simulated flow
+type SimulatedPU struct {
+	Tags        []string
+	PolicyIndex string
+	Selection   *PolicySelection
+}
+
+// SimulationResult is the outcome of a simulated flow
+type SimulationResult struct {
+	Source      *SimulatedPU `json:",omitempty"`
+	Destination *SimulatedPU `json:",omitempty"`
+	Port        string       `json:",omitempty"`
+	Protocol    string       `json:",omitempty"`
+	// PortEvaluated is false for flows between PUs: their Dependencies and
+	// ExposureRules apply to all the ports
+	PortEvaluated bool
+	Decision      string
+	Steps         []*SimulationStep
+}
+
+// ParseSimulationEndpoint parses an end of a simulated flow. It is either an
+// IP address or a comma separated list of key=value tags. Keys without a
+// prefix get the @usr: prefix, like the labels of Docker containers.
+func ParseSimulationEndpoint(value string) (*SimulationEndpoint, error) {
+
+	if ip := net.ParseIP(value); ip != nil {
+		return &SimulationEndpoint{IP: ip.String()}, nil
+	}
+
+	tags := []string{}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag %q: must be key=value", tag)
+		}
+		if !strings.HasPrefix(parts[0], "@") {
+			tag = "@usr:" + tag
+		}
+		tags = append(tags, tag)
+	}
+
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("%q is neither an IP address nor a list of tags", value)
+	}
+
+	return &SimulationEndpoint{Tags: tags}, nil
+}
+
+// ParsePortProtocol parses a port and a protocol in the form "port/protocol".
+// The protocol is tcp if missing. ICMP is given as "icmp".
+func ParsePortProtocol(value string) (string, string, error) {
+
+	if strings.EqualFold(value, "icmp") {
+		return "", "icmp", nil
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	protocol := "tcp"
+	if len(parts) == 2 {
+		protocol = strings.ToLower(parts[1])
+	}
+
+	switch protocol {
+	case "tcp", "udp":
+		port, err := strconv.Atoi(parts[0])
+		if err != nil || port < 1 || port > 65535 {
+			return "", "", fmt.Errorf("invalid port %q", parts[0])
+		}
+	case "icmp":
+		if parts[0] != "" {
+			return "", "", fmt.Errorf("ports are not allowed with protocol icmp")
+		}
+	default:
+		return "", "", fmt.Errorf("invalid protocol %q", protocol)
+	}
+
+	return parts[0], protocol, nil
+}
+
+// Simulate evaluates the policies of a policy file for a flow from src to dst
+// on the given port and protocol, without enforcing anything. The policy of
+// every PU end is resolved as the daemon does. A flow between PUs must be
+// accepted by the Dependencies of the source and the ExposureRules of the
+// destination, whatever the port. A flow to an IP address goes through the
+// ApplicationACLs of the source, and a flow from an IP address through the
+// NetworkACLs of the destination: it needs a protocol.
+func Simulate(file string, src, dst *SimulationEndpoint, port, protocol string, opts ...Option) (*SimulationResult, error) {
+
+	if src.IP != "" && dst.IP != "" {
+		return nil, fmt.Errorf("either the source or the destination must be a PU")
+	}
+
+	if (src.IP != "" || dst.IP != "") && protocol == "" {
+		return nil, fmt.Errorf("a port and protocol are needed for a flow with an IP address")
+	}
+
+	p, err := NewCustomPolicyResolver(nil, nil, file, false, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SimulationResult{
+		Port:     port,
+		Protocol: protocol,
+		Decision: DecisionAllow,
+		Steps:    []*SimulationStep{},
+	}
+
+	var srcPolicy, dstPolicy *CachedPolicy
+	if src.IP == "" {
+		result.Source, srcPolicy, err = p.simulatePU(src.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if dst.IP == "" {
+		result.Destination, dstPolicy, err = p.simulatePU(dst.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case src.IP != "":
+		result.PortEvaluated = true
+		result.addStep(matchACLs("destination", "NetworkACLs", *dstPolicy.NetworkACLs, src.IP, port, protocol))
+	case dst.IP != "":
+		result.PortEvaluated = true
+		result.addStep(matchACLs("source", "ApplicationACLs", *srcPolicy.ApplicationACLs, dst.IP, port, protocol))
+	default:
+		result.addStep(matchSelectors("source", "Dependencies", srcPolicy.Dependencies, dst.Tags))
+		result.addStep(matchSelectors("destination", "ExposureRules", dstPolicy.ExposureRules, src.Tags))
+	}
+
+	return result, nil
+}
+
+// simulatePU resolves the policy of a PU given by its tags
+func (p *CustomPolicyResolver) simulatePU(tags []string) (*SimulatedPU, *CachedPolicy, error) {
+
+	runtime := policy.NewPURuntime(strings.Join(tags, ","), 0, "", policy.NewTagStoreFromSlice(tags), policy.ExtendedMap{}, common.ContainerPU, nil)
+
+	p.RLock()
+	defer p.RUnlock()
+
+	policyIndex, cached, selection, err := p.resolve(runtime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &SimulatedPU{
+		Tags:        tags,
+		PolicyIndex: policyIndex,
+		Selection:   selection,
+	}, cached, nil
+}
+
+// addStep records a step. A flow is denied as soon as one step denies it.
+func (r *SimulationResult) addStep(step *SimulationStep) {
+
+	r.Steps = append(r.Steps, step)
+	if step.Decision == DecisionDeny {
+		r.Decision = DecisionDeny
+	}
+}
+
+// matchSelectors evaluates a list of selectors against the tags of the peer.
+// Reject selectors take precedence over accept selectors. Nothing matching is
+// a reject.
+func matchSelectors(pu, rules string, selectors policy.TagSelectorList, peerTags []string) *SimulationStep {
+
+	tags := tagValues(policy.NewTagStoreFromSlice(peerTags))
+
+	var accepted *SimulationStep
+	for i, selector := range selectors {
+		if selector.Policy == nil || !selectorMatches(selector, tags) {
+			continue
+		}
+		step := &SimulationStep{
+			PU:       pu,
+			Rules:    rules,
+			Rule:     describeClauses(selector.Clause),
+			Index:    i,
+			PolicyID: selector.Policy.PolicyID,
+		}
+		if selector.Policy.Action.Rejected() {
+			step.Decision = DecisionDeny
+			return step
+		}
+		if accepted == nil {
+			step.Decision = DecisionAllow
+			accepted = auditStep(step)
+		}
+	}
+
+	if accepted != nil {
+		return accepted
+	}
+
+	return &SimulationStep{PU: pu, Rules: rules, Decision: DecisionDeny, Index: -1}
+}
+
+// matchACLs evaluates a list of ACLs against the address of the peer. The
+// ACLs with the most specific address come first, and reject ACLs take
+// precedence over accept ACLs for the same address. Nothing matching is a
+// reject.
+func matchACLs(pu, rules string, acls policy.IPRuleList, ip, port, protocol string) *SimulationStep {
+
+	type candidate struct {
+		index  int
+		prefix int
+		rule   policy.IPRule
+	}
+
+	candidates := []*candidate{}
+	for i, rule := range acls {
+		prefix, ok := ruleMatches(rule, ip, port, protocol)
+		if !ok || rule.Policy == nil {
+			continue
+		}
+		candidates = append(candidates, &candidate{index: i, prefix: prefix, rule: rule})
+	}
+
+	if len(candidates) == 0 {
+		return &SimulationStep{PU: pu, Rules: rules, Decision: DecisionDeny, Index: -1}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].prefix != candidates[j].prefix {
+			return candidates[i].prefix > candidates[j].prefix
+		}
+		return candidates[i].rule.Policy.Action.Rejected() && !candidates[j].rule.Policy.Action.Rejected()
+	})
+
+	best := candidates[0]
+	step := &SimulationStep{
+		PU:       pu,
+		Rules:    rules,
+		Decision: DecisionAllow,
+		Rule:     fmt.Sprintf("%s %s/%s", best.rule.Address, best.rule.Port, strings.ToLower(best.rule.Protocol)),
+		Index:    best.index,
+		PolicyID: best.rule.Policy.PolicyID,
+	}
+	if best.rule.Policy.Action.Rejected() {
+		step.Decision = DecisionDeny
+		return step
+	}
+
+	return auditStep(step)
+}
+
+// auditStep marks the steps accepted by a reject rule in audit mode
+func auditStep(step *SimulationStep) *SimulationStep {
+
+	if policyID, ok := ParseAuditPolicyID(step.PolicyID); ok {
+		step.PolicyID = policyID
+		step.Audit = true
+	}
+
+	return step
+}
+
+// selectorMatches returns true if all the clauses of a selector match the tags
+func selectorMatches(selector policy.TagSelector, tags map[string][]string) bool {
+
+	for _, clause := range selector.Clause {
+		values, exists := tags[clause.Key]
+
+		switch clause.Operator {
+		case policy.Equal:
+			if !matchesValue(values, clause.Value) {
+				return false
+			}
+		case policy.NotEqual:
+			if matchesValue(values, clause.Value) {
+				return false
+			}
+		case policy.KeyExists:
+			if !exists {
+				return false
+			}
+		case policy.KeyNotExists:
+			if exists {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesValue returns true if one of the values is one of the wanted values.
+// A wanted value ending with "*" matches as a prefix.
+func matchesValue(values, wanted []string) bool {
+
+	for _, value := range values {
+		for _, w := range wanted {
+			if strings.HasSuffix(w, "*") && strings.HasPrefix(value, strings.TrimSuffix(w, "*")) {
+				return true
+			}
+			if value == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ruleMatches returns true and the prefix length of the address of an ACL if
+// it applies to the flow. Any port matches if the port is empty.
+func ruleMatches(rule policy.IPRule, ip, port, protocol string) (int, bool) {
+
+	_, network, err := net.ParseCIDR(rule.Address)
+	if err != nil || !network.Contains(net.ParseIP(ip)) {
+		return 0, false
+	}
+
+	if !strings.EqualFold(rule.Protocol, protocol) {
+		return 0, false
+	}
+
+	if protocol != "icmp" && port != "" && !portInRange(port, rule.Port) {
+		return 0, false
+	}
+
+	prefix, _ := network.Mask.Size()
+
+	return prefix, true
+}
+
+// portInRange returns true if the port is in a port or a port range "min:max"
+func portInRange(port, ports string) bool {
+
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+
+	parts := strings.SplitN(ports, ":", 2)
+	low, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+
+	high := low
+	if len(parts) == 2 {
+		if high, err = strconv.Atoi(parts[1]); err != nil {
+			return false
+		}
+	}
+
+	return n >= low && n <= high
+}
+
+// describeClauses returns a short description of the clauses of a selector
+func describeClauses(clauses []policy.KeyValueOperator) string {
+
+	descriptions := make([]string, len(clauses))
+	for i, clause := range clauses {
+		switch clause.Operator {
+		case policy.KeyExists, policy.KeyNotExists:
+			descriptions[i] = fmt.Sprintf("%s %s", clause.Key, clause.Operator)
+		default:
+			descriptions[i] = fmt.Sprintf("%s%s%s", clause.Key, clause.Operator, strings.Join(clause.Value, "|"))
+		}
+	}
+
+	return strings.Join(descriptions, " and ")
+}
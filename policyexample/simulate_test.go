@@ -0,0 +1,288 @@
+package policyexample
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testSimulationFile = `
+web:
+  Dependencies:
+    - {Clause: [{Key: "@usr:app", Operator: "=", Value: [db]}], Policy: {Action: accept, PolicyID: web-to-db}}
+    - {Clause: [{Key: "@usr:env", Operator: "=", Value: [dev]}], Policy: {Action: reject, PolicyID: no-dev}}
+  ApplicationACLs:
+    - {Address: 0.0.0.0/0, Port: "443", Protocol: tcp, Policy: {Action: accept, PolicyID: https}}
+    - {Address: 10.1.0.0/16, Port: "443", Protocol: tcp, Policy: {Action: reject, PolicyID: no-internal}}
+db:
+  ExposureRules:
+    - {Clause: [{Key: "@usr:app", Operator: "=", Value: [web]}], Policy: {Action: accept, PolicyID: db-from-web}}
+  NetworkACLs:
+    - {Address: 10.0.0.0/8, Port: "5000:6000", Protocol: tcp, Policy: {Action: accept, PolicyID: admin}}
+bindings:
+  - {Policy: web, Match: [{Key: app, Operator: "=", Value: [web]}]}
+  - {Policy: db, Match: [{Key: app, Operator: "=", Value: [db]}]}
+`
+
+// describeSteps returns the steps as "<rules> <decision> <policy ID>" strings
+func describeSteps(steps []*SimulationStep) []string {
+
+	descriptions := []string{}
+	for _, step := range steps {
+		descriptions = append(descriptions, fmt.Sprintf("%s %s %s", step.Rules, step.Decision, step.PolicyID))
+	}
+
+	return descriptions
+}
+
+func TestSimulate(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", testSimulationFile)
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		src       string
+		dst       string
+		port      string
+		decision  string
+		evaluated bool
+		steps     []string
+		err       string
+	}{
+		{
+			name:     "between PUs",
+			src:      "app=web,env=prod",
+			dst:      "app=db",
+			decision: DecisionAllow,
+			steps:    []string{"Dependencies allow web-to-db", "ExposureRules allow db-from-web"},
+		},
+		{
+			name:     "between PUs with a port",
+			src:      "app=web,env=prod",
+			dst:      "app=db",
+			port:     "1/udp",
+			decision: DecisionAllow,
+			steps:    []string{"Dependencies allow web-to-db", "ExposureRules allow db-from-web"},
+		},
+		{
+			name:     "reject selector first",
+			src:      "app=web",
+			dst:      "app=db,env=dev",
+			decision: DecisionDeny,
+			steps:    []string{"Dependencies deny no-dev", "ExposureRules allow db-from-web"},
+		},
+		{
+			name:     "no selector",
+			src:      "app=db",
+			dst:      "app=web",
+			decision: DecisionDeny,
+			steps:    []string{"Dependencies deny ", "ExposureRules deny "},
+		},
+		{
+			name:      "to an IP address",
+			src:       "app=web",
+			dst:       "8.8.8.8",
+			port:      "443/tcp",
+			decision:  DecisionAllow,
+			evaluated: true,
+			steps:     []string{"ApplicationACLs allow https"},
+		},
+		{
+			name:      "most specific address",
+			src:       "app=web",
+			dst:       "10.1.2.3",
+			port:      "443",
+			decision:  DecisionDeny,
+			evaluated: true,
+			steps:     []string{"ApplicationACLs deny no-internal"},
+		},
+		{
+			name:      "port range",
+			src:       "10.2.3.4",
+			dst:       "app=db",
+			port:      "5432/tcp",
+			decision:  DecisionAllow,
+			evaluated: true,
+			steps:     []string{"NetworkACLs allow admin"},
+		},
+		{
+			name:      "port out of the range",
+			src:       "10.2.3.4",
+			dst:       "app=db",
+			port:      "22/tcp",
+			decision:  DecisionDeny,
+			evaluated: true,
+			steps:     []string{"NetworkACLs deny "},
+		},
+		{
+			name: "IP address without port",
+			src:  "10.2.3.4",
+			dst:  "app=db",
+			err:  "a port and protocol are needed",
+		},
+		{
+			name: "two IP addresses",
+			src:  "10.2.3.4",
+			dst:  "8.8.8.8",
+			port: "53/udp",
+			err:  "either the source or the destination must be a PU",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			src, err := ParseSimulationEndpoint(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst, err := ParseSimulationEndpoint(tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			port, protocol := "", ""
+			if tt.port != "" {
+				if port, protocol, err = ParsePortProtocol(tt.port); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := Simulate(file, src, dst, port, protocol)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Decision != tt.decision || result.PortEvaluated != tt.evaluated {
+				t.Errorf("decision = %s (port evaluated %t), want %s (%t)", result.Decision, result.PortEvaluated, tt.decision, tt.evaluated)
+			}
+			if steps := describeSteps(result.Steps); fmt.Sprint(steps) != fmt.Sprint(tt.steps) {
+				t.Errorf("steps = %q, want %q", steps, tt.steps)
+			}
+		})
+	}
+}
+
+func TestSimulateAudit(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.yaml", testSimulationFile)
+	defer cleanup()
+
+	tests := []struct {
+		name  string
+		src   string
+		dst   string
+		steps []string
+	}{
+		{
+			name:  "reject selector",
+			src:   "app=web",
+			dst:   "app=db,env=dev",
+			steps: []string{"Dependencies allow no-dev", "ExposureRules allow db-from-web"},
+		},
+		{
+			// The default deny is audited as well
+			name:  "no selector",
+			src:   "app=db",
+			dst:   "app=web",
+			steps: []string{"Dependencies allow default", "ExposureRules allow default"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			src, _ := ParseSimulationEndpoint(tt.src) // nolint
+			dst, _ := ParseSimulationEndpoint(tt.dst) // nolint
+
+			result, err := Simulate(file, src, dst, "", "", OptionAudit())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Decision != DecisionAllow {
+				t.Errorf("decision = %s, want %s", result.Decision, DecisionAllow)
+			}
+			if steps := describeSteps(result.Steps); fmt.Sprint(steps) != fmt.Sprint(tt.steps) {
+				t.Errorf("steps = %q, want %q", steps, tt.steps)
+			}
+			if step := result.Steps[0]; !step.Audit {
+				t.Errorf("dependency step = %+v, want an audited rule", step)
+			}
+		})
+	}
+}
+
+func TestParseSimulationEndpoint(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{value: "10.0.0.1", want: "IP 10.0.0.1"},
+		{value: "app=web, @sys:image=nginx ,", want: "tags [@usr:app=web @sys:image=nginx]"},
+		{value: "app", err: true},
+		{value: "=web", err: true},
+		{value: " , ", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+
+			end, err := ParseSimulationEndpoint(tt.value)
+			if tt.err != (err != nil) {
+				t.Fatalf("error = %v, want error %t", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			got := fmt.Sprintf("tags %v", end.Tags)
+			if end.IP != "" {
+				got = "IP " + end.IP
+			}
+			if got != tt.want {
+				t.Errorf("endpoint = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePortProtocol(t *testing.T) {
+
+	tests := []struct {
+		value    string
+		port     string
+		protocol string
+		err      bool
+	}{
+		{value: "5432", port: "5432", protocol: "tcp"},
+		{value: "53/UDP", port: "53", protocol: "udp"},
+		{value: "ICMP", protocol: "icmp"},
+		{value: "0/tcp", err: true},
+		{value: "70000", err: true},
+		{value: "80/sctp", err: true},
+		{value: "8/icmp", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+
+			port, protocol, err := ParsePortProtocol(tt.value)
+			if tt.err != (err != nil) {
+				t.Fatalf("error = %v, want error %t", err, tt.err)
+			}
+			if port != tt.port || protocol != tt.protocol {
+				t.Errorf("port = %s/%s, want %s/%s", port, protocol, tt.port, tt.protocol)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package policyexample
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// PUStatus describes a PU handled by the resolver
+type PUStatus struct {
+	ID          string
+	Name        string
+	Type        string
+	Tags        []string
+	IPs         policy.ExtendedMap `json:",omitempty"`
+	PolicyIndex string
+	Selection   *PolicySelection
+	Event       common.Event
+	Enforced    bool
+	Audit       bool
+}
+
+// EnforcedPolicy is the policy enforced for a PU, as passed to the
+// controller. Its rules include the default rules and the changes of the
+// audit mode. The rules the PU applies to the traffic it sends are the
+// TransmitterRules, the ones it applies to the traffic it receives are the
+// ReceiverRules.
+type EnforcedPolicy struct {
+	ID               string
+	PolicyIndex      string
+	Audit            bool
+	Action           string
+	ApplicationACLs  policy.IPRuleList
+	NetworkACLs      policy.IPRuleList
+	TransmitterRules policy.TagSelectorList
+	ReceiverRules    policy.TagSelectorList
+	Identity         []string
+	Annotations      []string
+	IPs              policy.ExtendedMap
+	TriremeNetworks  []string
+	ExcludedNetworks []string
+}
+
+// ResolverStatus describes the state of the resolver
+type ResolverStatus struct {
+	PolicyFile      string
+	Policies        []string
+	TriremeNetworks []string
+	Bindings        []*Binding
+	Audit           bool
+	LastReload      time.Time
+	LastReloadError string
+	// PUReloadErrors are the PUs the last reload could not update
+	PUReloadErrors []*PUReloadError `json:",omitempty"`
+}
+
+// PUs returns the status of all the PUs handled by the resolver sorted by ID
+func (p *CustomPolicyResolver) PUs() []*PUStatus {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	pus := make([]*PUStatus, 0, len(p.pus))
+	for puID, state := range p.pus {
+		pus = append(pus, newPUStatus(puID, state))
+	}
+
+	sort.Slice(pus, func(i, j int) bool {
+		return pus[i].ID < pus[j].ID
+	})
+
+	return pus
+}
+
+// PU returns the status of a PU
+func (p *CustomPolicyResolver) PU(puID string) (*PUStatus, error) {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	state, ok := p.pus[puID]
+	if !ok {
+		return nil, fmt.Errorf("unknown PU %s", puID)
+	}
+
+	return newPUStatus(puID, state), nil
+}
+
+// newPUStatus describes the state of a PU
+func newPUStatus(puID string, state *puState) *PUStatus {
+
+	return &PUStatus{
+		ID:          puID,
+		Name:        state.runtime.Name(),
+		Type:        PUTypeName(state.runtime.PUType()),
+		Tags:        state.runtime.Tags().GetSlice(),
+		IPs:         state.runtime.IPAddresses(),
+		PolicyIndex: state.policyIndex,
+		Selection:   state.selection,
+		Event:       state.event,
+		Enforced:    state.enforced(),
+		Audit:       state.cached.Audit,
+	}
+}
+
+// PUPolicy returns the policy enforced for a PU, as passed to the controller
+func (p *CustomPolicyResolver) PUPolicy(puID string) (*EnforcedPolicy, error) {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	state, ok := p.pus[puID]
+	if !ok {
+		return nil, fmt.Errorf("unknown PU %s", puID)
+	}
+
+	return newEnforcedPolicy(puID, state), nil
+}
+
+// newEnforcedPolicy describes the policy passed to the controller for a PU
+func newEnforcedPolicy(puID string, state *puState) *EnforcedPolicy {
+
+	puPolicy := state.puPolicy
+
+	action := "police"
+	if puPolicy.TriremeAction() == policy.AllowAll {
+		action = "allow-all"
+	}
+
+	return &EnforcedPolicy{
+		ID:               puID,
+		PolicyIndex:      state.policyIndex,
+		Audit:            state.cached.Audit,
+		Action:           action,
+		ApplicationACLs:  puPolicy.ApplicationACLs(),
+		NetworkACLs:      puPolicy.NetworkACLs(),
+		TransmitterRules: puPolicy.TransmitterRules(),
+		ReceiverRules:    puPolicy.ReceiverRules(),
+		Identity:         puPolicy.Identity().GetSlice(),
+		Annotations:      puPolicy.Annotations().GetSlice(),
+		IPs:              puPolicy.IPAddresses(),
+		TriremeNetworks:  puPolicy.TriremeNetworks(),
+		ExcludedNetworks: puPolicy.ExcludedNetworks(),
+	}
+}
+
+// cachedPolicy returns the rules of an enforced policy as a cached policy. The
+// TransmitterRules are the Dependencies and the ReceiverRules the
+// ExposureRules.
+func (e *EnforcedPolicy) cachedPolicy() *CachedPolicy {
+
+	appACLs := e.ApplicationACLs
+	netACLs := e.NetworkACLs
+
+	return &CachedPolicy{
+		Audit:           e.Audit,
+		ApplicationACLs: &appACLs,
+		NetworkACLs:     &netACLs,
+		Dependencies:    e.TransmitterRules,
+		ExposureRules:   e.ReceiverRules,
+	}
+}
+
+// Status returns the status of the resolver
+func (p *CustomPolicyResolver) Status() *ResolverStatus {
+
+	p.RLock()
+	defer p.RUnlock()
+
+	status := &ResolverStatus{
+		PolicyFile:      p.policyFile,
+		Policies:        make([]string, 0, len(p.policies)),
+		TriremeNetworks: p.triremeNets,
+		Bindings:        p.bindings,
+		Audit:           p.audit,
+		LastReload:      p.lastReload,
+		PUReloadErrors:  p.reloadPUErrors,
+	}
+
+	for name := range p.policies {
+		status.Policies = append(status.Policies, name)
+	}
+	sort.Strings(status.Policies)
+
+	if p.reloadErr != nil {
+		status.LastReloadError = p.reloadErr.Error()
+	}
+
+	return status
+}
+
+// PUTypeName returns a short name for the type of a PU
+func PUTypeName(puType common.PUType) string {
+
+	switch puType {
+	case common.ContainerPU:
+		return "container"
+	case common.LinuxProcessPU:
+		return "process"
+	case common.UIDLoginPU:
+		return "uid"
+	default:
+		return "unknown"
+	}
+}
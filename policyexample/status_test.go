@@ -0,0 +1,95 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/common"
+)
+
+func TestPUTypeName(t *testing.T) {
+
+	tests := []struct {
+		puType common.PUType
+		name   string
+	}{
+		{puType: common.ContainerPU, name: "container"},
+		{puType: common.LinuxProcessPU, name: "process"},
+		{puType: common.UIDLoginPU, name: "uid"},
+		{puType: common.PUType(42), name: "unknown"},
+	}
+
+	for _, tt := range tests {
+		if name := PUTypeName(tt.puType); name != tt.name {
+			t.Errorf("PUTypeName(%d) = %s, want %s", tt.puType, name, tt.name)
+		}
+	}
+}
+
+func TestPUs(t *testing.T) {
+
+	file, cleanup := writeTestFile(t, "policy.json", testPolicyFile)
+	defer cleanup()
+
+	p, err := NewCustomPolicyResolver(newFakeController(), []string{"10.0.0.0/8"}, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []struct {
+		puID  string
+		event common.Event
+		tags  map[string]string
+	}{
+		{puID: "c", event: common.EventStart, tags: map[string]string{"@usr:PolicyIndex": "db"}},
+		{puID: "a", event: common.EventStart, tags: map[string]string{"@usr:PolicyIndex": "web"}},
+		{puID: "b", event: common.EventStart, tags: map[string]string{"app": "shop"}},
+		{puID: "c", event: common.EventPause, tags: map[string]string{"@usr:PolicyIndex": "db"}},
+	}
+	for _, e := range events {
+		if err = p.HandlePUEvent(context.Background(), e.puID, e.event, newTestRuntime(e.puID+"-name", e.tags)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id          string
+		policyIndex string
+		source      string
+		event       common.Event
+		enforced    bool
+	}{
+		{id: "a", policyIndex: "web", source: SourceLabel, event: common.EventStart, enforced: true},
+		{id: "b", policyIndex: "default", source: SourceDefault, event: common.EventStart, enforced: true},
+		{id: "c", policyIndex: "db", source: SourceLabel, event: common.EventPause, enforced: false},
+	}
+
+	pus := p.PUs()
+	if len(pus) != len(tests) {
+		t.Fatalf("PUs = %d, want %d", len(pus), len(tests))
+	}
+
+	for i, tt := range tests {
+		pu := pus[i]
+		if pu.ID != tt.id || pu.Name != tt.id+"-name" || pu.Type != "process" {
+			t.Errorf("PU %d = %+v, want %s", i, pu, tt.id)
+			continue
+		}
+		if pu.PolicyIndex != tt.policyIndex || pu.Selection.Source != tt.source || pu.Event != tt.event || pu.Enforced != tt.enforced {
+			t.Errorf("PU %s = %+v, want policy %s from %s, %s", tt.id, pu, tt.policyIndex, tt.source, tt.event)
+		}
+	}
+
+	if _, err = p.PU("unknown"); err == nil {
+		t.Errorf("expected an error for an unknown PU")
+	}
+
+	status := p.Status()
+	if status.PolicyFile != file || fmt.Sprint(status.Policies) != "[db default web]" || fmt.Sprint(status.TriremeNetworks) != "[10.0.0.0/8]" {
+		t.Errorf("status = %+v, want the policies of the file", status)
+	}
+	if status.LastReload.IsZero() || status.LastReloadError != "" {
+		t.Errorf("status = %+v, want a successful load", status)
+	}
+}
@@ -0,0 +1,114 @@
+package policyexample
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultStateFile is the default location of the state of the policy resolver
+const DefaultStateFile = "/var/lib/trireme-example/state.db"
+
+// puBucket is the bucket holding the PU records in the BoltDB file
+var puBucket = []byte("pus")
+
+// PURecord is the state persisted for every PU handled by the resolver
+type PURecord struct {
+	ID          string
+	PolicyIndex string
+	Event       common.Event
+	Runtime     *policy.PURuntime
+}
+
+// Store persists the PUs handled by the resolver across restarts
+type Store interface {
+	// Put creates or updates the record of a PU
+	Put(record *PURecord) error
+	// Delete removes the record of a PU
+	Delete(puID string) error
+	// List returns all the records
+	List() ([]*PURecord, error)
+	// Close releases the store
+	Close() error
+}
+
+// boltStore is a Store backed by a BoltDB file
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates a BoltDB file to persist PU records
+func NewBoltStore(file string) (Store, error) {
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("unable to create state directory: %s", err)
+	}
+
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open state file %s: %s", file, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, berr := tx.CreateBucketIfNotExists(puBucket)
+		return berr
+	})
+	if err != nil {
+		db.Close() // nolint
+		return nil, fmt.Errorf("unable to initialize state file %s: %s", file, err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+// Put implements the Store interface
+func (s *boltStore) Put(record *PURecord) error {
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(puBucket).Put([]byte(record.ID), data)
+	})
+}
+
+// Delete implements the Store interface
+func (s *boltStore) Delete(puID string) error {
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(puBucket).Delete([]byte(puID))
+	})
+}
+
+// List implements the Store interface
+func (s *boltStore) List() ([]*PURecord, error) {
+
+	records := []*PURecord{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(puBucket).ForEach(func(k, v []byte) error {
+			record := &PURecord{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return fmt.Errorf("invalid record for %s: %s", string(k), err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+// Close implements the Store interface
+func (s *boltStore) Close() error {
+
+	return s.db.Close()
+}
@@ -0,0 +1,167 @@
+package policyexample
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"go.aporeto.io/trireme-lib/common"
+	"go.aporeto.io/trireme-lib/policy"
+)
+
+// newTestStore opens a BoltDB store in a temporary directory and returns it
+// with a function closing and removing it
+func newTestStore(t *testing.T) (Store, func()) {
+
+	dir, err := ioutil.TempDir("", "policyexample")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewBoltStore(filepath.Join(dir, "state", "state.db"))
+	if err != nil {
+		os.RemoveAll(dir) // nolint
+		t.Fatal(err)
+	}
+
+	return store, func() {
+		store.Close()     // nolint
+		os.RemoveAll(dir) // nolint
+	}
+}
+
+// storedIDs returns the sorted IDs of the records of a store
+func storedIDs(t *testing.T, store Store) []string {
+
+	records, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func TestBoltStore(t *testing.T) {
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	runtime := newTestRuntime("web-1", map[string]string{"app": "web"})
+
+	for _, record := range []*PURecord{
+		{ID: "a", PolicyIndex: "web", Event: common.EventStart, Runtime: runtime},
+		{ID: "b", PolicyIndex: "db", Event: common.EventStart, Runtime: runtime},
+		{ID: "a", PolicyIndex: "web", Event: common.EventPause, Runtime: runtime},
+	} {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+
+	record := records[0]
+	if record.ID != "a" || record.PolicyIndex != "web" || record.Event != common.EventPause {
+		t.Errorf("record = %+v, want a paused with policy web", record)
+	}
+	if record.Runtime == nil || record.Runtime.Name() != "web-1" || record.Runtime.PUType() != common.LinuxProcessPU {
+		t.Errorf("runtime = %+v, want the process web-1", record.Runtime)
+	}
+}
+
+func TestResolverPersistsPUs(t *testing.T) {
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	p, err := NewCustomPolicyResolver(newFakeController(), nil, "", false, OptionStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []struct {
+		puID  string
+		event common.Event
+		want  []string
+	}{
+		{puID: "a", event: common.EventStart, want: []string{"a"}},
+		{puID: "b", event: common.EventStart, want: []string{"a", "b"}},
+		{puID: "a", event: common.EventPause, want: []string{"a", "b"}},
+		{puID: "b", event: common.EventStop, want: []string{"a"}},
+		{puID: "a", event: common.EventDestroy, want: []string{}},
+	}
+
+	for _, e := range events {
+		if err = p.HandlePUEvent(context.Background(), e.puID, e.event, newTestRuntime(e.puID, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if ids := storedIDs(t, store); fmt.Sprint(ids) != fmt.Sprint(e.want) {
+			t.Errorf("after %s %s: records = %v, want %v", e.event, e.puID, ids, e.want)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	alive := os.Getpid()
+	records := []*PURecord{
+		{ID: "running", Event: common.EventStart, Runtime: newTestRuntimePid("running", alive, common.LinuxProcessPU)},
+		{ID: "paused", Event: common.EventPause, Runtime: newTestRuntimePid("paused", alive, common.LinuxProcessPU)},
+		{ID: "dead", Event: common.EventStart, Runtime: newTestRuntimePid("dead", 0, common.LinuxProcessPU)},
+		{ID: "container", Event: common.EventStart, Runtime: newTestRuntimePid("container", alive, common.ContainerPU)},
+		{ID: "broken", Event: common.EventStart},
+	}
+	for _, record := range records {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctrl := newFakeController()
+	p, err := NewCustomPolicyResolver(ctrl, nil, "", false, OptionStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = p.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"enforce running"}; fmt.Sprint(ctrl.calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want %v", ctrl.calls, want)
+	}
+	if want := []string{"paused", "running"}; fmt.Sprint(storedIDs(t, store)) != fmt.Sprint(want) {
+		t.Errorf("records = %v, want %v", storedIDs(t, store), want)
+	}
+}
+
+// newTestRuntimePid returns the runtime of a PU of the given type and PID
+func newTestRuntimePid(name string, pid int, puType common.PUType) *policy.PURuntime {
+	return policy.NewPURuntime(name, pid, "", policy.NewTagStore(), policy.ExtendedMap{}, puType, nil)
+}
@@ -0,0 +1,463 @@
+package policyexample
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"go.aporeto.io/trireme-lib/policy"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// validActions is the set of all action bits a flow policy can use
+const validActions = policy.Accept | policy.Reject | policy.Encrypt | policy.Log
+
+// PolicyError is a single error found in a policy file
+type PolicyError struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+// Error implements the error interface
+func (e *PolicyError) Error() string {
+
+	location := e.File
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, e.Line)
+	}
+
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", location, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, e.Path, e.Message)
+}
+
+// ValidationErrors is the list of all errors found in a policy file
+type ValidationErrors []*PolicyError
+
+// Error implements the error interface
+func (v ValidationErrors) Error() string {
+
+	errs := make([]string, len(v))
+	for i, e := range v {
+		errs[i] = e.Error()
+	}
+
+	return strings.Join(errs, "\n")
+}
+
+// validator collects the errors found while checking a set of policies
+type validator struct {
+	file   string
+	lines  map[string]int
+	errors ValidationErrors
+}
+
+// ValidatePolicies checks that a policy file can be loaded and returns all
+// the errors found in it as ValidationErrors.
+func ValidatePolicies(file string) error {
+
+	_, err := LoadPolicies(file)
+
+	return err
+}
+
+// yamlErrorLine and tomlErrorLine find the line in the errors of the YAML and
+// TOML parsers
+var (
+	yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+	tomlErrorLine = regexp.MustCompile(`^\((\d+), \d+\)`)
+)
+
+// newValidator creates a validator for a policy file. The raw data of the file
+// is used to report the line of every error.
+func newValidator(file string, data []byte) *validator {
+
+	var lines map[string]int
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		lines = yamlLines(data)
+	case ".toml":
+		lines = tomlLines(data)
+	default:
+		lines = jsonLines(data)
+	}
+
+	return &validator{
+		file:  file,
+		lines: lines,
+	}
+}
+
+// validate checks the content of a decoded policy file and returns all the
+// errors found so far
+func (v *validator) validate(config map[string]*CachedPolicy) error {
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cached := config[name]
+		if cached == nil {
+			v.errorf(name, "policy is empty")
+			continue
+		}
+		if cached.ApplicationACLs != nil {
+			v.checkRules(name+".ApplicationACLs", *cached.ApplicationACLs)
+		}
+		if cached.NetworkACLs != nil {
+			v.checkRules(name+".NetworkACLs", *cached.NetworkACLs)
+		}
+		v.checkSelectors(name+".Dependencies", cached.Dependencies)
+		v.checkSelectors(name+".ExposureRules", cached.ExposureRules)
+	}
+
+	return v.err()
+}
+
+// err returns the errors found so far or nil
+func (v *validator) err() error {
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	return nil
+}
+
+// decodeError converts an error of the JSON decoder into a validation error
+func decodeError(file string, data []byte, err error) error {
+
+	offset := int64(-1)
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	}
+
+	return ValidationErrors{
+		&PolicyError{
+			File:    file,
+			Line:    offsetLine(data, offset),
+			Message: err.Error(),
+		},
+	}
+}
+
+// parserError converts an error of the YAML or TOML parser into a validation
+// error, at the line found in its message with pattern
+func parserError(file string, err error, pattern *regexp.Regexp) error {
+
+	line := 0
+	if match := pattern.FindStringSubmatch(err.Error()); match != nil {
+		line, _ = strconv.Atoi(match[1]) // nolint
+	}
+
+	return ValidationErrors{
+		&PolicyError{
+			File:    file,
+			Line:    line,
+			Message: err.Error(),
+		},
+	}
+}
+
+// errorf records an error for the value at the given path. Missing values
+// are reported at the line of their closest parent.
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+
+	line, ok := v.lines[path]
+	for parent := path; !ok && parent != ""; {
+		i := strings.LastIndexAny(parent, ".[")
+		if i < 0 {
+			break
+		}
+		parent = parent[:i]
+		line, ok = v.lines[parent]
+	}
+
+	v.errors = append(v.errors, &PolicyError{
+		File:    v.file,
+		Line:    line,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkRules validates a list of ACLs
+func (v *validator) checkRules(path string, rules policy.IPRuleList) {
+
+	for i, rule := range rules {
+		rulePath := fmt.Sprintf("%s[%d]", path, i)
+
+		if _, _, err := net.ParseCIDR(rule.Address); err != nil {
+			v.errorf(rulePath+".Address", "invalid CIDR %q", rule.Address)
+		}
+
+		protocol := strings.ToLower(rule.Protocol)
+		switch protocol {
+		case "tcp", "udp":
+			if err := checkPorts(rule.Port); err != nil {
+				v.errorf(rulePath+".Port", "%s", err)
+			}
+		case "icmp":
+			if rule.Port != "" {
+				v.errorf(rulePath+".Port", "ports are not allowed with protocol icmp")
+			}
+		default:
+			if n, err := strconv.Atoi(protocol); err != nil || n < 0 || n > 255 {
+				v.errorf(rulePath+".Protocol", "invalid protocol %q", rule.Protocol)
+			}
+		}
+
+		v.checkFlowPolicy(rulePath+".Policy", rule.Policy)
+	}
+}
+
+// checkSelectors validates a list of tag selectors
+func (v *validator) checkSelectors(path string, selectors policy.TagSelectorList) {
+
+	for i, selector := range selectors {
+		selectorPath := fmt.Sprintf("%s[%d]", path, i)
+
+		v.checkClauses(selectorPath, selector.Clause)
+		v.checkFlowPolicy(selectorPath+".Policy", selector.Policy)
+	}
+}
+
+// checkClauses validates the clauses of a selector
+func (v *validator) checkClauses(selectorPath string, clauses []policy.KeyValueOperator) {
+
+	if len(clauses) == 0 {
+		v.errorf(selectorPath+".Clause", "a selector needs at least one clause")
+	}
+
+	for j, clause := range clauses {
+		clausePath := fmt.Sprintf("%s.Clause[%d]", selectorPath, j)
+
+		if clause.Key == "" {
+			v.errorf(clausePath+".Key", "key is empty")
+		}
+
+		switch clause.Operator {
+		case policy.Equal, policy.NotEqual:
+			if len(clause.Value) == 0 {
+				v.errorf(clausePath+".Value", "operator %q needs at least one value", clause.Operator)
+			}
+		case policy.KeyExists, policy.KeyNotExists:
+		default:
+			v.errorf(clausePath+".Operator", "invalid operator %q", clause.Operator)
+		}
+	}
+}
+
+// checkFlowPolicy validates the action of a rule
+func (v *validator) checkFlowPolicy(path string, flowPolicy *policy.FlowPolicy) {
+
+	if flowPolicy == nil {
+		v.errorf(path, "policy is missing")
+		return
+	}
+
+	action := flowPolicy.Action
+	if action&^validActions != 0 {
+		v.errorf(path+".Action", "invalid action %d", action)
+		return
+	}
+
+	if action.Accepted() == action.Rejected() {
+		v.errorf(path+".Action", "action %d must either accept or reject", action)
+	}
+}
+
+// checkPorts validates a port or a port range in the form "min:max"
+func checkPorts(ports string) error {
+
+	if ports == "" {
+		return fmt.Errorf("port is missing")
+	}
+
+	parts := strings.SplitN(ports, ":", 2)
+
+	low, err := strconv.Atoi(parts[0])
+	if err != nil || low < 1 || low > 65535 {
+		return fmt.Errorf("invalid port %q", ports)
+	}
+
+	if len(parts) == 1 {
+		return nil
+	}
+
+	high, err := strconv.Atoi(parts[1])
+	if err != nil || high < low || high > 65535 {
+		return fmt.Errorf("invalid port range %q", ports)
+	}
+
+	return nil
+}
+
+// jsonLines returns the line where every value of a JSON document starts, keyed
+// by the path used in validation errors. A malformed document returns the lines
+// found up to the first error.
+func jsonLines(data []byte) map[string]int {
+
+	lines := map[string]int{}
+	jsonValueLines(json.NewDecoder(bytes.NewReader(data)), data, "", lines) // nolint
+
+	return lines
+}
+
+// jsonValueLines records the line of the next value of the decoder and of the
+// values it contains
+func jsonValueLines(dec *json.Decoder, data []byte, path string, lines map[string]int) error {
+
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	// Tokens never span lines, so the line of their end is the line of their start
+	if path != "" {
+		lines[path] = offsetLine(data, dec.InputOffset())
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	for i := 0; dec.More(); i++ {
+		child := fmt.Sprintf("%s[%d]", path, i)
+		if delim == '{' {
+			key, kerr := dec.Token()
+			if kerr != nil {
+				return kerr
+			}
+			child = fmt.Sprint(key)
+			if path != "" {
+				child = path + "." + child
+			}
+		}
+		if err = jsonValueLines(dec, data, child, lines); err != nil {
+			return err
+		}
+	}
+
+	// The closing delimiter
+	_, err = dec.Token()
+
+	return err
+}
+
+// yamlLines returns the line of every value of a YAML document by path. The
+// values of a map are reported at the line of their key.
+func yamlLines(data []byte) map[string]int {
+
+	lines := map[string]int{}
+
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return lines
+	}
+	yamlNodeLines(root.Content[0], "", lines)
+
+	return lines
+}
+
+// yamlNodeLines records the line of the values a YAML node contains
+func yamlNodeLines(node *yamlv3.Node, path string, lines map[string]int) {
+
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := key.Value
+			if path != "" {
+				child = path + "." + child
+			}
+			lines[child] = key.Line
+			yamlNodeLines(value, child, lines)
+		}
+	case yamlv3.SequenceNode:
+		for i, item := range node.Content {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			lines[child] = item.Line
+			yamlNodeLines(item, child, lines)
+		}
+	}
+}
+
+// tomlLines returns the line of every value of a TOML document by path
+func tomlLines(data []byte) map[string]int {
+
+	lines := map[string]int{}
+
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return lines
+	}
+	tomlTreeLines(tree, "", lines)
+
+	return lines
+}
+
+// tomlTreeLines records the line of the values a TOML table contains
+func tomlTreeLines(tree *toml.Tree, path string, lines map[string]int) {
+
+	for _, key := range tree.Keys() {
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		if position := tree.GetPositionPath([]string{key}); !position.Invalid() {
+			lines[child] = position.Line
+		}
+		tomlValueLines(tree.GetPath([]string{key}), child, lines)
+	}
+}
+
+// tomlValueLines records the line of the tables a TOML value contains
+func tomlValueLines(value interface{}, path string, lines map[string]int) {
+
+	switch v := value.(type) {
+	case *toml.Tree:
+		tomlTreeLines(v, path, lines)
+	case []*toml.Tree:
+		for i, item := range v {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			if position := item.Position(); !position.Invalid() {
+				lines[child] = position.Line
+			}
+			tomlTreeLines(item, child, lines)
+		}
+	case []interface{}:
+		for i, item := range v {
+			tomlValueLines(item, fmt.Sprintf("%s[%d]", path, i), lines)
+		}
+	}
+}
+
+// offsetLine returns the line of a byte offset of data, or 0 if the offset is
+// out of range
+func offsetLine(data []byte, offset int64) int {
+
+	if offset < 0 || offset > int64(len(data)) {
+		return 0
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,353 @@
+package policyexample
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePolicies(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		errors  []string
+	}{
+		{
+			name: "valid",
+			file: "policy.json",
+			content: `{
+  "web": {
+    "ApplicationACLs": [
+      {"Address": "10.0.0.0/8", "Port": "80:90", "Protocol": "tcp", "Policy": {"Action": 9}}
+    ]
+  }
+}`,
+		},
+		{
+			name: "unknown action",
+			file: "policy.json",
+			content: `{
+  "web": {
+    "NetworkACLs": [
+      {
+        "Address": "10.0.0.0/8",
+        "Port": "80",
+        "Protocol": "tcp",
+        "Policy": {"Action": "allow"}
+      }
+    ]
+  }
+}`,
+			errors: []string{`policy.json:8: web.NetworkACLs[0].Policy.Action: invalid action "allow"`},
+		},
+		{
+			name: "bad CIDR",
+			file: "policy.json",
+			content: `{
+  "web": {
+    "ApplicationACLs": [
+      {"Address": "10.0.0.0/8", "Port": "80", "Protocol": "tcp", "Policy": {"Action": 1}},
+      {
+        "Address": "10.0.0.300/8",
+        "Port": "80",
+        "Protocol": "tcp",
+        "Policy": {"Action": 1}
+      }
+    ]
+  }
+}`,
+			errors: []string{`policy.json:6: web.ApplicationACLs[1].Address: invalid CIDR "10.0.0.300/8"`},
+		},
+		{
+			name: "bad port range",
+			file: "policy.json",
+			content: `{
+  "web": {
+    "ApplicationACLs": [
+      {
+        "Address": "10.0.0.0/8",
+        "Port": "90:80",
+        "Protocol": "udp",
+        "Policy": {"Action": 1}
+      }
+    ]
+  }
+}`,
+			errors: []string{`policy.json:6: web.ApplicationACLs[0].Port: invalid port range "90:80"`},
+		},
+		{
+			name: "empty clause",
+			file: "policy.json",
+			content: `{
+  "web": {
+    "Dependencies": [
+      {
+        "Clause": [],
+        "Policy": {"Action": 1}
+      }
+    ]
+  }
+}`,
+			errors: []string{`policy.json:5: web.Dependencies[0].Clause: a selector needs at least one clause`},
+		},
+		{
+			name: "unknown group",
+			file: "policy.json",
+			content: `{
+  "groups": {
+    "dns": {}
+  },
+  "web": {
+    "Groups": [
+      "dns",
+      "ntp"
+    ]
+  }
+}`,
+			errors: []string{`policy.json:8: web.Groups[1]: unknown group "ntp"`},
+		},
+		{
+			name: "unknown binding policy",
+			file: "policy.json",
+			content: `{
+  "web": {},
+  "bindings": [
+    {
+      "Policy": "db",
+      "Match": [{"Key": "app", "Operator": "=", "Value": ["db"]}]
+    }
+  ]
+}`,
+			errors: []string{`policy.json:5: bindings[0].Policy: unknown policy "db"`},
+		},
+		{
+			name: "missing value reported at its parent",
+			file: "policy.json",
+			content: `{
+  "web": {
+    "ApplicationACLs": [
+      {"Address": "10.0.0.0/8", "Protocol": "tcp", "Policy": {"Action": 1}}
+    ]
+  }
+}`,
+			errors: []string{`policy.json:4: web.ApplicationACLs[0].Port: port is missing`},
+		},
+		{
+			name: "several errors",
+			file: "policy.json",
+			content: `{
+  "db": {
+    "NetworkACLs": [
+      {"Address": "any", "Port": "0", "Protocol": "tcp", "Policy": {"Action": 1}}
+    ]
+  },
+  "web": {
+    "ExposureRules": [
+      {"Clause": [{"Key": "", "Operator": "=", "Value": ["x"]}], "Policy": {"Action": 3}}
+    ]
+  }
+}`,
+			errors: []string{
+				`policy.json:4: db.NetworkACLs[0].Address: invalid CIDR "any"`,
+				`policy.json:4: db.NetworkACLs[0].Port: invalid port "0"`,
+				`policy.json:9: web.ExposureRules[0].Clause[0].Key: key is empty`,
+				`policy.json:9: web.ExposureRules[0].Policy.Action: action 3 must either accept or reject`,
+			},
+		},
+		{
+			name:    "syntax error",
+			file:    "policy.json",
+			content: "{\n  \"web\": {\n    \"NetworkACLs\": [,]\n  }\n}",
+			errors:  []string{`policy.json:3: invalid character ',' looking for beginning of value`},
+		},
+		{
+			name: "YAML",
+			file: "policy.yaml",
+			content: `web:
+  NetworkACLs:
+    - Address: 10.0.0.0/8
+      Port: "80"
+      Protocol: sctp
+      Policy:
+        Action: accept
+    - {Address: 10.0.0.0/8, Port: "80", Protocol: tcp, Policy: {Action: allow}}
+`,
+			errors: []string{
+				`policy.yaml:8: web.NetworkACLs[1].Policy.Action: invalid action "allow"`,
+			},
+		},
+		{
+			name: "YAML rules",
+			file: "policy.yaml",
+			content: `web:
+  NetworkACLs:
+    - Address: 10.0.0.0/8
+      Port: "80"
+      Protocol: sctp
+      Policy:
+        Action: accept
+`,
+			errors: []string{`policy.yaml:5: web.NetworkACLs[0].Protocol: invalid protocol "sctp"`},
+		},
+		{
+			name:    "YAML syntax error",
+			file:    "policy.yml",
+			content: "web:\n  NetworkACLs: [\n  - a\n",
+			errors:  []string{`policy.yml:2: yaml: line 2: did not find expected node content`},
+		},
+		{
+			name: "TOML",
+			file: "policy.toml",
+			content: `[web]
+
+[[web.ApplicationACLs]]
+Address = "10.0.0.0/8"
+Port = "80"
+Protocol = "tcp"
+Policy = { Action = "accept" }
+
+[[web.ApplicationACLs]]
+Address = "10.0.0.0/8"
+Port = "90:80"
+Protocol = "tcp"
+Policy = { Action = "accept" }
+`,
+			errors: []string{`policy.toml:11: web.ApplicationACLs[1].Port: invalid port range "90:80"`},
+		},
+		{
+			name:    "TOML syntax error",
+			file:    "policy.toml",
+			content: "[web]\n\n[[web.ApplicationACLs]\n",
+			errors:  []string{`policy.toml:3: (3, 22): was expecting token [[, but got unclosed table array key instead`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file, cleanup := writeTestFile(t, tt.file, tt.content)
+			defer cleanup()
+
+			err := ValidatePolicies(file)
+			if len(tt.errors) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			errs, ok := err.(ValidationErrors)
+			if !ok {
+				t.Fatalf("error = %v, want ValidationErrors", err)
+			}
+
+			// The errors are reported with the full path of the file
+			got := strings.Replace(errs.Error(), file, tt.file, -1)
+			if want := strings.Join(tt.errors, "\n"); got != want {
+				t.Errorf("errors =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+
+	data := []byte(`{
+  "a": {
+    "b": [
+      1,
+      {"c": "x"}
+    ]
+  },
+  "d": "y"
+}`)
+
+	want := map[string]int{
+		"a":        2,
+		"a.b":      3,
+		"a.b[0]":   4,
+		"a.b[1]":   5,
+		"a.b[1].c": 5,
+		"d":        8,
+	}
+
+	lines := jsonLines(data)
+	if len(lines) != len(want) {
+		t.Errorf("lines = %v, want %v", lines, want)
+	}
+	for path, line := range want {
+		if lines[path] != line {
+			t.Errorf("line of %s = %d, want %d", path, lines[path], line)
+		}
+	}
+}
+
+func TestYAMLAndTOMLLines(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		lines func([]byte) map[string]int
+		data  string
+		want  map[string]int
+	}{
+		{
+			name:  "YAML",
+			lines: yamlLines,
+			data: `a:
+  b:
+    - 1
+    - {c: x}
+d: y
+`,
+			want: map[string]int{
+				"a":        1,
+				"a.b":      2,
+				"a.b[0]":   3,
+				"a.b[1]":   4,
+				"a.b[1].c": 4,
+				"d":        5,
+			},
+		},
+		{
+			name:  "TOML",
+			lines: tomlLines,
+			data: `d = "y"
+
+[a]
+e = 1
+
+[[a.b]]
+c = "x"
+
+[[a.b]]
+c = "z"
+`,
+			want: map[string]int{
+				"d":        1,
+				"a":        3,
+				"a.e":      4,
+				"a.b":      9,
+				"a.b[0]":   6,
+				"a.b[0].c": 7,
+				"a.b[1]":   9,
+				"a.b[1].c": 10,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			lines := tt.lines([]byte(tt.data))
+			if len(lines) != len(tt.want) {
+				t.Errorf("lines = %v, want %v", lines, tt.want)
+			}
+			for path, line := range tt.want {
+				if lines[path] != line {
+					t.Errorf("line of %s = %d, want %d", path, lines[path], line)
+				}
+			}
+		})
+	}
+}
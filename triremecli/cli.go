@@ -2,22 +2,33 @@ package triremecli
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/docker/docker/api/types"
 	"go.uber.org/zap"
 
+	"github.com/aporeto-inc/trireme-example/collectors"
 	"github.com/aporeto-inc/trireme-example/configuration"
 	"github.com/aporeto-inc/trireme-example/extractors"
+	"github.com/aporeto-inc/trireme-example/management"
+	"github.com/aporeto-inc/trireme-example/metrics"
 	"github.com/aporeto-inc/trireme-example/policyexample"
 	"github.com/aporeto-inc/trireme-example/utils"
+	"github.com/aporeto-inc/trireme-example/versions"
 
 	"go.aporeto.io/trireme-lib/cmd/systemdutil"
 	"go.aporeto.io/trireme-lib/collector"
 	"go.aporeto.io/trireme-lib/controller"
 	"go.aporeto.io/trireme-lib/controller/pkg/secrets"
 	"go.aporeto.io/trireme-lib/monitor"
+	"go.aporeto.io/trireme-lib/policy"
 )
 
 // KillContainerOnError defines if the Container is getting killed if the policy Application resulted in an error
@@ -57,6 +68,10 @@ func ProcessRun(config *configuration.Configuration) (err error) {
 // ProcessDaemon is called when trireme-example is called to start the daemon
 func ProcessDaemon(config *configuration.Configuration) (err error) {
 
+	if err = validateEnforcement(config); err != nil {
+		zap.L().Fatal("Invalid enforcement configuration", zap.Error(err))
+	}
+
 	// Setting up Secret Auth type based on user config.
 	var triremesecret secrets.Secrets
 	if config.Auth == configuration.PSK {
@@ -72,12 +87,63 @@ func ProcessDaemon(config *configuration.Configuration) (err error) {
 		zap.L().Fatal("No Authentication option given")
 	}
 
+	// Write the events to the flow log, if any
 	collectorInstance := collector.NewDefaultCollector()
+	var flowLog *collectors.FlowLogCollector
+	if config.FlowLogFile != "" {
+		file, ferr := collectors.NewRotatingFile(config.FlowLogFile, int64(config.FlowLogMaxSize)*1024*1024, config.FlowLogMaxAge, config.FlowLogMaxBackups)
+		if ferr != nil {
+			zap.L().Fatal("Unable to open flow log", zap.Error(ferr))
+		}
+		defer file.Close() // nolint
+		flowLog = collectors.NewFlowLogCollector(file, collectorInstance)
+		collectorInstance = flowLog
+	}
+
+	// Send the flows to syslog, if configured
+	var syslogSink *collectors.SyslogCollector
+	if config.SyslogAddress != "" {
+		writer, serr := newSyslogWriter(config)
+		if serr != nil {
+			zap.L().Fatal("Unable to connect to syslog", zap.Error(serr))
+		}
+		defer writer.Close() // nolint
+		syslogSink, serr = collectors.NewSyslogCollector(writer, config.SyslogFormat, config.SyslogRejectsOnly, collectorInstance)
+		if serr != nil {
+			zap.L().Fatal("Invalid syslog configuration", zap.Error(serr))
+		}
+		collectorInstance = syslogSink
+	}
+
+	// Export the flows to IPFIX, if configured
+	var ipfix *collectors.IPFIXCollector
+	if config.IPFIXAddress != "" {
+		exporter, ierr := collectors.NewIPFIXExporter(config.IPFIXAddress, config.IPFIXObservationDomain, config.IPFIXTemplateRefresh)
+		if ierr != nil {
+			zap.L().Fatal("Unable to connect to IPFIX collector", zap.Error(ierr))
+		}
+		defer exporter.Close() // nolint
+		ipfix = collectors.NewIPFIXCollector(exporter, collectorInstance)
+		collectorInstance = ipfix
+	}
+
+	// Aggregate the flows for the top flows of the management API
+	aggregator, aerr := collectors.NewFlowAggregator(config.FlowRetention, collectorInstance)
+	if aerr != nil {
+		zap.L().Fatal("Invalid flow aggregation configuration", zap.Error(aerr))
+	}
+	collectorInstance = aggregator
+
+	// Count the flows for the metrics
+	metricsCollector := collectors.NewMetricsCollector(collectorInstance)
+	collectorInstance = metricsCollector
+
+	// Report the flows accepted by the policies in audit mode
+	collectorInstance = collectors.NewAuditCollector(collectorInstance)
 
 	controllerOptions := []controller.Option{
 		controller.OptionSecret(triremesecret),
 		controller.OptionCollector(collectorInstance),
-		controller.OptionEnforceLinuxProcess(),
 		controller.OptionTargetNetworks(config.ParsedTriremeNetworks),
 		controller.OptionProcMountPoint("/proc"),
 	}
@@ -85,18 +151,32 @@ func ProcessDaemon(config *configuration.Configuration) (err error) {
 		controllerOptions = append(controllerOptions, controller.OptionPacketLogs())
 	}
 
-	// Docker options
-	dockerOptions := []monitor.DockerMonitorOption{}
-	if config.SwarmMode {
-		dockerOptions = append(dockerOptions, monitor.SubOptionMonitorDockerExtractor(extractors.SwarmExtractor))
-	}
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// Setting up extractor and monitor
 	monitorOptions := []monitor.Options{
-		monitor.OptionMonitorLinuxProcess(),
 		monitor.OptionCollector(collectorInstance),
-		monitor.OptionMonitorDocker(dockerOptions...),
-		monitor.OptionMonitorUID(),
+	}
+
+	if config.DockerEnforcement {
+		// Docker options
+		dockerOptions := []monitor.DockerMonitorOption{}
+		extractor, eerr := dockerExtractor(ctx, config)
+		if eerr != nil {
+			zap.L().Fatal("Unable to initialize metadata extractor", zap.Error(eerr))
+		}
+		if extractor != nil {
+			dockerOptions = append(dockerOptions, monitor.SubOptionMonitorDockerExtractor(extractor))
+		}
+		monitorOptions = append(monitorOptions, monitor.OptionMonitorDocker(dockerOptions...))
+	}
+
+	if config.LinuxProcessesEnforcement {
+		controllerOptions = append(controllerOptions, controller.OptionEnforceLinuxProcess())
+		monitorOptions = append(monitorOptions,
+			monitor.OptionMonitorLinuxProcess(),
+			monitor.OptionMonitorUID(),
+		)
 	}
 
 	// Initialize the controllers
@@ -106,7 +186,45 @@ func ProcessDaemon(config *configuration.Configuration) (err error) {
 	}
 
 	// Initialize the policy resolver
-	policyEngine := policyexample.NewCustomPolicyResolver(ctrl, config.ParsedTriremeNetworks, config.PolicyFile)
+	policyOptions := []policyexample.Option{}
+	if config.StateFile != "" {
+		store, serr := policyexample.NewBoltStore(config.StateFile)
+		if serr != nil {
+			zap.L().Fatal("Unable to open state file", zap.Error(serr))
+		}
+		defer store.Close() // nolint
+		policyOptions = append(policyOptions, policyexample.OptionStore(store))
+	}
+	if config.Audit {
+		zap.L().Warn("Audit mode - reject rules only log the traffic")
+		policyOptions = append(policyOptions, policyexample.OptionAudit())
+	}
+	if config.ComposeMode {
+		// The default policy only matches containers of the same compose project
+		policyOptions = append(policyOptions, policyexample.OptionDefaultScope(extractors.ComposeProjectTag))
+	}
+
+	policyEngine, err := policyexample.NewCustomPolicyResolver(ctrl, config.ParsedTriremeNetworks, config.PolicyFile, config.PolicyFallback, policyOptions...)
+	if err != nil {
+		zap.L().Fatal("Invalid policy file - use --policy-fallback to start with the default policy", zap.Error(err))
+	}
+
+	if flowLog != nil {
+		flowLog.SetPULookup(policyEngine)
+	}
+	if syslogSink != nil {
+		syslogSink.SetPULookup(policyEngine)
+	}
+	if ipfix != nil {
+		ipfix.SetPULookup(policyEngine)
+	}
+	aggregator.SetPULookup(policyEngine)
+	metricsCollector.SetPULookup(policyEngine)
+
+	// Catch SIGHUP before the PUs start coming in: its default action would
+	// kill the daemon. The reloads start once everything is running.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
 	// Initialize the monitors
 	monitorOptions = append(monitorOptions, monitor.OptionPolicyResolver(policyEngine))
@@ -116,16 +234,34 @@ func ProcessDaemon(config *configuration.Configuration) (err error) {
 	}
 
 	// Start all the go routines.
-	ctx, cancel := context.WithCancel(context.Background())
-
 	if err := ctrl.Run(ctx); err != nil {
 		zap.L().Fatal("Failed to start controller")
 	}
 
+	// Restore the PUs enforced before a restart
+	if err := policyEngine.Restore(ctx); err != nil {
+		zap.L().Error("Unable to restore state", zap.Error(err))
+	}
+
 	if err := m.Run(ctx); err != nil {
 		zap.L().Fatal("Failed to start monitor")
 	}
 
+	// Serve the management API
+	if config.APISocket != "" || config.APIAddress != "" {
+		startManagementAPI(ctx, config, policyEngine, aggregator)
+	}
+
+	// Serve the metrics
+	if config.MetricsAddress != "" {
+		if err := metrics.Serve(ctx, config.MetricsAddress); err != nil {
+			zap.L().Fatal("Unable to serve metrics", zap.Error(err))
+		}
+	}
+
+	// Reload the policy file on SIGHUP
+	go reloadOnSignal(ctx, reload, policyEngine)
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	zap.L().Info("Everything started. Waiting for Stop signal")
@@ -138,3 +274,140 @@ func ProcessDaemon(config *configuration.Configuration) (err error) {
 
 	return nil
 }
+
+// validateEnforcement rejects the combinations of enforcement settings that the
+// daemon cannot run with
+func validateEnforcement(config *configuration.Configuration) error {
+
+	if !config.DockerEnforcement && !config.LinuxProcessesEnforcement {
+		return fmt.Errorf("nothing to enforce: --docker and --linux-processes are both disabled")
+	}
+
+	if !config.DockerEnforcement && (config.SwarmMode || config.CustomExtractor != "" || config.KubernetesMode || config.ComposeMode) {
+		return fmt.Errorf("--swarm, --extractor, --kubernetes and --compose require --docker")
+	}
+
+	return nil
+}
+
+// dockerExtractor returns the Docker metadata extractor selected by the configuration,
+// or nil for the default one. Background tasks of the extractor stop with ctx.
+func dockerExtractor(ctx context.Context, config *configuration.Configuration) (func(*types.ContainerJSON) (*policy.PURuntime, error), error) {
+
+	selected := 0
+	for _, enabled := range []bool{config.SwarmMode, config.CustomExtractor != "", config.KubernetesMode, config.ComposeMode} {
+		if enabled {
+			selected++
+		}
+	}
+	if selected > 1 {
+		return nil, fmt.Errorf("only one of --swarm, --extractor, --kubernetes and --compose can be used")
+	}
+
+	switch {
+	case config.SwarmMode:
+		swarmExtractor, err := extractors.NewSwarmExtractor(config.SwarmCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		go swarmExtractor.Run(ctx)
+		return extractors.Instrument("swarm", swarmExtractor.Extract), nil
+	case config.ComposeMode:
+		return extractors.Instrument("compose", extractors.ComposeExtractor), nil
+	case config.CustomExtractor != "":
+		externalExtractor, err := extractors.NewExternalExtractor(config.CustomExtractor, config.ExtractorTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return extractors.Instrument("external", externalExtractor), nil
+	case config.KubernetesMode:
+		kubernetesExtractor, err := extractors.NewKubernetesExtractor(config.Kubeconfig, config.KubernetesCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		return extractors.Instrument("kubernetes", kubernetesExtractor.Extract), nil
+	default:
+		return nil, nil
+	}
+}
+
+// newSyslogWriter connects to the syslog server of the configuration. With
+// tls://, the server is verified with the configured CA or the system ones.
+func newSyslogWriter(config *configuration.Configuration) (*collectors.SyslogWriter, error) {
+
+	u, err := url.Parse(config.SyslogAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %s: %s", config.SyslogAddress, err)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: u.Hostname(),
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.SyslogCaCertPath != "" {
+		caPEM, rerr := ioutil.ReadFile(config.SyslogCaCertPath)
+		if rerr != nil {
+			return nil, fmt.Errorf("unable to load syslog CA: %s", rerr)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificate found in %s", config.SyslogCaCertPath)
+		}
+	}
+
+	return collectors.NewSyslogWriter(config.SyslogAddress, tlsConfig)
+}
+
+// startManagementAPI serves the management API on the unix socket and, if
+// configured, on TCP with mTLS
+func startManagementAPI(ctx context.Context, config *configuration.Configuration, policyEngine *policyexample.CustomPolicyResolver, aggregator *collectors.FlowAggregator) {
+
+	var tlsConfig *tls.Config
+	if config.APIAddress != "" {
+		var err error
+		tlsConfig, err = management.ServerTLSConfig(config.APICertPath, config.APIKeyPath, config.APICaCertPath)
+		if err != nil {
+			zap.L().Fatal("Unable to load management API certificates", zap.Error(err))
+		}
+	}
+
+	info := &management.DaemonInfo{
+		Version:  versions.VERSION,
+		Revision: versions.REVISION,
+		AuthType: config.Auth.String(),
+	}
+
+	server, err := management.NewServer(policyEngine, aggregator, info, config.APISocket, config.APIAddress, tlsConfig)
+	if err != nil {
+		zap.L().Fatal("Unable to create management API", zap.Error(err))
+	}
+
+	if err = server.Run(ctx); err != nil {
+		zap.L().Fatal("Unable to start management API", zap.Error(err))
+	}
+}
+
+// reloadOnSignal reloads the policies of the policy engine every time SIGHUP is received
+// on c. A policy file that cannot be loaded is rejected and the current policies are kept.
+func reloadOnSignal(ctx context.Context, c chan os.Signal, policyEngine *policyexample.CustomPolicyResolver) {
+
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			zap.L().Info("Reload signal received")
+			failed, err := policyEngine.Reload(ctx)
+			if err != nil {
+				zap.L().Error("Unable to reload policies - keeping current policies", zap.Error(err))
+				continue
+			}
+			if len(failed) > 0 {
+				zap.L().Error("Some PUs kept their previous policy", zap.Int("pus", len(failed)))
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package triremecli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aporeto-inc/trireme-example/collectors"
+	"github.com/aporeto-inc/trireme-example/configuration"
+	"github.com/aporeto-inc/trireme-example/management"
+)
+
+// ProcessFlows is called when trireme-example is called to show the flows
+// seen by the daemon
+func ProcessFlows(config *configuration.Configuration) (err error) {
+
+	output, _ := config.Arguments["--output"].(string)
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output format %s", output)
+	}
+
+	client, err := newManagementClient(config)
+	if err != nil {
+		return err
+	}
+
+	if top, ok := config.Arguments["top"].(bool); ok && top {
+		return topFlows(client, config, output)
+	}
+
+	return fmt.Errorf("unknown flows command")
+}
+
+// topFlows prints the top talkers and the top rejected flows of the daemon
+func topFlows(client *management.Client, config *configuration.Configuration, output string) error {
+
+	window, _ := config.Arguments["--window"].(time.Duration)
+	limit, _ := config.Arguments["--limit"].(int)
+
+	top, err := client.TopFlows(window, limit)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return printJSON(top)
+	}
+
+	fmt.Printf("Flows over the last %s (since %s)\n\n", top.Window, top.Since.Format("2006-01-02 15:04:05"))
+
+	fmt.Println("Top talkers:")
+	if err = printFlowAggregates(top.Talkers); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("Top rejected:")
+
+	return printFlowAggregates(top.Rejected)
+}
+
+// printFlowAggregates prints a table of aggregated flows
+func printFlowAggregates(aggregates []*collectors.FlowAggregate) error {
+
+	if len(aggregates) == 0 {
+		fmt.Println("  no flows")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tDESTINATION\tPORT\tACTION\tFLOWS") // nolint
+
+	for _, aggregate := range aggregates {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", aggregate.SourcePolicy, aggregate.DestinationPolicy, aggregate.Port, aggregate.Action, aggregate.Flows) // nolint
+	}
+
+	return w.Flush()
+}
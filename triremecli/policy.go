@@ -0,0 +1,403 @@
+package triremecli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aporeto-inc/trireme-example/configuration"
+	"github.com/aporeto-inc/trireme-example/policyexample"
+)
+
+// ProcessPolicy is called when trireme-example is called to manage policy files
+func ProcessPolicy(config *configuration.Configuration) (err error) {
+
+	if validate, ok := config.Arguments["validate"].(bool); ok && validate {
+		file := config.Arguments["<policyFile>"].(string)
+
+		if err = policyexample.ValidatePolicies(file); err != nil {
+			fmt.Println(err)
+			return fmt.Errorf("invalid policy file %s", file)
+		}
+
+		fmt.Printf("%s: OK\n", file)
+		return nil
+	}
+
+	if simulate, ok := config.Arguments["simulate"].(bool); ok && simulate {
+		return simulatePolicy(config)
+	}
+
+	if explain, ok := config.Arguments["explain"].(bool); ok && explain {
+		return explainPolicy(config)
+	}
+
+	if diff, ok := config.Arguments["diff"].(bool); ok && diff {
+		return diffPolicy(config)
+	}
+
+	if importK8s, ok := config.Arguments["import-k8s"].(bool); ok && importK8s {
+		return importKubernetesPolicies(config)
+	}
+
+	if graph, ok := config.Arguments["graph"].(bool); ok && graph {
+		return graphPolicy(config)
+	}
+
+	return fmt.Errorf("unknown policy command")
+}
+
+// simulatePolicy evaluates a policy file for a flow given on the command line
+func simulatePolicy(config *configuration.Configuration) error {
+
+	output, _ := config.Arguments["--output"].(string)
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output format %s", output)
+	}
+
+	src, err := policyexample.ParseSimulationEndpoint(config.Arguments["--src"].(string))
+	if err != nil {
+		return fmt.Errorf("invalid source: %s", err)
+	}
+
+	dst, err := policyexample.ParseSimulationEndpoint(config.Arguments["--dst"].(string))
+	if err != nil {
+		return fmt.Errorf("invalid destination: %s", err)
+	}
+
+	port, protocol := "", ""
+	if value := config.Arguments["--port"].(string); value != "" {
+		port, protocol, err = policyexample.ParsePortProtocol(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts := []policyexample.Option{}
+	if audit, ok := config.Arguments["--audit"].(bool); ok && audit {
+		opts = append(opts, policyexample.OptionAudit())
+	}
+
+	result, err := policyexample.Simulate(config.Arguments["--policy"].(string), src, dst, port, protocol, opts...)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return printJSON(result)
+	}
+
+	return printSimulation(result, src, dst)
+}
+
+// printSimulation prints the outcome of a simulated flow
+func printSimulation(result *policyexample.SimulationResult, src, dst *policyexample.SimulationEndpoint) error {
+
+	fmt.Printf("Source:       %s\n", describeSimulatedEnd(result.Source, src))
+	fmt.Printf("Destination:  %s\n", describeSimulatedEnd(result.Destination, dst))
+	switch {
+	case result.Protocol == "":
+		fmt.Println("Port:         any")
+	case !result.PortEvaluated:
+		fmt.Printf("Port:         %s/%s (not evaluated: the rules between PUs apply to all the ports)\n", result.Port, result.Protocol)
+	default:
+		fmt.Printf("Port:         %s/%s\n", result.Port, result.Protocol)
+	}
+	fmt.Println()
+
+	if err := printSteps(result.Steps); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("Result:       %s\n", strings.ToUpper(result.Decision))
+
+	return nil
+}
+
+// explainPolicy asks the daemon how the policies of two PUs apply to the
+// traffic between them
+func explainPolicy(config *configuration.Configuration) error {
+
+	output, _ := config.Arguments["--output"].(string)
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output format %s", output)
+	}
+
+	client, err := newManagementClient(config)
+	if err != nil {
+		return err
+	}
+
+	port, _ := config.Arguments["--port"].(string)
+	explanation, err := client.Explain(config.Arguments["<src>"].(string), config.Arguments["<dst>"].(string), port)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return printJSON(explanation)
+	}
+
+	fmt.Printf("Source:       %s\n", describeExplainedPU(explanation.Source))
+	fmt.Printf("Destination:  %s\n", describeExplainedPU(explanation.Destination))
+	if explanation.Port != "" {
+		fmt.Printf("Port:         %s/%s\n", explanation.Port, explanation.Protocol)
+	}
+	fmt.Println()
+
+	steps := []*policyexample.SimulationStep{explanation.Dependency, explanation.Exposure}
+	if err = printSteps(steps); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("Result:       %s\n", strings.ToUpper(explanation.Decision))
+
+	acls := []*policyexample.SimulationStep{}
+	for _, step := range []*policyexample.SimulationStep{explanation.ApplicationACL, explanation.NetworkACL} {
+		if step != nil {
+			acls = append(acls, step)
+		}
+	}
+	if len(acls) > 0 {
+		fmt.Println()
+		fmt.Println("ACLs matching the addresses of the PUs:")
+		return printSteps(acls)
+	}
+
+	return nil
+}
+
+// diffPolicy compares two policy files and, with --live, reports the PUs of
+// the daemon affected by the change
+func diffPolicy(config *configuration.Configuration) error {
+
+	output, _ := config.Arguments["--output"].(string)
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output format %s", output)
+	}
+
+	diff, err := policyexample.DiffPolicyFiles(config.Arguments["<oldPolicyFile>"].(string), config.Arguments["<newPolicyFile>"].(string))
+	if err != nil {
+		fmt.Println(err)
+		return fmt.Errorf("unable to compare the policy files")
+	}
+
+	var impacts []*policyexample.PUImpact
+	if live, ok := config.Arguments["--live"].(bool); ok && live {
+		if impacts, err = liveImpact(config, diff); err != nil {
+			return err
+		}
+	}
+
+	if output == "json" {
+		return printJSON(struct {
+			*policyexample.PolicyFileDiff
+			Impact []*policyexample.PUImpact `json:",omitempty"`
+		}{diff, impacts})
+	}
+
+	if diff.Empty() {
+		fmt.Println("No changes")
+	}
+
+	for _, policyDiff := range diff.Policies {
+		fmt.Printf("Policy %s: %s\n", policyDiff.Index, policyDiff.Change)
+		if err = printRuleChanges(policyDiff.Rules); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	if diff.DefaultPolicy {
+		fmt.Println("The default policy changed")
+	}
+	if diff.Bindings {
+		fmt.Println("The bindings changed")
+	}
+
+	if impacts == nil {
+		return nil
+	}
+
+	fmt.Println()
+	if len(impacts) == 0 {
+		fmt.Println("No enforced PU is affected")
+		return nil
+	}
+
+	fmt.Printf("Affected PUs: %d\n", len(impacts))
+	for _, impact := range impacts {
+		fmt.Println()
+		if impact.Error != "" {
+			fmt.Printf("%s %s: policy %s kept, %s\n", impact.ID, impact.Name, impact.PolicyIndex, impact.Error)
+			continue
+		}
+		if impact.NewPolicyIndex != impact.PolicyIndex {
+			fmt.Printf("%s %s: policy %s -> %s (selected by %s)\n", impact.ID, impact.Name, impact.PolicyIndex, impact.NewPolicyIndex, impact.Selection)
+		} else {
+			fmt.Printf("%s %s: policy %s changed\n", impact.ID, impact.Name, impact.PolicyIndex)
+		}
+		if len(impact.Rules) > 0 {
+			if err = printRuleChanges(impact.Rules); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// liveImpact returns the enforced PUs of the daemon affected by a change of
+// the policy file. The new policy of every PU is compared with the policy the
+// daemon enforces for it.
+func liveImpact(config *configuration.Configuration, diff *policyexample.PolicyFileDiff) ([]*policyexample.PUImpact, error) {
+
+	client, err := newManagementClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := client.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	pus, err := client.PUs()
+	if err != nil {
+		return nil, err
+	}
+
+	enforced := map[string]*policyexample.EnforcedPolicy{}
+	for _, pu := range pus {
+		if !pu.Enforced {
+			continue
+		}
+		puPolicy, perr := client.PUPolicy(pu.ID)
+		if perr != nil {
+			return nil, fmt.Errorf("unable to get the policy of PU %s: %s", pu.ID, perr)
+		}
+		enforced[pu.ID] = puPolicy
+	}
+
+	return diff.Impact(pus, enforced, status.Audit), nil
+}
+
+// importKubernetesPolicies prints the policy file converted from Kubernetes
+// NetworkPolicy manifests. The unsupported constructs go to stderr so that
+// the output can be redirected to a file.
+func importKubernetesPolicies(config *configuration.Configuration) error {
+
+	imported, err := policyexample.ImportNetworkPolicies(config.Arguments["<manifest>"].([]string))
+	if err != nil {
+		return err
+	}
+
+	for _, unsupported := range imported.Unsupported {
+		fmt.Fprintf(os.Stderr, "unsupported: %s\n", unsupported) // nolint
+	}
+
+	if strict, ok := config.Arguments["--strict"].(bool); ok && strict && len(imported.Unsupported) > 0 {
+		return fmt.Errorf("%d constructs cannot be converted", len(imported.Unsupported))
+	}
+
+	return printJSON(imported.Document())
+}
+
+// graphPolicy prints the graph of a policy file, with the PUs of the daemon
+// with --live
+func graphPolicy(config *configuration.Configuration) error {
+
+	output, _ := config.Arguments["--output"].(string)
+	if output != "dot" && output != "json" {
+		return fmt.Errorf("invalid output format %s", output)
+	}
+
+	var pus []*policyexample.PUStatus
+	if live, ok := config.Arguments["--live"].(bool); ok && live {
+		client, err := newManagementClient(config)
+		if err != nil {
+			return err
+		}
+		if pus, err = client.PUs(); err != nil {
+			return err
+		}
+	}
+
+	graph, err := policyexample.PolicyGraph(config.Arguments["<policyFile>"].(string), pus)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return printJSON(graph)
+	}
+
+	fmt.Print(graph.DOT())
+
+	return nil
+}
+
+// printRuleChanges prints a table of the rules changed in a policy
+func printRuleChanges(changes []*policyexample.RuleChange) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  RULES\tCHANGE\tRULE\tOLD\tNEW") // nolint
+
+	for _, change := range changes {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", change.Rules, change.Change, change.Rule, change.Old, change.New) // nolint
+	}
+
+	return w.Flush()
+}
+
+// printSteps prints a table of the rules evaluated for a flow
+func printSteps(steps []*policyexample.SimulationStep) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PU\tRULES\tDECISION\tRULE\tPOLICYID") // nolint
+
+	for _, step := range steps {
+		rule := "no rule matched"
+		if step.Index >= 0 {
+			rule = fmt.Sprintf("%s[%d] %s", step.Rules, step.Index, step.Rule)
+		}
+		decision := step.Decision
+		if step.Audit {
+			decision += " (audit: would deny)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", step.PU, step.Rules, decision, rule, step.PolicyID) // nolint
+	}
+
+	return w.Flush()
+}
+
+// describeExplainedPU returns a description of a PU of an explained flow
+func describeExplainedPU(pu *policyexample.ExplainedPU) string {
+
+	state := "enforced"
+	if !pu.Enforced {
+		state = string(pu.Event)
+	} else if pu.Audit {
+		state = "audit"
+	}
+
+	description := fmt.Sprintf("%s %s (policy %s, selected by %s, %s)", pu.ID, pu.Name, pu.PolicyIndex, pu.Selection, state)
+	if pu.IP != "" {
+		description += " " + pu.IP
+	}
+
+	return description
+}
+
+// describeSimulatedEnd returns a description of an end of a simulated flow
+func describeSimulatedEnd(pu *policyexample.SimulatedPU, end *policyexample.SimulationEndpoint) string {
+
+	if pu == nil {
+		return end.IP
+	}
+
+	return fmt.Sprintf("%s (policy %s, selected by %s)", strings.Join(pu.Tags, ","), pu.PolicyIndex, pu.Selection)
+}
@@ -0,0 +1,120 @@
+package triremecli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aporeto-inc/trireme-example/configuration"
+	"github.com/aporeto-inc/trireme-example/management"
+	"github.com/aporeto-inc/trireme-example/policyexample"
+)
+
+// ProcessStatus is called when trireme-example is called to show the status
+// of the daemon or to list the PUs it handles
+func ProcessStatus(config *configuration.Configuration) (err error) {
+
+	output, _ := config.Arguments["--output"].(string)
+	if output != "table" && output != "json" {
+		return fmt.Errorf("invalid output format %s", output)
+	}
+
+	client, err := newManagementClient(config)
+	if err != nil {
+		return err
+	}
+
+	pus, err := client.PUs()
+	if err != nil {
+		return err
+	}
+
+	if list, ok := config.Arguments["list"].(bool); ok && list {
+		if output == "json" {
+			return printJSON(pus)
+		}
+		return printPUs(pus)
+	}
+
+	status, err := client.Status()
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		return printJSON(struct {
+			*management.Status
+			PUList []*policyexample.PUStatus `json:"PUList"`
+		}{status, pus})
+	}
+
+	fmt.Printf("Version:          %s (%s)\n", status.Version, status.Revision)
+	fmt.Printf("Auth:             %s\n", status.AuthType)
+	fmt.Printf("Target networks:  %s\n", strings.Join(status.TriremeNetworks, ", "))
+	fmt.Printf("Policy file:      %s\n", status.PolicyFile)
+	fmt.Printf("Policies:         %s\n", strings.Join(status.Policies, ", "))
+	fmt.Printf("Bindings:         %d\n", status.Bindings)
+	fmt.Printf("Audit mode:       %t\n", status.Audit)
+	fmt.Printf("Last reload:      %s\n", status.LastReload.Format("2006-01-02 15:04:05"))
+	if status.LastReloadError != "" {
+		fmt.Printf("Reload error:     %s\n", status.LastReloadError)
+	}
+	for _, pu := range status.PUReloadErrors {
+		fmt.Printf("Not reloaded:     %s (%s): %s\n", pu.ID, pu.PolicyIndex, pu.Error)
+	}
+	fmt.Println()
+
+	return printPUs(pus)
+}
+
+// newManagementClient creates a client of the daemon management API
+func newManagementClient(config *configuration.Configuration) (*management.Client, error) {
+
+	if config.APIAddress == "" {
+		if config.APISocket == "" {
+			return nil, fmt.Errorf("either --api-socket or --api-address is required")
+		}
+		return management.NewClient(config.APISocket, "", nil), nil
+	}
+
+	tlsConfig, err := management.ClientTLSConfig(config.APICertPath, config.APIKeyPath, config.APICaCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return management.NewClient("", config.APIAddress, tlsConfig), nil
+}
+
+// printPUs prints a table of PUs
+func printPUs(pus []*policyexample.PUStatus) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tPOLICY\tSELECTED BY\tSTATE\tTAGS") // nolint
+
+	for _, pu := range pus {
+		state := "enforced"
+		if !pu.Enforced {
+			state = string(pu.Event)
+		} else if pu.Audit {
+			state = "audit"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", pu.ID, pu.Name, pu.Type, pu.PolicyIndex, pu.Selection, state, strings.Join(pu.Tags, ",")) // nolint
+	}
+
+	return w.Flush()
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(data))
+
+	return nil
+}